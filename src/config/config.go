@@ -14,7 +14,22 @@ import (
 type configurations struct {
 	AppEnv string `json:"app_env" koanf:"APP_ENV" valid:"required"`
 
-	JWTSecret string `json:"jwt_secret" koanf:"JWT_SECRET" valid:"required"`
+	JWTSecret          string `json:"jwt_secret" koanf:"JWT_SECRET" valid:"required"`
+	JWTPrivateKey      string `json:"jwt_private_key" koanf:"JWT_PRIVATE_KEY"`
+	JWTPrivateKeyFiles string `json:"jwt_private_key_files" koanf:"JWT_PRIVATE_KEY_FILES"`
+	JWTPublicKeyFiles  string `json:"jwt_public_key_files" koanf:"JWT_PUBLIC_KEY_FILES"`
+
+	OIDCIssuer          string `json:"oidc_issuer" koanf:"OIDC_ISSUER"`
+	OIDCClientID        string `json:"oidc_client_id" koanf:"OIDC_CLIENT_ID"`
+	OIDCClientSecret    string `json:"oidc_client_secret" koanf:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL     string `json:"oidc_redirect_url" koanf:"OIDC_REDIRECT_URL"`
+	OIDCScopes          string `json:"oidc_scopes" koanf:"OIDC_SCOPES"`
+	OIDCAllowedSubjects string `json:"oidc_allowed_subjects" koanf:"OIDC_ALLOWED_SUBJECTS"`
+	OIDCAllowedEmails   string `json:"oidc_allowed_emails" koanf:"OIDC_ALLOWED_EMAILS"`
+
+	WebAuthnRelyingPartyID   string `json:"webauthn_relying_party_id" koanf:"WEBAUTHN_RP_ID"`
+	WebAuthnRelyingPartyName string `json:"webauthn_relying_party_name" koanf:"WEBAUTHN_RP_NAME"`
+	WebAuthnOrigins          string `json:"webauthn_origins" koanf:"WEBAUTHN_ORIGINS"`
 
 	DBHosts    string `json:"db_hosts" koanf:"DB_HOSTS" valid:"required"`
 	DBUsername string `json:"db_username" koanf:"DB_USERNAME" valid:"required"`
@@ -22,13 +37,36 @@ type configurations struct {
 	DBPort     string `json:"db_port" koanf:"DB_PORT" valid:"required"`
 	DBName     string `json:"db_name" koanf:"DB_NAME" valid:"required"`
 
-	S3BucketName   string `json:"s3_bucket_name" koanf:"S3_BUCKET_NAME"`
-	S3BucketKey    string `json:"s3_bucket_key" koanf:"S3_BUCKET_KEY"`
-	S3BucketRegion string `json:"s3_bucket_region" koanf:"S3_BUCKET_REGION"`
-	S3ACL          string `json:"s3_ACL" koanf:"S3_ACL"`
-	S3BucketURL    string `json:"s3_bucket_url" koanf:"S3_BUCKET_URL"`
-	AWSAccessKey   string `json:"aws_access_key" koanf:"AWS_ACCESS_KEY_ID"`
-	AWSSecretKey   string `json:"aws_secret_key" koanf:"AWS_SECRET_ACCESS_KEY"`
+	StorageBackend   string `json:"storage_backend" koanf:"STORAGE_BACKEND" valid:"in(s3|local)"`
+	LocalStoragePath string `json:"local_storage_path" koanf:"LOCAL_STORAGE_PATH"`
+	LocalStorageURL  string `json:"local_storage_url" koanf:"LOCAL_STORAGE_URL"`
+
+	S3BucketName         string `json:"s3_bucket_name" koanf:"S3_BUCKET_NAME"`
+	S3BucketKey          string `json:"s3_bucket_key" koanf:"S3_BUCKET_KEY"`
+	S3BucketRegion       string `json:"s3_bucket_region" koanf:"S3_BUCKET_REGION"`
+	S3ACL                string `json:"s3_ACL" koanf:"S3_ACL"`
+	S3BucketURL          string `json:"s3_bucket_url" koanf:"S3_BUCKET_URL"`
+	S3Endpoint           string `json:"s3_endpoint" koanf:"S3_ENDPOINT"`
+	S3ForcePathStyle     bool   `json:"s3_force_path_style" koanf:"S3_FORCE_PATH_STYLE"`
+	S3InsecureSkipVerify bool   `json:"s3_insecure_skip_verify" koanf:"S3_INSECURE_SKIP_VERIFY"`
+	S3CABundleFile       string `json:"s3_ca_bundle_file" koanf:"S3_CA_BUNDLE_FILE"`
+	AWSAccessKey         string `json:"aws_access_key" koanf:"AWS_ACCESS_KEY_ID"`
+	AWSSecretKey         string `json:"aws_secret_key" koanf:"AWS_SECRET_ACCESS_KEY"`
+
+	ImageVariantWidths      string `json:"image_variant_widths" koanf:"IMAGE_VARIANT_WIDTHS"`
+	MaxImageUploadSize      int64  `json:"max_image_upload_size" koanf:"MAX_IMAGE_UPLOAD_SIZE"`
+	MaxCVUploadSize         int64  `json:"max_cv_upload_size" koanf:"MAX_CV_UPLOAD_SIZE"`
+	MaxAttachmentUploadSize int64  `json:"max_attachment_upload_size" koanf:"MAX_ATTACHMENT_UPLOAD_SIZE"`
+	KeepOriginalImages      bool   `json:"keep_original_images" koanf:"KEEP_ORIGINAL_IMAGES"`
+
+	ImageTransformSecret    string `json:"image_transform_secret" koanf:"IMAGE_TRANSFORM_SECRET"`
+	ImageTransformCacheSize int64  `json:"image_transform_cache_size" koanf:"IMAGE_TRANSFORM_CACHE_SIZE"`
+	ImageDecodeBudget       int    `json:"image_decode_budget" koanf:"IMAGE_DECODE_BUDGET"`
+
+	OrphanGCInterval    string `json:"orphan_gc_interval" koanf:"ORPHAN_GC_INTERVAL"`
+	OrphanGCGracePeriod string `json:"orphan_gc_grace_period" koanf:"ORPHAN_GC_GRACE_PERIOD"`
+	OrphanGCDelete      bool   `json:"orphan_gc_delete" koanf:"ORPHAN_GC_DELETE"`
+	TrashRetention      string `json:"trash_retention" koanf:"TRASH_RETENTION"`
 
 	GeoFileKey string `json:"geo_file_key" koanf:"GEO_FILE_KEY"`
 }
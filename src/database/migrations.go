@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrationLockID is the advisory lock serialising the migrations of the instances started at the same time
+const migrationLockID = 5_120_026
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migrate applies the migrations of the migrations directory which were not applied yet, in the order of their file
+// names. Each migration runs in its own transaction and is recorded in the schema_migrations table, so a failed
+// migration is retried on the next run. Returns the versions applied by this run.
+func Migrate(timeout time.Duration) (applied []string, err error) {
+	_, err = ExecuteQuery(
+		`CREATE TABLE IF NOT EXISTS schema_migrations
+				(
+					version    TEXT PRIMARY KEY,
+					applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+				);`,
+	)
+	if err != nil {
+		return
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(path.Base(name), ".sql")
+		migration, readErr := migrationFiles.ReadFile(name)
+		if readErr != nil {
+			return applied, readErr
+		}
+
+		wasApplied, applyErr := applyMigration(version, string(migration), timeout)
+		if applyErr != nil {
+			return applied, applyErr
+		}
+		if wasApplied {
+			applied = append(applied, version)
+		}
+	}
+	return
+}
+
+// applyMigration runs the migration unless it was applied before. The advisory lock makes the instances started
+// at the same time wait for each other, so each migration is applied once.
+func applyMigration(version, migration string, timeout time.Duration) (wasApplied bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tx, err := instance.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			wasApplied = false
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			wasApplied = false
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1);`, migrationLockID); err != nil {
+		return
+	}
+
+	var count int
+	if err = tx.GetContext(ctx, &count, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1;`, version); err != nil || count > 0 {
+		return
+	}
+
+	// without arguments the statements of the file are sent as a single simple query
+	if _, err = tx.ExecContext(ctx, migration); err != nil {
+		return
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1);`, version)
+	return err == nil, err
+}
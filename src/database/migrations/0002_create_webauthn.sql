@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+    id           TEXT PRIMARY KEY,
+    user_id      INT         NOT NULL DEFAULT 1 REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT        NOT NULL,
+    public_key   BYTEA       NOT NULL,
+    sign_count   BIGINT      NOT NULL DEFAULT 0,
+    aaguid       TEXT        NOT NULL DEFAULT '',
+    transports   TEXT[]      NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges
+(
+    challenge  TEXT PRIMARY KEY,
+    ceremony   TEXT        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
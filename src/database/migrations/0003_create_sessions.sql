@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id           TEXT PRIMARY KEY,
+    device       TEXT        NOT NULL,
+    browser      TEXT        NOT NULL,
+    os           TEXT        NOT NULL,
+    ip_address   TEXT        NOT NULL,
+    country      TEXT        NOT NULL,
+    country_code TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    revoked_at   TIMESTAMPTZ
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS pending_uploads
+(
+    id           TEXT PRIMARY KEY,
+    staging_key  TEXT        NOT NULL,
+    target       TEXT        NOT NULL,
+    target_name  TEXT        NOT NULL DEFAULT '',
+    content_type TEXT        NOT NULL,
+    max_size     BIGINT      NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMPTZ NOT NULL
+);
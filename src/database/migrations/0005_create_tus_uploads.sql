@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS tus_uploads
+(
+    id                TEXT PRIMARY KEY,
+    staging_key       TEXT        NOT NULL,
+    storage_upload_id TEXT        NOT NULL,
+    target            TEXT        NOT NULL,
+    target_name       TEXT        NOT NULL DEFAULT '',
+    metadata          TEXT        NOT NULL DEFAULT '',
+    length            BIGINT      NOT NULL,
+    stored_offset     BIGINT      NOT NULL DEFAULT 0,
+    parts             INTEGER     NOT NULL DEFAULT 0,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at        TIMESTAMPTZ NOT NULL
+);
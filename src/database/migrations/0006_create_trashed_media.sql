@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS trashed_media
+(
+    id               TEXT PRIMARY KEY,
+    image_url        TEXT        NOT NULL,
+    objects          JSONB       NOT NULL DEFAULT '[]',
+    image_references JSONB       NOT NULL DEFAULT '[]',
+    deleted_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    purge_at         TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS trashed_media_purge_at_idx ON trashed_media (purge_at);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS media_hashes
+(
+    sha256     TEXT PRIMARY KEY,
+    file_key   TEXT        NOT NULL,
+    image      JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS cv_versions
+(
+    id         TEXT PRIMARY KEY,
+    variant    TEXT        NOT NULL,
+    language   TEXT        NOT NULL DEFAULT '',
+    file_key   TEXT        NOT NULL,
+    size       BIGINT      NOT NULL DEFAULT 0,
+    is_current BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS cv_versions_variant_idx ON cv_versions (variant, language, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS cv_versions_current_idx ON cv_versions (variant, language) WHERE is_current;
+
+-- the CV uploaded before the versioning was always stored at the fixed key cv
+INSERT INTO cv_versions (id, variant, language, file_key, is_current)
+SELECT 'initial', 'default', '', 'cv', TRUE
+FROM users
+WHERE id = 1
+  AND COALESCE(cv_link, '') <> ''
+ON CONFLICT DO NOTHING;
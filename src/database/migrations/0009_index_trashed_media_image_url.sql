@@ -0,0 +1,2 @@
+-- the trashed references of a shared image find the trashed media holding its objects by the image URL
+CREATE INDEX IF NOT EXISTS trashed_media_image_url_idx ON trashed_media (image_url);
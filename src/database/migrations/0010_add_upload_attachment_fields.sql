@@ -0,0 +1,8 @@
+-- the attachments uploaded directly keep their title and file name until the upload is completed
+ALTER TABLE pending_uploads
+    ADD COLUMN IF NOT EXISTS title     TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS file_name TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE tus_uploads
+    ADD COLUMN IF NOT EXISTS title     TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS file_name TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- the pending OpenID Connect logins, bound to the browser by the id kept in a cookie
+CREATE TABLE IF NOT EXISTS oidc_logins
+(
+    id            TEXT PRIMARY KEY,
+    state         TEXT        NOT NULL,
+    nonce         TEXT        NOT NULL,
+    code_verifier TEXT        NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,2 @@
+-- the deletion of the last reference to an image drops its content hash entries by the key
+CREATE INDEX IF NOT EXISTS media_hashes_file_key_idx ON media_hashes (file_key);
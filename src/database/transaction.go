@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// Transaction runs the queries of a WithTransaction function, all bound to the timeout of the transaction
+type Transaction struct {
+	ctx context.Context
+	tx  *sqlx.Tx
+}
+
+// WithTransaction runs the function in a database transaction. The transaction is committed when the function
+// returns without an error and rolled back otherwise (including panics). Other side effects of the function, such
+// as storage operations, should be done last so a failure rolls the database changes back.
+func WithTransaction(timeout time.Duration, function func(transaction *Transaction) error) (err error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tx, err := instance.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			_ = tx.Rollback()
+			panic(recovered)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	err = function(&Transaction{ctx: ctx, tx: tx.Unsafe()})
+	return
+}
+
+// ExecuteNamedQuery executes queries such as INSERT, UPDATE or DELETE with named parameters in the transaction
+func (transaction *Transaction) ExecuteNamedQuery(query string, arg interface{}) (sql.Result, error) {
+	return transaction.tx.NamedExecContext(transaction.ctx, query, arg)
+}
+
+// GetSingleRecord selects a single record in the transaction
+func (transaction *Transaction) GetSingleRecord(destination interface{}, query string) error {
+	return transaction.tx.GetContext(transaction.ctx, destination, query)
+}
+
+// GetSingleRecordNamedQuery selects a single record from a named query in the transaction
+func (transaction *Transaction) GetSingleRecordNamedQuery(destination interface{}, query string, args interface{}) error {
+	namedStatement, err := transaction.tx.PrepareNamedContext(transaction.ctx, query)
+	if err != nil {
+		return err
+	}
+	defer namedStatement.Close()
+	return namedStatement.GetContext(transaction.ctx, destination, args)
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"portfolio-cms-server/database"
+	"time"
+)
+
+const (
+	ScopeContentWrite  = "content:write"
+	ScopeAnalyticsRead = "analytics:read"
+	ScopeFilesWrite    = "files:write"
+
+	apiKeyPrefix       = "pcms_"
+	apiKeyPrefixLength = 12
+)
+
+// ErrAPIKeyInvalid is returned for the API keys which are unknown, revoked or expired
+var ErrAPIKeyInvalid = errors.New("unknown, revoked or expired API key")
+
+var knownScopes = map[string]interface{}{
+	ScopeContentWrite:  nil,
+	ScopeAnalyticsRead: nil,
+	ScopeFilesWrite:    nil,
+}
+
+// CreateAPIKey validates the requested scopes and expiry, generates a new random API key and stores only its
+// SHA-256 hash. The plain key is returned once and can not be retrieved afterwards.
+func CreateAPIKey(request APIKeyRequestBody) (createdKey CreatedAPIKey, err error) {
+	if len(request.Scopes) == 0 {
+		return CreatedAPIKey{}, errors.New("invalid param scopes, at least one scope is required")
+	}
+
+	for _, scope := range request.Scopes {
+		if _, isKnown := knownScopes[scope]; !isKnown {
+			return CreatedAPIKey{}, fmt.Errorf("invalid param scopes, unknown scope %s", scope)
+		}
+	}
+
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		return CreatedAPIKey{}, errors.New("invalid param expiresAt, the expiry date should be in the future")
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err = rand.Read(randomBytes); err != nil {
+		return
+	}
+	plainKey := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	err = database.GetSingleRecordNamedQuery(
+		&createdKey.APIKey,
+		`INSERT INTO api_keys (name, prefix, key_hash, scopes, expires_at)
+				VALUES (:name, :prefix, :key_hash, :scopes, :expires_at)
+				RETURNING id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at;`,
+		map[string]interface{}{
+			"name":       request.Name,
+			"prefix":     plainKey[:apiKeyPrefixLength],
+			"key_hash":   hashAPIKey(plainKey),
+			"scopes":     pq.StringArray(request.Scopes),
+			"expires_at": request.ExpiresAt,
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	createdKey.Key = plainKey
+	return
+}
+
+// GetAPIKeys gets all API keys (including the revoked and expired ones) without their hashes
+func GetAPIKeys() (apiKeys []APIKey, err error) {
+	err = database.GetMultipleRecords(
+		&apiKeys,
+		`SELECT id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+				FROM api_keys
+				ORDER BY created_at DESC;`,
+	)
+	return
+}
+
+// RevokeAPIKey marks the API key as revoked. Revoked keys are kept for auditing but are no longer accepted.
+func RevokeAPIKey(id int) (apiKey APIKey, err error) {
+	err = database.GetSingleRecordNamedQuery(
+		&apiKey,
+		`UPDATE api_keys
+				SET revoked_at = COALESCE(revoked_at, NOW())
+				WHERE id = :id
+				RETURNING id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at;`,
+		map[string]interface{}{"id": id},
+	)
+	return
+}
+
+// AuthenticateAPIKey looks up an active (not revoked and not expired) API key by the hash of the provided plain key
+// and records the time it was last used
+func AuthenticateAPIKey(plainKey string) (apiKey APIKey, err error) {
+	err = database.GetSingleRecordNamedQuery(
+		&apiKey,
+		`UPDATE api_keys
+				SET last_used_at = NOW()
+				WHERE key_hash = :key_hash
+				  AND revoked_at IS NULL
+				  AND (expires_at IS NULL OR expires_at > NOW())
+				RETURNING id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at;`,
+		map[string]interface{}{"key_hash": hashAPIKey(plainKey)},
+	)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		err = ErrAPIKeyInvalid
+	}
+	return
+}
+
+// HasScopes checks if the API key is granted all the required scopes
+func (apiKey APIKey) HasScopes(requiredScopes ...string) bool {
+	granted := make(map[string]interface{}, len(apiKey.Scopes))
+	for _, scope := range apiKey.Scopes {
+		granted[scope] = nil
+	}
+
+	for _, scope := range requiredScopes {
+		if _, isGranted := granted[scope]; !isGranted {
+			return false
+		}
+	}
+	return true
+}
+
+func hashAPIKey(plainKey string) string {
+	hash := sha256.Sum256([]byte(plainKey))
+	return hex.EncodeToString(hash[:])
+}
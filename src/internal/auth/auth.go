@@ -1,13 +1,15 @@
 package auth
 
 import (
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
 	"golang.org/x/crypto/bcrypt"
 	"portfolio-cms-server/database"
-	"portfolio-cms-server/utils"
 )
 
-// Login accepts username and password validates it and if such user exists - returns JWT auth token
-func Login(loginData UserAuthData) (authToken string, err error) {
+// Login accepts username and password validates it and if such user exists - starts a new session for the
+// requesting client and returns JWT auth token bound to it
+func Login(db *geoip2.Reader, ctx *gin.Context, loginData UserAuthData) (authToken string, err error) {
 	userData := UserAuthData{}
 
 	err = database.GetSingleRecordNamedQuery(
@@ -23,5 +25,5 @@ func Login(loginData UserAuthData) (authToken string, err error) {
 		return
 	}
 
-	return utils.GenerateJWT()
+	return startSession(db, ctx)
 }
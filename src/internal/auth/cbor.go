@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// cborDecoder is a minimal CBOR (RFC 8949) decoder covering what WebAuthn authenticators produce - integers,
+// byte and text strings, arrays, maps, tags and simple values. Indefinite length items are not supported since
+// the CTAP2 canonical encoding does not allow them.
+type cborDecoder struct {
+	data   []byte
+	offset int
+}
+
+const cborMaxNesting = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes a single CBOR item and returns it along with the number of bytes it occupied. Maps are
+// decoded to map[interface{}]interface{} with int64 or string keys.
+func decodeCBOR(data []byte) (value interface{}, length int, err error) {
+	decoder := &cborDecoder{data: data}
+	value, err = decoder.decode(0)
+	return value, decoder.offset, err
+}
+
+func (decoder *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxNesting {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+
+	if decoder.offset >= len(decoder.data) {
+		return nil, errCBORTruncated
+	}
+	initialByte := decoder.data[decoder.offset]
+	decoder.offset++
+	majorType, additionalInfo := initialByte>>5, initialByte&0x1f
+
+	if majorType == 7 {
+		return decoder.decodeSimple(additionalInfo)
+	}
+
+	argument, err := decoder.readArgument(additionalInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	switch majorType {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return int64(argument), nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(argument), nil
+	case 2:
+		return decoder.readBytes(argument)
+	case 3:
+		text, err := decoder.readBytes(argument)
+		return string(text), err
+	case 4:
+		return decoder.decodeArray(argument, depth)
+	case 5:
+		return decoder.decodeMap(argument, depth)
+	case 6:
+		return decoder.decode(depth + 1)
+	}
+	return nil, fmt.Errorf("cbor: unsupported major type %d", majorType)
+}
+
+func (decoder *cborDecoder) readArgument(additionalInfo byte) (uint64, error) {
+	var size int
+	switch {
+	case additionalInfo < 24:
+		return uint64(additionalInfo), nil
+	case additionalInfo == 24:
+		size = 1
+	case additionalInfo == 25:
+		size = 2
+	case additionalInfo == 26:
+		size = 4
+	case additionalInfo == 27:
+		size = 8
+	default:
+		return 0, errors.New("cbor: indefinite length items are not supported")
+	}
+
+	if decoder.offset+size > len(decoder.data) {
+		return 0, errCBORTruncated
+	}
+
+	padded := make([]byte, 8)
+	copy(padded[8-size:], decoder.data[decoder.offset:decoder.offset+size])
+	decoder.offset += size
+	return binary.BigEndian.Uint64(padded), nil
+}
+
+func (decoder *cborDecoder) readBytes(length uint64) ([]byte, error) {
+	if length > uint64(len(decoder.data)-decoder.offset) {
+		return nil, errCBORTruncated
+	}
+	value := decoder.data[decoder.offset : decoder.offset+int(length)]
+	decoder.offset += int(length)
+	return value, nil
+}
+
+func (decoder *cborDecoder) decodeArray(length uint64, depth int) ([]interface{}, error) {
+	if length > uint64(len(decoder.data)-decoder.offset) {
+		return nil, errCBORTruncated
+	}
+
+	array := make([]interface{}, 0, length)
+	for i := uint64(0); i < length; i++ {
+		item, err := decoder.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, item)
+	}
+	return array, nil
+}
+
+func (decoder *cborDecoder) decodeMap(length uint64, depth int) (map[interface{}]interface{}, error) {
+	if length > uint64(len(decoder.data)-decoder.offset) {
+		return nil, errCBORTruncated
+	}
+
+	result := make(map[interface{}]interface{}, length)
+	for i := uint64(0); i < length; i++ {
+		key, err := decoder.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key.(type) {
+		case int64, string:
+		default:
+			return nil, errors.New("cbor: only integer and text map keys are supported")
+		}
+
+		value, err := decoder.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+func (decoder *cborDecoder) decodeSimple(additionalInfo byte) (interface{}, error) {
+	switch additionalInfo {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25, 26, 27:
+		sizes := map[byte]int{25: 2, 26: 4, 27: 8}
+		if decoder.offset+sizes[additionalInfo] > len(decoder.data) {
+			return nil, errCBORTruncated
+		}
+		decoder.offset += sizes[additionalInfo]
+		return nil, errors.New("cbor: floating point values are not supported")
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", additionalInfo)
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// cborPair keeps the order of the encoded map entries, as the CTAP2 canonical encoding sorts the keys
+type cborPair struct {
+	key, value interface{}
+}
+
+// encodeCBOR encodes the values the WebAuthn fixtures need - integers, byte and text strings, arrays and maps
+func encodeCBOR(value interface{}) []byte {
+	switch typedValue := value.(type) {
+	case int:
+		return encodeCBOR(int64(typedValue))
+	case int64:
+		if typedValue < 0 {
+			return cborHead(1, uint64(-1-typedValue))
+		}
+		return cborHead(0, uint64(typedValue))
+	case []byte:
+		return append(cborHead(2, uint64(len(typedValue))), typedValue...)
+	case string:
+		return append(cborHead(3, uint64(len(typedValue))), typedValue...)
+	case []interface{}:
+		encoded := cborHead(4, uint64(len(typedValue)))
+		for _, item := range typedValue {
+			encoded = append(encoded, encodeCBOR(item)...)
+		}
+		return encoded
+	case []cborPair:
+		encoded := cborHead(5, uint64(len(typedValue)))
+		for _, pair := range typedValue {
+			encoded = append(encoded, encodeCBOR(pair.key)...)
+			encoded = append(encoded, encodeCBOR(pair.value)...)
+		}
+		return encoded
+	}
+	panic("unsupported fixture value")
+}
+
+func cborHead(majorType byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{majorType<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{majorType<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{majorType<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{majorType<<5 | 26}, uint32(argument))
+	}
+	return binary.BigEndian.AppendUint64([]byte{majorType<<5 | 27}, argument)
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	testCases := []struct {
+		name   string
+		data   []byte
+		value  interface{}
+		length int
+	}{
+		{name: "small integer", data: []byte{0x17}, value: int64(23), length: 1},
+		{name: "one byte integer", data: []byte{0x18, 0x64}, value: int64(100), length: 2},
+		{name: "eight byte integer", data: []byte{0x1b, 0, 0, 0, 1, 0, 0, 0, 0}, value: int64(1 << 32), length: 9},
+		{name: "negative integer", data: []byte{0x38, 0x63}, value: int64(-100), length: 2},
+		{name: "COSE RS256 algorithm", data: []byte{0x39, 0x01, 0x00}, value: int64(-257), length: 3},
+		{name: "byte string", data: []byte{0x43, 1, 2, 3}, value: []byte{1, 2, 3}, length: 4},
+		{name: "text string", data: []byte{0x64, 'n', 'o', 'n', 'e'}, value: "none", length: 5},
+		{name: "array", data: []byte{0x82, 0x01, 0x20}, value: []interface{}{int64(1), int64(-1)}, length: 3},
+		{
+			name:   "map",
+			data:   []byte{0xa2, 0x01, 0x02, 0x63, 'f', 'm', 't', 0x40},
+			value:  map[interface{}]interface{}{int64(1): int64(2), "fmt": []byte{}},
+			length: 8,
+		},
+		{name: "tag", data: []byte{0xc2, 0x41, 0x01}, value: []byte{1}, length: 3},
+		{name: "simple values", data: []byte{0x83, 0xf4, 0xf5, 0xf6}, value: []interface{}{false, true, nil}, length: 4},
+		{name: "trailing data is left", data: []byte{0x01, 0x02}, value: int64(1), length: 1},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			value, length, err := decodeCBOR(testCase.data)
+			if err != nil {
+				t.Fatalf("expected the item to be decoded, got %s", err.Error())
+			}
+			if !reflect.DeepEqual(value, testCase.value) || length != testCase.length {
+				t.Fatalf("expected %#v of %d bytes, got %#v of %d bytes", testCase.value, testCase.length, value, length)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	deeplyNested := append(bytes.Repeat([]byte{0x81}, cborMaxNesting+1), 0x01)
+	deeplyTagged := append(bytes.Repeat([]byte{0xc6}, cborMaxNesting+1), 0x01)
+
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty input", data: []byte{}},
+		{name: "truncated argument", data: []byte{0x19, 0x01}},
+		{name: "truncated eight byte argument", data: []byte{0x1b, 0, 0, 0}},
+		{name: "truncated byte string", data: []byte{0x45, 1, 2}},
+		{name: "truncated text string", data: []byte{0x65, 'a'}},
+		{name: "truncated array", data: []byte{0x83, 0x01, 0x02}},
+		{name: "map without a value", data: []byte{0xa1, 0x01}},
+		{name: "truncated float", data: []byte{0xfb, 0, 0}},
+		{name: "indefinite byte string", data: []byte{0x5f, 0x41, 0x01, 0xff}},
+		{name: "indefinite text string", data: []byte{0x7f, 0x61, 'a', 0xff}},
+		{name: "indefinite array", data: []byte{0x9f, 0x01, 0xff}},
+		{name: "indefinite map", data: []byte{0xbf, 0x01, 0x02, 0xff}},
+		{name: "break outside an indefinite item", data: []byte{0xff}},
+		{name: "reserved additional information", data: []byte{0x1c}},
+		{name: "oversized byte string", data: []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{name: "oversized byte string within int", data: []byte{0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "oversized array", data: []byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{name: "oversized map", data: []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x02}},
+		{name: "integer overflow", data: []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "negative integer overflow", data: []byte{0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{name: "byte string map key", data: []byte{0xa1, 0x41, 0x01, 0x01}},
+		{name: "array map key", data: []byte{0xa1, 0x80, 0x01}},
+		{name: "float", data: []byte{0xf9, 0x3c, 0x00}},
+		{name: "nesting too deep", data: deeplyNested},
+		{name: "tags nested too deep", data: deeplyTagged},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if value, _, err := decodeCBOR(testCase.data); err == nil {
+				t.Fatalf("expected the input to be rejected, got %#v", value)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORAcceptsMaximumNesting(t *testing.T) {
+	nested := append(bytes.Repeat([]byte{0x81}, cborMaxNesting), 0x01)
+	if _, length, err := decodeCBOR(nested); err != nil || length != len(nested) {
+		t.Fatalf("expected %d nested arrays to be decoded, got %v", cborMaxNesting, err)
+	}
+}
+
+func TestEncodeCBORFixturesRoundTrip(t *testing.T) {
+	x := bytes.Repeat([]byte{1}, 32)
+	encoded := encodeCBOR([]cborPair{{1, 2}, {3, -7}, {-1, 1}, {-2, x}, {"fmt", "none"}})
+
+	value, length, err := decodeCBOR(encoded)
+	if err != nil || length != len(encoded) {
+		t.Fatalf("expected the fixture encoding to be decoded, got %v", err)
+	}
+	expected := map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(-7), int64(-1): int64(1), int64(-2): x, "fmt": "none"}
+	if !reflect.DeepEqual(value, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, value)
+	}
+}
@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/oschwald/geoip2-golang"
+	"net/http"
+	"net/url"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+type oidcProvider struct {
+	config          OIDCConfig
+	discovery       *oidcDiscovery
+	keys            map[string]interface{}
+	keysRefreshedAt time.Time
+	mutex           sync.Mutex
+}
+
+var (
+	ErrOIDCNotConfigured      = errors.New("OpenID Connect login is not configured")
+	ErrOIDCInvalidState       = errors.New("invalid or expired OpenID Connect login state")
+	ErrOIDCIdentityNotAllowed = errors.New("the OpenID Connect identity is not allowed to log in")
+
+	oidc           *oidcProvider
+	oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+	// storeOIDCLogin and consumeOIDCLogin persist the pending logins, so the callback can reach any instance. They
+	// are variables so the login flow can be exercised without a database.
+	storeOIDCLogin   = insertOIDCLogin
+	consumeOIDCLogin = deleteOIDCLogin
+)
+
+const (
+	// OIDCLoginLifetime is the time the user has to log in with the provider, the cookie binding the login to the
+	// browser expires along with it
+	OIDCLoginLifetime = 10 * time.Minute
+	// oidcKeyRefreshInterval limits the provider key fetches, so the tokens with unknown key ids can not be used to
+	// flood the provider with requests
+	oidcKeyRefreshInterval = time.Minute
+)
+
+// ConfigureOIDC enables the OpenID Connect login with the given provider. The provider discovery document and
+// signing keys are fetched lazily on the first login attempt. Only the providers issuing ID tokens are supported -
+// plain OAuth 2.0 providers such as GitHub are not.
+func ConfigureOIDC(config OIDCConfig) {
+	if len(config.Issuer) == 0 || len(config.ClientID) == 0 {
+		return
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	oidc = &oidcProvider{
+		config: config,
+		keys:   map[string]interface{}{},
+	}
+}
+
+// BeginOIDCLogin starts the authorization code flow with PKCE. It generates and stores the state, nonce and code
+// verifier of the login attempt and returns the provider authorization URL the user should be redirected to, along
+// with the id of the login which the caller has to bind to the browser.
+func BeginOIDCLogin() (authorizationURL, loginID string, err error) {
+	if oidc == nil {
+		return "", "", ErrOIDCNotConfigured
+	}
+
+	discovery, err := oidc.getDiscovery()
+	if err != nil {
+		return
+	}
+
+	login := pendingOIDCLogin{ExpiresAt: time.Now().Add(OIDCLoginLifetime)}
+	for _, value := range []*string{&login.ID, &login.State, &login.Nonce, &login.CodeVerifier} {
+		if *value, err = randomURLSafeString(32); err != nil {
+			return
+		}
+	}
+	codeChallenge := sha256.Sum256([]byte(login.CodeVerifier))
+
+	if err = storeOIDCLogin(login); err != nil {
+		return
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.config.ClientID},
+		"redirect_uri":          {oidc.config.RedirectURL},
+		"scope":                 {strings.Join(oidc.config.Scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(codeChallenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), login.ID, nil
+}
+
+// CompleteOIDCLogin finishes the authorization code flow - it checks the state against the login bound to the
+// browser, exchanges the code for tokens, validates the ID token against the provider keys and checks the identity
+// against the allow-list of subjects and emails. On success it starts a session and issues the same JWT as the
+// username and password login.
+func CompleteOIDCLogin(db *geoip2.Reader, ctx *gin.Context, loginID, state, code string) (authToken string, err error) {
+	if oidc == nil {
+		return "", ErrOIDCNotConfigured
+	}
+
+	login, err := consumeOIDCLogin(loginID, state)
+	if err != nil {
+		return
+	}
+
+	rawIDToken, err := oidc.exchangeCode(code, login.CodeVerifier)
+	if err != nil {
+		return
+	}
+
+	claims, err := oidc.validateIDToken(rawIDToken, login.Nonce)
+	if err != nil {
+		return
+	}
+
+	if !oidc.isAllowed(claims) {
+		return "", ErrOIDCIdentityNotAllowed
+	}
+
+	return startSession(db, ctx)
+}
+
+func (provider *oidcProvider) getDiscovery() (*oidcDiscovery, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	discoveryURL := strings.TrimSuffix(provider.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(discoveryURL, discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch the OpenID Connect discovery document - %s", err.Error())
+	}
+
+	if discovery.Issuer != provider.config.Issuer {
+		return nil, fmt.Errorf("the discovered issuer %s does not match the configured one", discovery.Issuer)
+	}
+
+	provider.discovery = discovery
+	return discovery, nil
+}
+
+func (provider *oidcProvider) exchangeCode(code, codeVerifier string) (string, error) {
+	discovery, err := provider.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	response, err := oidcHTTPClient.PostForm(discovery.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.config.RedirectURL},
+		"client_id":     {provider.config.ClientID},
+		"client_secret": {provider.config.ClientSecret},
+		"code_verifier": {codeVerifier},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange the OpenID Connect code - %s", err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to exchange the OpenID Connect code - provider responded with %d", response.StatusCode)
+	}
+
+	tokens := oidcTokenResponse{}
+	if err = json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+
+	if len(tokens.IDToken) == 0 {
+		return "", errors.New("the OpenID Connect provider did not return an ID token")
+	}
+	return tokens.IDToken, nil
+}
+
+func (provider *oidcProvider) validateIDToken(rawIDToken, nonce string) (*OIDCClaims, error) {
+	claims := &OIDCClaims{}
+
+	_, err := jwt.ParseWithClaims(
+		rawIDToken,
+		claims,
+		provider.resolveKey,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(provider.config.Issuer),
+		jwt.WithAudience(provider.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenID Connect ID token - %s", err.Error())
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid OpenID Connect ID token - nonce mismatch")
+	}
+	return claims, nil
+}
+
+// resolveKey finds the ID token verification key by its kid, refreshing the provider keys once if the kid is
+// unknown (the provider might have rotated its keys). The keys are refreshed at most once per refresh interval.
+func (provider *oidcProvider) resolveKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	provider.mutex.Lock()
+	key, found := provider.keys[keyID]
+	provider.mutex.Unlock()
+	if found {
+		return key, nil
+	}
+
+	if err := provider.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if key, found = provider.keys[keyID]; found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %s", keyID)
+}
+
+func (provider *oidcProvider) refreshKeys() error {
+	provider.mutex.Lock()
+	if time.Since(provider.keysRefreshedAt) < oidcKeyRefreshInterval {
+		provider.mutex.Unlock()
+		return nil
+	}
+	// the concurrent lookups of the unknown key ids wait for the interval instead of fetching the keys too
+	provider.keysRefreshedAt = time.Now()
+	provider.mutex.Unlock()
+
+	discovery, err := provider.getDiscovery()
+	if err != nil {
+		return err
+	}
+
+	jwks := utils.JWKS{}
+	if err = getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch the OpenID Connect provider keys - %s", err.Error())
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		if publicKey, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = publicKey
+		}
+	}
+
+	provider.mutex.Lock()
+	provider.keys = keys
+	provider.mutex.Unlock()
+	return nil
+}
+
+func (provider *oidcProvider) isAllowed(claims *OIDCClaims) bool {
+	for _, subject := range provider.config.AllowedSubjects {
+		if subject == claims.Subject {
+			return true
+		}
+	}
+
+	if !claims.EmailVerified || len(claims.Email) == 0 {
+		return false
+	}
+
+	for _, email := range provider.config.AllowedEmails {
+		if strings.EqualFold(email, claims.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func insertOIDCLogin(login pendingOIDCLogin) (err error) {
+	_, err = database.ExecuteQuery(`DELETE FROM oidc_logins WHERE expires_at < NOW();`)
+	if err != nil {
+		return
+	}
+
+	_, err = database.ExecuteNamedQuery(
+		`INSERT INTO oidc_logins (id, state, nonce, code_verifier, expires_at)
+				VALUES (:id, :state, :nonce, :code_verifier, :expires_at);`,
+		login,
+	)
+	return
+}
+
+// deleteOIDCLogin deletes the login so it can be completed only once, the state has to match the login of the
+// browser
+func deleteOIDCLogin(loginID, state string) (login pendingOIDCLogin, err error) {
+	err = database.GetSingleRecordNamedQuery(
+		&login,
+		`DELETE FROM oidc_logins
+				WHERE id = :id
+				  AND state = :state
+				  AND expires_at > NOW()
+				RETURNING id, state, nonce, code_verifier, expires_at;`,
+		map[string]interface{}{"id": loginID, "state": state},
+	)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return login, ErrOIDCInvalidState
+	}
+	return
+}
+
+func getJSON(url string, destination interface{}) error {
+	response, err := oidcHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(destination)
+}
+
+func randomURLSafeString(length int) (string, error) {
+	randomBytes := make([]byte, length)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
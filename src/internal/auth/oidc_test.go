@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"portfolio-cms-server/utils"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mockProvider struct {
+	server        *httptest.Server
+	key           *rsa.PrivateKey
+	codeChallenge string
+	nonce         string
+	subject       string
+	email         string
+	jwksRequests  atomic.Int32
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &mockProvider{key: key, subject: "github|42", email: "admin@example.com"}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(map[string]string{
+			"issuer":                 provider.server.URL,
+			"authorization_endpoint": provider.server.URL + "/authorize",
+			"token_endpoint":         provider.server.URL + "/token",
+			"jwks_uri":               provider.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(writer http.ResponseWriter, request *http.Request) {
+		provider.jwksRequests.Add(1)
+		_ = json.NewEncoder(writer).Encode(utils.JWKS{Keys: []utils.JWK{{
+			KeyType:   "RSA",
+			KeyID:     "mock-key",
+			Use:       "sig",
+			Algorithm: "RS256",
+			Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		verifierHash := sha256.Sum256([]byte(request.PostFormValue("code_verifier")))
+		if request.PostFormValue("code") != "valid-code" ||
+			base64.RawURLEncoding.EncodeToString(verifierHash[:]) != provider.codeChallenge {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, OIDCClaims{
+			Nonce:         provider.nonce,
+			Email:         provider.email,
+			EmailVerified: true,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    provider.server.URL,
+				Subject:   provider.subject,
+				Audience:  jwt.ClaimStrings{"cms-client"},
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+			},
+		})
+		idToken.Header["kid"] = "mock-key"
+		signedToken, _ := idToken.SignedString(key)
+
+		_ = json.NewEncoder(writer).Encode(map[string]string{"id_token": signedToken, "access_token": "access"})
+	})
+
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+// authorize mimics the user consenting on the provider page by remembering the PKCE challenge and nonce
+func (provider *mockProvider) authorize(t *testing.T, authorizationURL string) (state string) {
+	parsedURL, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsedURL.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected S256 code challenge method, got %s", query.Get("code_challenge_method"))
+	}
+
+	provider.codeChallenge = query.Get("code_challenge")
+	provider.nonce = query.Get("nonce")
+	return query.Get("state")
+}
+
+func configureMockOIDC(provider *mockProvider, allowedEmails []string) {
+	utils.GetJWTKey("test-secret")
+	storeSession = func(session Session) (Session, error) {
+		return session, nil
+	}
+
+	pendingLogins := map[string]pendingOIDCLogin{}
+	storeOIDCLogin = func(login pendingOIDCLogin) error {
+		pendingLogins[login.ID] = login
+		return nil
+	}
+	consumeOIDCLogin = func(loginID, state string) (pendingOIDCLogin, error) {
+		login, found := pendingLogins[loginID]
+		if !found || login.State != state || time.Now().After(login.ExpiresAt) {
+			return pendingOIDCLogin{}, ErrOIDCInvalidState
+		}
+		delete(pendingLogins, loginID)
+		return login, nil
+	}
+	ConfigureOIDC(OIDCConfig{
+		Issuer:        provider.server.URL,
+		ClientID:      "cms-client",
+		RedirectURL:   "http://localhost/callback",
+		AllowedEmails: allowedEmails,
+	})
+}
+
+func newCallbackContext() *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/auth/oidc/callback", nil)
+	ctx.Request.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	return ctx
+}
+
+func TestOIDCLoginIssuesJWTForAllowedIdentity(t *testing.T) {
+	provider := newMockProvider(t)
+	configureMockOIDC(provider, []string{"admin@example.com"})
+
+	authorizationURL, loginID, err := BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := provider.authorize(t, authorizationURL)
+
+	authToken, err := CompleteOIDCLogin(nil, newCallbackContext(), loginID, state, "valid-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, isValid, err := utils.ParseJWT(authToken)
+	if err != nil || !isValid || claims.Role != "administrator" {
+		t.Fatalf("expected a valid administrator token, got %v %v", claims, err)
+	}
+	if len(claims.ID) == 0 {
+		t.Fatal("expected the token to be bound to a session")
+	}
+}
+
+func TestOIDCLoginRejectsIdentityOutsideTheAllowList(t *testing.T) {
+	provider := newMockProvider(t)
+	configureMockOIDC(provider, []string{"someone-else@example.com"})
+
+	authorizationURL, loginID, err := BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := provider.authorize(t, authorizationURL)
+
+	if _, err = CompleteOIDCLogin(nil, newCallbackContext(), loginID, state, "valid-code"); !errors.Is(err, ErrOIDCIdentityNotAllowed) {
+		t.Fatalf("expected ErrOIDCIdentityNotAllowed, got %v", err)
+	}
+}
+
+func TestOIDCLoginRejectsUnknownAndReusedState(t *testing.T) {
+	provider := newMockProvider(t)
+	configureMockOIDC(provider, []string{"admin@example.com"})
+
+	if _, err := CompleteOIDCLogin(nil, newCallbackContext(), "unknown-login", "unknown-state", "valid-code"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("expected ErrOIDCInvalidState, got %v", err)
+	}
+
+	authorizationURL, loginID, err := BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := provider.authorize(t, authorizationURL)
+
+	if _, err = CompleteOIDCLogin(nil, newCallbackContext(), loginID, state, "valid-code"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CompleteOIDCLogin(nil, newCallbackContext(), loginID, state, "valid-code"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("expected ErrOIDCInvalidState on reuse, got %v", err)
+	}
+}
+
+func TestOIDCLoginRejectsNonceMismatch(t *testing.T) {
+	provider := newMockProvider(t)
+	configureMockOIDC(provider, []string{"admin@example.com"})
+
+	authorizationURL, loginID, err := BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := provider.authorize(t, authorizationURL)
+	provider.nonce = "replayed-nonce"
+
+	if _, err = CompleteOIDCLogin(nil, newCallbackContext(), loginID, state, "valid-code"); err == nil {
+		t.Fatal("expected the ID token with a foreign nonce to be rejected")
+	}
+}
+
+func TestOIDCKeyRefreshIsRateLimited(t *testing.T) {
+	provider := newMockProvider(t)
+	configureMockOIDC(provider, nil)
+
+	for _, keyID := range []string{"forged-1", "forged-2", "forged-3"} {
+		if _, err := oidc.resolveKey(&jwt.Token{Header: map[string]interface{}{"kid": keyID}}); err == nil {
+			t.Fatalf("expected the unknown key %s to be rejected", keyID)
+		}
+	}
+	if requests := provider.jwksRequests.Load(); requests != 1 {
+		t.Fatalf("expected a single provider key fetch, got %d", requests)
+	}
+
+	if _, err := oidc.resolveKey(&jwt.Token{Header: map[string]interface{}{"kid": "mock-key"}}); err != nil {
+		t.Fatalf("expected the fetched key to be resolved, got %s", err.Error())
+	}
+}
+
+func TestOIDCLoginRejectsStateOfAnotherBrowser(t *testing.T) {
+	provider := newMockProvider(t)
+	configureMockOIDC(provider, []string{"admin@example.com"})
+
+	authorizationURL, _, err := BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := provider.authorize(t, authorizationURL)
+
+	// the callback of the attacker's login opened in the browser of the victim, which started its own login
+	_, victimLoginID, err := BeginOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CompleteOIDCLogin(nil, newCallbackContext(), victimLoginID, state, "valid-code"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("expected ErrOIDCInvalidState, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mssola/user_agent"
+	"github.com/oschwald/geoip2-golang"
+	"net"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"time"
+)
+
+// sessionTouchInterval throttles the last seen updates, the sessions are still checked on every request
+const sessionTouchInterval = time.Minute
+
+var (
+	// ErrSessionInactive is returned for the sessions which were revoked, expired or never existed
+	ErrSessionInactive = errors.New("the session expired or was revoked")
+
+	// storeSession persists a new session, it is a variable so the login flows can be exercised without a database
+	storeSession = insertSession
+)
+
+// GetSessions gets all active (not revoked and not expired) sessions, marking the one the request is made from
+func GetSessions(currentSessionID string) (sessions []Session, err error) {
+	sessions = []Session{}
+	err = database.GetMultipleRecords(
+		&sessions,
+		`SELECT id, device, browser, os, ip_address, country, country_code, created_at, last_seen_at, expires_at
+				FROM sessions
+				WHERE revoked_at IS NULL AND expires_at > NOW()
+				ORDER BY last_seen_at DESC;`,
+	)
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+	return
+}
+
+// RevokeSession revokes an active session, the tokens issued for it are no longer accepted
+func RevokeSession(id string) (err error) {
+	var revokedID string
+	return database.GetSingleRecordNamedQuery(
+		&revokedID,
+		`UPDATE sessions
+				SET revoked_at = NOW()
+				WHERE id = :id AND revoked_at IS NULL AND expires_at > NOW()
+				RETURNING id;`,
+		map[string]interface{}{"id": id},
+	)
+}
+
+// TouchSession checks that the session is still active and records the time it was last seen, at most once per
+// touch interval. Returns ErrSessionInactive if the session is not active.
+func TouchSession(id string) (err error) {
+	var activeID string
+	err = database.GetSingleRecordNamedQuery(
+		&activeID,
+		`WITH active_session AS (SELECT id, last_seen_at
+								 FROM sessions
+								 WHERE id = :id AND revoked_at IS NULL AND expires_at > NOW()),
+					 touched_session AS (UPDATE sessions
+										 SET last_seen_at = NOW()
+										 WHERE id IN (SELECT id
+													  FROM active_session
+													  WHERE last_seen_at < NOW() - CAST(:touch_seconds AS INTEGER) * INTERVAL '1 second')
+										 RETURNING id)
+				SELECT id FROM active_session;`,
+		map[string]interface{}{"id": id, "touch_seconds": int(sessionTouchInterval.Seconds())},
+	)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return ErrSessionInactive
+	}
+	return
+}
+
+// startSession records a new session with the device, browser, ip and country of the client and issues a JWT
+// bound to it. All login flows end here.
+func startSession(db *geoip2.Reader, ctx *gin.Context) (authToken string, err error) {
+	var (
+		clientIP     = ctx.ClientIP()
+		userAgent    = user_agent.New(ctx.Request.UserAgent())
+		browser, ver = userAgent.Browser()
+		device       = "desktop"
+		country      = "unknown"
+		countryCode  = "unknown"
+	)
+
+	if userAgent.Bot() {
+		device = "bot"
+	} else if userAgent.Mobile() {
+		device = "mobile"
+	}
+
+	if db != nil {
+		if record, err := db.Country(net.ParseIP(clientIP)); err == nil && len(record.Country.IsoCode) > 0 {
+			country = record.Country.Names["en"]
+			countryCode = record.Country.IsoCode
+		}
+	}
+
+	sessionID, err := uuid.NewRandom()
+	if err != nil {
+		return
+	}
+
+	session, err := storeSession(Session{
+		ID:          sessionID.String(),
+		Device:      device,
+		Browser:     fmt.Sprintf("%s %s", browser, ver),
+		OS:          userAgent.OS(),
+		IPAddress:   clientIP,
+		Country:     country,
+		CountryCode: countryCode,
+		ExpiresAt:   time.Now().Add(utils.TokenLifetime),
+	})
+	if err != nil {
+		return
+	}
+
+	return utils.GenerateJWT(session.ID)
+}
+
+func insertSession(session Session) (storedSession Session, err error) {
+	err = database.GetSingleRecordNamedQuery(
+		&storedSession,
+		`INSERT INTO sessions (id, device, browser, os, ip_address, country, country_code, expires_at)
+				VALUES (:id, :device, :browser, :os, :ip_address, :country, :country_code, :expires_at)
+				RETURNING id, device, browser, os, ip_address, country, country_code, created_at, last_seen_at, expires_at;`,
+		session,
+	)
+	return
+}
@@ -1,6 +1,191 @@
 package auth
 
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lib/pq"
+	"time"
+)
+
 type UserAuthData struct {
 	Username string `db:"username" json:"username" valid:"required,minstringlength(3)"`
 	Password string `db:"password" json:"password" valid:"required,minstringlength(5)"`
 }
+
+type APIKey struct {
+	ID         int            `db:"id" json:"id"`
+	Name       string         `db:"name" json:"name"`
+	Prefix     string         `db:"prefix" json:"prefix"`
+	Scopes     pq.StringArray `db:"scopes" json:"scopes"`
+	ExpiresAt  *time.Time     `db:"expires_at" json:"expiresAt"`
+	LastUsedAt *time.Time     `db:"last_used_at" json:"lastUsedAt"`
+	RevokedAt  *time.Time     `db:"revoked_at" json:"revokedAt"`
+	CreatedAt  time.Time      `db:"created_at" json:"createdAt"`
+}
+
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyRequestBody struct {
+	Name      string     `json:"name" valid:"required,minstringlength(3)"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type OIDCConfig struct {
+	Issuer          string
+	ClientID        string
+	ClientSecret    string
+	RedirectURL     string
+	Scopes          []string
+	AllowedSubjects []string
+	AllowedEmails   []string
+}
+
+type OIDCClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	jwt.RegisteredClaims
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+}
+
+type pendingOIDCLogin struct {
+	ID           string    `db:"id"`
+	State        string    `db:"state"`
+	Nonce        string    `db:"nonce"`
+	CodeVerifier string    `db:"code_verifier"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+type WebAuthnConfig struct {
+	RelyingPartyID   string
+	RelyingPartyName string
+	Origins          []string
+}
+
+type WebAuthnCredential struct {
+	ID         string         `db:"id" json:"id"`
+	Name       string         `db:"name" json:"name"`
+	PublicKey  []byte         `db:"public_key" json:"-"`
+	SignCount  int64          `db:"sign_count" json:"signCount"`
+	AAGUID     string         `db:"aaguid" json:"aaguid"`
+	Transports pq.StringArray `db:"transports" json:"transports"`
+	CreatedAt  time.Time      `db:"created_at" json:"createdAt"`
+	LastUsedAt *time.Time     `db:"last_used_at" json:"lastUsedAt"`
+}
+
+type WebAuthnCreationOptions struct {
+	Challenge              string                         `json:"challenge"`
+	RelyingParty           webAuthnRelyingParty           `json:"rp"`
+	User                   webAuthnUser                   `json:"user"`
+	PubKeyCredParams       []webAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	ExcludeCredentials     []webAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection webAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation"`
+	Timeout                int                            `json:"timeout"`
+}
+
+type WebAuthnRequestOptions struct {
+	Challenge        string                         `json:"challenge"`
+	RelyingPartyID   string                         `json:"rpId"`
+	AllowCredentials []webAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+	Timeout          int                            `json:"timeout"`
+}
+
+type WebAuthnRegistrationRequestBody struct {
+	Name     string                      `json:"name" valid:"required"`
+	ID       string                      `json:"id" valid:"required"`
+	Response WebAuthnAttestationResponse `json:"response"`
+}
+
+type WebAuthnAttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON" valid:"required"`
+	AttestationObject string   `json:"attestationObject" valid:"required"`
+	Transports        []string `json:"transports"`
+}
+
+type WebAuthnAssertionRequestBody struct {
+	ID       string                    `json:"id" valid:"required"`
+	Response WebAuthnAssertionResponse `json:"response"`
+}
+
+type WebAuthnAssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON" valid:"required"`
+	AuthenticatorData string `json:"authenticatorData" valid:"required"`
+	Signature         string `json:"signature" valid:"required"`
+	UserHandle        string `json:"userHandle"`
+}
+
+type WebAuthnRenameRequestBody struct {
+	Name string `json:"name" valid:"required"`
+}
+
+type webAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type webAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name" db:"nickname"`
+	DisplayName string `json:"displayName"`
+}
+
+type webAuthnCredentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int    `json:"alg"`
+}
+
+type webAuthnCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type webAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type webAuthnAuthenticatorData struct {
+	relyingPartyIDHash []byte
+	flags              byte
+	signCount          uint32
+	aaguid             []byte
+	credentialID       []byte
+	publicKey          []byte
+}
+
+type Session struct {
+	ID          string    `db:"id" json:"id"`
+	Device      string    `db:"device" json:"device"`
+	Browser     string    `db:"browser" json:"browser"`
+	OS          string    `db:"os" json:"os"`
+	IPAddress   string    `db:"ip_address" json:"ipAddress"`
+	Country     string    `db:"country" json:"country"`
+	CountryCode string    `db:"country_code" json:"countryCode"`
+	CreatedAt   time.Time `db:"created_at" json:"createdAt"`
+	LastSeenAt  time.Time `db:"last_seen_at" json:"lastSeenAt"`
+	ExpiresAt   time.Time `db:"expires_at" json:"expiresAt"`
+	Current     bool      `db:"-" json:"current"`
+}
@@ -0,0 +1,415 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/lib/pq"
+	"github.com/oschwald/geoip2-golang"
+	"portfolio-cms-server/database"
+	"strings"
+	"time"
+)
+
+const (
+	webAuthnCeremonyRegistration = "webauthn.create"
+	webAuthnCeremonyLogin        = "webauthn.get"
+	webAuthnChallengeLifetime    = 5 * time.Minute
+	webAuthnUserHandle           = "1"
+
+	webAuthnFlagUserPresent            = 0x01
+	webAuthnFlagUserVerified           = 0x04
+	webAuthnFlagAttestedCredentialData = 0x40
+)
+
+var (
+	ErrWebAuthnNotConfigured      = errors.New("passkey authentication is not configured")
+	ErrWebAuthnVerificationFailed = errors.New("passkey verification failed")
+
+	webAuthn *WebAuthnConfig
+)
+
+// ConfigureWebAuthn enables the passkey registration and login with the given relying party and allowed origins
+func ConfigureWebAuthn(config WebAuthnConfig) {
+	if len(config.RelyingPartyID) == 0 || len(config.Origins) == 0 {
+		return
+	}
+
+	if len(config.RelyingPartyName) == 0 {
+		config.RelyingPartyName = config.RelyingPartyID
+	}
+	webAuthn = &config
+}
+
+// BeginWebAuthnRegistration stores a new registration challenge and returns the options the browser should pass
+// to navigator.credentials.create(). Already registered authenticators are excluded.
+func BeginWebAuthnRegistration() (options WebAuthnCreationOptions, err error) {
+	if webAuthn == nil {
+		return WebAuthnCreationOptions{}, ErrWebAuthnNotConfigured
+	}
+
+	user := webAuthnUser{}
+	err = database.GetSingleRecord(&user, `SELECT nickname FROM users WHERE id = 1;`)
+	if err != nil {
+		return
+	}
+	user.ID = base64.RawURLEncoding.EncodeToString([]byte(webAuthnUserHandle))
+	user.DisplayName = user.Name
+
+	challenge, err := createWebAuthnChallenge(webAuthnCeremonyRegistration)
+	if err != nil {
+		return
+	}
+
+	excludedCredentials, err := getCredentialDescriptors()
+	if err != nil {
+		return
+	}
+
+	return WebAuthnCreationOptions{
+		Challenge:    challenge,
+		RelyingParty: webAuthnRelyingParty{ID: webAuthn.RelyingPartyID, Name: webAuthn.RelyingPartyName},
+		User:         user,
+		PubKeyCredParams: []webAuthnCredentialParameter{
+			{Type: "public-key", Algorithm: coseAlgorithmES256},
+			{Type: "public-key", Algorithm: coseAlgorithmEdDSA},
+			{Type: "public-key", Algorithm: coseAlgorithmRS256},
+		},
+		ExcludeCredentials:     excludedCredentials,
+		AuthenticatorSelection: webAuthnAuthenticatorSelection{ResidentKey: "preferred", UserVerification: "required"},
+		Attestation:            "none",
+		Timeout:                int(webAuthnChallengeLifetime.Milliseconds()),
+	}, nil
+}
+
+// FinishWebAuthnRegistration verifies the attestation response of the authenticator against the stored challenge
+// and the relying party and stores the new credential public key with its initial sign counter.
+// Attestation statements are not verified since the registration requests "none" attestation.
+func FinishWebAuthnRegistration(request WebAuthnRegistrationRequestBody) (credential WebAuthnCredential, err error) {
+	if webAuthn == nil {
+		return WebAuthnCredential{}, ErrWebAuthnNotConfigured
+	}
+
+	clientDataJSON, err := decodeBase64URL(request.Response.ClientDataJSON)
+	if err != nil {
+		return WebAuthnCredential{}, webAuthnError("malformed client data")
+	}
+	if err = verifyClientData(clientDataJSON, webAuthnCeremonyRegistration); err != nil {
+		return
+	}
+
+	attestationObject, err := decodeBase64URL(request.Response.AttestationObject)
+	if err != nil {
+		return WebAuthnCredential{}, webAuthnError("malformed attestation object")
+	}
+
+	authenticatorData, err := parseAttestationObject(attestationObject, request.ID)
+	if err != nil {
+		return
+	}
+	credentialID := base64.RawURLEncoding.EncodeToString(authenticatorData.credentialID)
+
+	err = database.GetSingleRecordNamedQuery(
+		&credential,
+		`INSERT INTO webauthn_credentials (id, name, public_key, sign_count, aaguid, transports)
+				VALUES (:id, :name, :public_key, :sign_count, :aaguid, :transports)
+				RETURNING id, name, public_key, sign_count, aaguid, transports, created_at, last_used_at;`,
+		map[string]interface{}{
+			"id":         credentialID,
+			"name":       request.Name,
+			"public_key": authenticatorData.publicKey,
+			"sign_count": int64(authenticatorData.signCount),
+			"aaguid":     hex.EncodeToString(authenticatorData.aaguid),
+			"transports": pq.StringArray(request.Response.Transports),
+		},
+	)
+	return
+}
+
+// BeginWebAuthnLogin stores a new login challenge and returns the options the browser should pass to
+// navigator.credentials.get()
+func BeginWebAuthnLogin() (options WebAuthnRequestOptions, err error) {
+	if webAuthn == nil {
+		return WebAuthnRequestOptions{}, ErrWebAuthnNotConfigured
+	}
+
+	challenge, err := createWebAuthnChallenge(webAuthnCeremonyLogin)
+	if err != nil {
+		return
+	}
+
+	allowedCredentials, err := getCredentialDescriptors()
+	if err != nil {
+		return
+	}
+
+	return WebAuthnRequestOptions{
+		Challenge:        challenge,
+		RelyingPartyID:   webAuthn.RelyingPartyID,
+		AllowCredentials: allowedCredentials,
+		UserVerification: "required",
+		Timeout:          int(webAuthnChallengeLifetime.Milliseconds()),
+	}, nil
+}
+
+// FinishWebAuthnLogin verifies the assertion signature with the stored credential public key, checks the sign
+// counter for cloned authenticators and on success starts a session and issues the same JWT as the username and
+// password login
+func FinishWebAuthnLogin(db *geoip2.Reader, ctx *gin.Context, request WebAuthnAssertionRequestBody) (authToken string, err error) {
+	if webAuthn == nil {
+		return "", ErrWebAuthnNotConfigured
+	}
+
+	credential := WebAuthnCredential{}
+	err = database.GetSingleRecordNamedQuery(
+		&credential,
+		`SELECT id, name, public_key, sign_count, aaguid, transports, created_at, last_used_at
+				FROM webauthn_credentials
+				WHERE id = :id;`,
+		map[string]interface{}{"id": strings.TrimRight(request.ID, "=")},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return "", webAuthnError("unknown credential")
+		}
+		return
+	}
+
+	clientDataJSON, err := decodeBase64URL(request.Response.ClientDataJSON)
+	if err != nil {
+		return "", webAuthnError("malformed client data")
+	}
+	if err = verifyClientData(clientDataJSON, webAuthnCeremonyLogin); err != nil {
+		return
+	}
+
+	rawAuthenticatorData, err := decodeBase64URL(request.Response.AuthenticatorData)
+	if err != nil {
+		return "", webAuthnError("malformed authenticator data")
+	}
+	signature, err := decodeBase64URL(request.Response.Signature)
+	if err != nil {
+		return "", webAuthnError("malformed signature")
+	}
+
+	authenticatorData, err := verifyAssertion(credential, rawAuthenticatorData, clientDataJSON, signature)
+	if err != nil {
+		return
+	}
+
+	// the counter is compared again by the update, so of the concurrent assertions with the same counter only one
+	// passes. The authenticators without a counter always report zero.
+	result, err := database.ExecuteNamedQuery(
+		`UPDATE webauthn_credentials
+				SET sign_count = :sign_count, last_used_at = NOW()
+				WHERE id = :id
+				  AND (sign_count < :sign_count OR (sign_count = 0 AND CAST(:sign_count AS BIGINT) = 0));`,
+		map[string]interface{}{"id": credential.ID, "sign_count": int64(authenticatorData.signCount)},
+	)
+	if err != nil {
+		return
+	}
+	updatedRows, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if updatedRows == 0 {
+		return "", webAuthnError("the sign counter did not increase, the authenticator might be cloned")
+	}
+
+	return startSession(db, ctx)
+}
+
+// GetWebAuthnCredentials gets all registered authenticators
+func GetWebAuthnCredentials() (credentials []WebAuthnCredential, err error) {
+	err = database.GetMultipleRecords(
+		&credentials,
+		`SELECT id, name, public_key, sign_count, aaguid, transports, created_at, last_used_at
+				FROM webauthn_credentials
+				ORDER BY created_at;`,
+	)
+	return
+}
+
+// RenameWebAuthnCredential changes the display name of a registered authenticator
+func RenameWebAuthnCredential(id string, request WebAuthnRenameRequestBody) (credential WebAuthnCredential, err error) {
+	err = database.GetSingleRecordNamedQuery(
+		&credential,
+		`UPDATE webauthn_credentials
+				SET name = :name
+				WHERE id = :id
+				RETURNING id, name, public_key, sign_count, aaguid, transports, created_at, last_used_at;`,
+		map[string]interface{}{"id": id, "name": request.Name},
+	)
+	return
+}
+
+// DeleteWebAuthnCredential removes a registered authenticator so it can no longer be used for logging in
+func DeleteWebAuthnCredential(id string) (err error) {
+	var deletedID string
+	return database.GetSingleRecordNamedQuery(
+		&deletedID,
+		`DELETE FROM webauthn_credentials WHERE id = :id RETURNING id;`,
+		map[string]interface{}{"id": id},
+	)
+}
+
+func createWebAuthnChallenge(ceremony string) (challenge string, err error) {
+	challenge, err = randomURLSafeString(32)
+	if err != nil {
+		return
+	}
+
+	_, err = database.ExecuteQuery(`DELETE FROM webauthn_challenges WHERE expires_at < NOW();`)
+	if err != nil {
+		return
+	}
+
+	_, err = database.ExecuteNamedQuery(
+		`INSERT INTO webauthn_challenges (challenge, ceremony, expires_at) VALUES (:challenge, :ceremony, :expires_at);`,
+		map[string]interface{}{
+			"challenge":  challenge,
+			"ceremony":   ceremony,
+			"expires_at": time.Now().Add(webAuthnChallengeLifetime),
+		},
+	)
+	return
+}
+
+// consumeWebAuthnChallenge deletes the challenge so it can be used only once and reports if it was still valid
+func consumeWebAuthnChallenge(challenge, ceremony string) error {
+	var consumed string
+	err := database.GetSingleRecordNamedQuery(
+		&consumed,
+		`DELETE FROM webauthn_challenges
+				WHERE challenge = :challenge
+				  AND ceremony = :ceremony
+				  AND expires_at > NOW()
+				RETURNING challenge;`,
+		map[string]interface{}{"challenge": challenge, "ceremony": ceremony},
+	)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return webAuthnError("unknown or expired challenge")
+	}
+	return err
+}
+
+func getCredentialDescriptors() ([]webAuthnCredentialDescriptor, error) {
+	credentials, err := GetWebAuthnCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := []webAuthnCredentialDescriptor{}
+	for _, credential := range credentials {
+		descriptors = append(descriptors, webAuthnCredentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.ID,
+			Transports: credential.Transports,
+		})
+	}
+	return descriptors, nil
+}
+
+// parseAttestationObject decodes the attestation object of a registration, verifies its authenticator data and
+// the attested credential with the id the client sent
+func parseAttestationObject(attestationObject []byte, credentialID string) (authenticatorData webAuthnAuthenticatorData, err error) {
+	decodedAttestation, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return authenticatorData, webAuthnError("malformed attestation object - %s", err.Error())
+	}
+	attestation, _ := decodedAttestation.(map[interface{}]interface{})
+	rawAuthenticatorData, _ := attestation["authData"].([]byte)
+
+	if authenticatorData, err = parseAuthenticatorData(rawAuthenticatorData); err != nil {
+		return
+	}
+	if err = verifyAuthenticatorData(authenticatorData); err != nil {
+		return
+	}
+	if authenticatorData.flags&webAuthnFlagAttestedCredentialData == 0 {
+		return authenticatorData, webAuthnError("the authenticator data carries no credential")
+	}
+
+	if base64.RawURLEncoding.EncodeToString(authenticatorData.credentialID) != strings.TrimRight(credentialID, "=") {
+		return authenticatorData, webAuthnError("credential id mismatch")
+	}
+
+	_, _, err = parseCOSEKey(authenticatorData.publicKey)
+	return
+}
+
+// verifyAssertion verifies the authenticator data and the signature of a login assertion with the stored credential,
+// and that the sign counter increased
+func verifyAssertion(credential WebAuthnCredential, rawAuthenticatorData, clientDataJSON, signature []byte) (authenticatorData webAuthnAuthenticatorData, err error) {
+	if authenticatorData, err = parseAuthenticatorData(rawAuthenticatorData); err != nil {
+		return
+	}
+	if err = verifyAuthenticatorData(authenticatorData); err != nil {
+		return
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := append(append([]byte{}, rawAuthenticatorData...), clientDataHash[:]...)
+	if err = verifyCOSESignature(credential.PublicKey, signedData, signature); err != nil {
+		return
+	}
+
+	newSignCount := int64(authenticatorData.signCount)
+	if (newSignCount != 0 || credential.SignCount != 0) && newSignCount <= credential.SignCount {
+		return authenticatorData, webAuthnError("the sign counter did not increase, the authenticator might be cloned")
+	}
+	return
+}
+
+func verifyClientData(clientDataJSON []byte, ceremony string) error {
+	clientData := webAuthnClientData{}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return webAuthnError("malformed client data")
+	}
+
+	if clientData.Type != ceremony {
+		return webAuthnError("unexpected ceremony type %s", clientData.Type)
+	}
+
+	originIsAllowed := false
+	for _, origin := range webAuthn.Origins {
+		if origin == clientData.Origin {
+			originIsAllowed = true
+		}
+	}
+	if !originIsAllowed {
+		return webAuthnError("origin %s is not allowed", clientData.Origin)
+	}
+
+	return consumeWebAuthnChallenge(strings.TrimRight(clientData.Challenge, "="), ceremony)
+}
+
+func verifyAuthenticatorData(authenticatorData webAuthnAuthenticatorData) error {
+	relyingPartyIDHash := sha256.Sum256([]byte(webAuthn.RelyingPartyID))
+	if !bytes.Equal(authenticatorData.relyingPartyIDHash, relyingPartyIDHash[:]) {
+		return webAuthnError("relying party id mismatch")
+	}
+
+	if authenticatorData.flags&webAuthnFlagUserPresent == 0 {
+		return webAuthnError("the user was not present")
+	}
+	// the passkeys replace the password, so the possession of the authenticator alone is not enough
+	if authenticatorData.flags&webAuthnFlagUserVerified == 0 {
+		return webAuthnError("the user was not verified")
+	}
+	return nil
+}
+
+func webAuthnError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w - %s", ErrWebAuthnVerificationFailed, fmt.Sprintf(format, args...))
+}
+
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
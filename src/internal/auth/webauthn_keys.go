@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+const (
+	coseAlgorithmES256 = -7
+	coseAlgorithmEdDSA = -8
+	coseAlgorithmRS256 = -257
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// parseAuthenticatorData splits the binary authenticator data to the relying party id hash, flags, sign counter
+// and (when present) the attested credential data
+func parseAuthenticatorData(data []byte) (authenticatorData webAuthnAuthenticatorData, err error) {
+	if len(data) < 37 {
+		return authenticatorData, webAuthnError("authenticator data is too short")
+	}
+
+	authenticatorData.relyingPartyIDHash = data[:32]
+	authenticatorData.flags = data[32]
+	authenticatorData.signCount = binary.BigEndian.Uint32(data[33:37])
+
+	if authenticatorData.flags&webAuthnFlagAttestedCredentialData == 0 {
+		return authenticatorData, nil
+	}
+
+	if len(data) < 55 {
+		return authenticatorData, webAuthnError("attested credential data is too short")
+	}
+	authenticatorData.aaguid = data[37:53]
+
+	credentialIDLength := int(binary.BigEndian.Uint16(data[53:55]))
+	if len(data) < 55+credentialIDLength {
+		return authenticatorData, webAuthnError("attested credential data is too short")
+	}
+	authenticatorData.credentialID = data[55 : 55+credentialIDLength]
+
+	_, publicKeyLength, err := decodeCBOR(data[55+credentialIDLength:])
+	if err != nil {
+		return authenticatorData, webAuthnError("malformed credential public key - %s", err.Error())
+	}
+	authenticatorData.publicKey = data[55+credentialIDLength : 55+credentialIDLength+publicKeyLength]
+	return authenticatorData, nil
+}
+
+// parseCOSEKey converts a COSE encoded credential public key to a public key and its COSE algorithm.
+// Supported are ES256 (P-256), EdDSA (Ed25519) and RS256 keys.
+func parseCOSEKey(encodedKey []byte) (publicKey crypto.PublicKey, algorithm int64, err error) {
+	decodedKey, _, err := decodeCBOR(encodedKey)
+	if err != nil {
+		return nil, 0, webAuthnError("malformed credential public key - %s", err.Error())
+	}
+
+	key, isMap := decodedKey.(map[interface{}]interface{})
+	if !isMap {
+		return nil, 0, webAuthnError("malformed credential public key")
+	}
+
+	keyType, _ := key[int64(1)].(int64)
+	algorithm, _ = key[int64(3)].(int64)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == coseAlgorithmES256:
+		curve, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, webAuthnError("invalid ES256 credential public key")
+		}
+		ecdsaKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !ecdsaKey.Curve.IsOnCurve(ecdsaKey.X, ecdsaKey.Y) {
+			return nil, 0, webAuthnError("invalid ES256 credential public key")
+		}
+		return ecdsaKey, algorithm, nil
+	case keyType == coseKeyTypeOKP && algorithm == coseAlgorithmEdDSA:
+		curve, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, webAuthnError("invalid EdDSA credential public key")
+		}
+		return ed25519.PublicKey(x), algorithm, nil
+	case keyType == coseKeyTypeRSA && algorithm == coseAlgorithmRS256:
+		modulus, _ := key[int64(-1)].([]byte)
+		exponent, _ := key[int64(-2)].([]byte)
+		if len(modulus) < 256 || len(exponent) == 0 || len(exponent) > 4 {
+			return nil, 0, webAuthnError("invalid RS256 credential public key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, algorithm, nil
+	}
+	return nil, 0, webAuthnError("unsupported credential public key algorithm %d", algorithm)
+}
+
+func verifyCOSESignature(encodedKey, signedData, signature []byte) error {
+	publicKey, algorithm, err := parseCOSEKey(encodedKey)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(signedData)
+	isValid := false
+
+	switch algorithm {
+	case coseAlgorithmES256:
+		isValid = ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), hash[:], signature)
+	case coseAlgorithmEdDSA:
+		isValid = ed25519.Verify(publicKey.(ed25519.PublicKey), signedData, signature)
+	case coseAlgorithmRS256:
+		isValid = rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil
+	}
+
+	if !isValid {
+		return webAuthnError("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+const testRelyingPartyID = "cms.example.com"
+
+// testAuthenticator is a software authenticator producing the attestations and assertions of one algorithm
+type testAuthenticator struct {
+	name         string
+	credentialID []byte
+	coseKey      []byte
+	sign         func(signedData []byte) []byte
+}
+
+func newTestAuthenticators(t *testing.T) []testAuthenticator {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []testAuthenticator{
+		{
+			name:         "ES256",
+			credentialID: []byte("es256-credential"),
+			coseKey: encodeCBOR([]cborPair{
+				{1, coseKeyTypeEC2}, {3, coseAlgorithmES256}, {-1, coseCurveP256},
+				{-2, ecdsaKey.X.FillBytes(make([]byte, 32))}, {-3, ecdsaKey.Y.FillBytes(make([]byte, 32))},
+			}),
+			sign: func(signedData []byte) []byte {
+				hash := sha256.Sum256(signedData)
+				signature, _ := ecdsa.SignASN1(rand.Reader, ecdsaKey, hash[:])
+				return signature
+			},
+		},
+		{
+			name:         "EdDSA",
+			credentialID: []byte("eddsa-credential"),
+			coseKey:      encodeCBOR([]cborPair{{1, coseKeyTypeOKP}, {3, coseAlgorithmEdDSA}, {-1, coseCurveEd25519}, {-2, []byte(edPublicKey)}}),
+			sign: func(signedData []byte) []byte {
+				return ed25519.Sign(edPrivateKey, signedData)
+			},
+		},
+		{
+			name:         "RS256",
+			credentialID: []byte("rs256-credential"),
+			coseKey: encodeCBOR([]cborPair{
+				{1, coseKeyTypeRSA}, {3, coseAlgorithmRS256}, {-1, rsaKey.N.Bytes()}, {-2, big.NewInt(int64(rsaKey.E)).Bytes()},
+			}),
+			sign: func(signedData []byte) []byte {
+				hash := sha256.Sum256(signedData)
+				signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+				return signature
+			},
+		},
+	}
+}
+
+func configureTestWebAuthn(t *testing.T) {
+	previous := webAuthn
+	webAuthn = &WebAuthnConfig{RelyingPartyID: testRelyingPartyID, Origins: []string{"https://" + testRelyingPartyID}}
+	t.Cleanup(func() { webAuthn = previous })
+}
+
+func authenticatorDataFixture(relyingPartyID string, flags byte, signCount uint32, attestedCredential []byte) []byte {
+	relyingPartyIDHash := sha256.Sum256([]byte(relyingPartyID))
+	data := append(relyingPartyIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attestedCredential...)
+}
+
+func (authenticator testAuthenticator) attestedCredential() []byte {
+	data := bytes.Repeat([]byte{0xaa}, 16)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(authenticator.credentialID)))
+	data = append(data, authenticator.credentialID...)
+	return append(data, authenticator.coseKey...)
+}
+
+func (authenticator testAuthenticator) attestation(authenticatorData []byte) []byte {
+	return encodeCBOR([]cborPair{{"fmt", "none"}, {"attStmt", []cborPair{}}, {"authData", authenticatorData}})
+}
+
+func (authenticator testAuthenticator) id() string {
+	return base64.RawURLEncoding.EncodeToString(authenticator.credentialID)
+}
+
+func TestParseAttestationObject(t *testing.T) {
+	configureTestWebAuthn(t)
+
+	for _, authenticator := range newTestAuthenticators(t) {
+		t.Run(authenticator.name, func(t *testing.T) {
+			validData := authenticatorDataFixture(testRelyingPartyID, 0x45, 0, authenticator.attestedCredential())
+
+			authenticatorData, err := parseAttestationObject(authenticator.attestation(validData), authenticator.id())
+			if err != nil {
+				t.Fatalf("expected the attestation to be accepted, got %s", err.Error())
+			}
+			if !bytes.Equal(authenticatorData.publicKey, authenticator.coseKey) ||
+				!bytes.Equal(authenticatorData.credentialID, authenticator.credentialID) {
+				t.Fatal("expected the attested credential id and public key")
+			}
+
+			tamperedCases := []struct {
+				name              string
+				attestationObject []byte
+				credentialID      string
+			}{
+				{
+					name:              "other relying party",
+					attestationObject: authenticator.attestation(authenticatorDataFixture("evil.example.com", 0x45, 0, authenticator.attestedCredential())),
+				},
+				{
+					name:              "user not present",
+					attestationObject: authenticator.attestation(authenticatorDataFixture(testRelyingPartyID, 0x44, 0, authenticator.attestedCredential())),
+				},
+				{
+					name:              "user not verified",
+					attestationObject: authenticator.attestation(authenticatorDataFixture(testRelyingPartyID, 0x41, 0, authenticator.attestedCredential())),
+				},
+				{
+					name:              "no attested credential",
+					attestationObject: authenticator.attestation(authenticatorDataFixture(testRelyingPartyID, 0x05, 0, nil)),
+				},
+				{
+					name:              "credential id mismatch",
+					attestationObject: authenticator.attestation(validData),
+					credentialID:      base64.RawURLEncoding.EncodeToString([]byte("other-credential")),
+				},
+				{
+					name:              "truncated credential public key",
+					attestationObject: authenticator.attestation(validData[:len(validData)-4]),
+				},
+				{
+					name:              "truncated credential id",
+					attestationObject: authenticator.attestation(validData[:60]),
+				},
+				{
+					name:              "truncated authenticator data",
+					attestationObject: authenticator.attestation(validData[:36]),
+				},
+				{
+					name:              "missing authenticator data",
+					attestationObject: encodeCBOR([]cborPair{{"fmt", "none"}, {"attStmt", []cborPair{}}}),
+				},
+				{
+					name:              "not a map",
+					attestationObject: encodeCBOR([]interface{}{validData}),
+				},
+				{
+					name:              "truncated attestation object",
+					attestationObject: authenticator.attestation(validData)[:40],
+				},
+			}
+
+			for _, tamperedCase := range tamperedCases {
+				credentialID := tamperedCase.credentialID
+				if len(credentialID) == 0 {
+					credentialID = authenticator.id()
+				}
+
+				_, err = parseAttestationObject(tamperedCase.attestationObject, credentialID)
+				if !errors.Is(err, ErrWebAuthnVerificationFailed) {
+					t.Fatalf("expected the %s attestation to be rejected, got %v", tamperedCase.name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCOSEKeyRejectsInvalidKeys(t *testing.T) {
+	onCurveKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := onCurveKey.X.FillBytes(make([]byte, 32)), onCurveKey.Y.FillBytes(make([]byte, 32))
+	offCurveY := append([]byte{}, y...)
+	offCurveY[31] ^= 0x01
+
+	testCases := []struct {
+		name string
+		key  []byte
+	}{
+		{name: "ES256 point off the curve", key: encodeCBOR([]cborPair{{1, 2}, {3, -7}, {-1, 1}, {-2, x}, {-3, offCurveY}})},
+		{name: "ES256 other curve", key: encodeCBOR([]cborPair{{1, 2}, {3, -7}, {-1, 2}, {-2, x}, {-3, y}})},
+		{name: "ES256 short coordinate", key: encodeCBOR([]cborPair{{1, 2}, {3, -7}, {-1, 1}, {-2, x[1:]}, {-3, y}})},
+		{name: "ES256 key type mismatch", key: encodeCBOR([]cborPair{{1, 1}, {3, -7}, {-1, 1}, {-2, x}, {-3, y}})},
+		{name: "EdDSA short key", key: encodeCBOR([]cborPair{{1, 1}, {3, -8}, {-1, 6}, {-2, x[:31]}})},
+		{name: "EdDSA other curve", key: encodeCBOR([]cborPair{{1, 1}, {3, -8}, {-1, 7}, {-2, x}})},
+		{name: "RS256 short modulus", key: encodeCBOR([]cborPair{{1, 3}, {3, -257}, {-1, bytes.Repeat([]byte{0xff}, 128)}, {-2, []byte{1, 0, 1}}})},
+		{name: "RS256 long exponent", key: encodeCBOR([]cborPair{{1, 3}, {3, -257}, {-1, bytes.Repeat([]byte{0xff}, 256)}, {-2, bytes.Repeat([]byte{1}, 5)}})},
+		{name: "unsupported algorithm", key: encodeCBOR([]cborPair{{1, 2}, {3, -35}, {-1, 2}, {-2, x}, {-3, y}})},
+		{name: "not a map", key: encodeCBOR([]interface{}{1, 2})},
+		{name: "malformed", key: []byte{0xa5, 0x01}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, _, err := parseCOSEKey(testCase.key); !errors.Is(err, ErrWebAuthnVerificationFailed) {
+				t.Fatalf("expected the key to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	configureTestWebAuthn(t)
+	authenticators := newTestAuthenticators(t)
+
+	for index, authenticator := range authenticators {
+		t.Run(authenticator.name, func(t *testing.T) {
+			credential := WebAuthnCredential{ID: authenticator.id(), PublicKey: authenticator.coseKey, SignCount: 4}
+			clientDataJSON := []byte(`{"type":"webauthn.get","challenge":"Y2hhbGxlbmdl","origin":"https://cms.example.com"}`)
+
+			sign := func(signer testAuthenticator, authenticatorData, clientData []byte) []byte {
+				clientDataHash := sha256.Sum256(clientData)
+				return signer.sign(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+			}
+
+			validData := authenticatorDataFixture(testRelyingPartyID, 0x05, 5, nil)
+			validSignature := sign(authenticator, validData, clientDataJSON)
+
+			authenticatorData, err := verifyAssertion(credential, validData, clientDataJSON, validSignature)
+			if err != nil {
+				t.Fatalf("expected the assertion to be accepted, got %s", err.Error())
+			}
+			if authenticatorData.signCount != 5 {
+				t.Fatalf("expected the sign counter 5, got %d", authenticatorData.signCount)
+			}
+
+			withoutCounter := authenticatorDataFixture(testRelyingPartyID, 0x05, 0, nil)
+			credentialWithoutCounter := credential
+			credentialWithoutCounter.SignCount = 0
+			_, err = verifyAssertion(credentialWithoutCounter, withoutCounter, clientDataJSON, sign(authenticator, withoutCounter, clientDataJSON))
+			if err != nil {
+				t.Fatalf("expected the assertion of an authenticator without a counter to be accepted, got %s", err.Error())
+			}
+
+			tamperedCounter := authenticatorDataFixture(testRelyingPartyID, 0x05, 6, nil)
+			tamperedClientData := bytes.Replace(clientDataJSON, []byte("Y2hhbGxlbmdl"), []byte("b3RoZXI"), 1)
+			tamperedSignature := append([]byte{}, validSignature...)
+			tamperedSignature[len(tamperedSignature)/2] ^= 0x01
+			otherRelyingParty := authenticatorDataFixture("evil.example.com", 0x05, 5, nil)
+			userNotPresent := authenticatorDataFixture(testRelyingPartyID, 0x04, 5, nil)
+			userNotVerified := authenticatorDataFixture(testRelyingPartyID, 0x01, 5, nil)
+			replayedCounter := authenticatorDataFixture(testRelyingPartyID, 0x05, 4, nil)
+			otherAuthenticator := authenticators[(index+1)%len(authenticators)]
+
+			tamperedCases := []struct {
+				name              string
+				authenticatorData []byte
+				clientDataJSON    []byte
+				signature         []byte
+			}{
+				{name: "authenticator data changed after signing", authenticatorData: tamperedCounter, clientDataJSON: clientDataJSON, signature: validSignature},
+				{name: "client data changed after signing", authenticatorData: validData, clientDataJSON: tamperedClientData, signature: validSignature},
+				{name: "flipped signature bit", authenticatorData: validData, clientDataJSON: clientDataJSON, signature: tamperedSignature},
+				{name: "truncated signature", authenticatorData: validData, clientDataJSON: clientDataJSON, signature: validSignature[:len(validSignature)-1]},
+				{name: "empty signature", authenticatorData: validData, clientDataJSON: clientDataJSON, signature: []byte{}},
+				{name: "signed by another credential", authenticatorData: validData, clientDataJSON: clientDataJSON, signature: sign(otherAuthenticator, validData, clientDataJSON)},
+				{name: "other relying party", authenticatorData: otherRelyingParty, clientDataJSON: clientDataJSON, signature: sign(authenticator, otherRelyingParty, clientDataJSON)},
+				{name: "user not present", authenticatorData: userNotPresent, clientDataJSON: clientDataJSON, signature: sign(authenticator, userNotPresent, clientDataJSON)},
+				{name: "user not verified", authenticatorData: userNotVerified, clientDataJSON: clientDataJSON, signature: sign(authenticator, userNotVerified, clientDataJSON)},
+				{name: "sign counter not increased", authenticatorData: replayedCounter, clientDataJSON: clientDataJSON, signature: sign(authenticator, replayedCounter, clientDataJSON)},
+				{name: "counter reset to zero", authenticatorData: withoutCounter, clientDataJSON: clientDataJSON, signature: sign(authenticator, withoutCounter, clientDataJSON)},
+				{name: "truncated authenticator data", authenticatorData: validData[:36], clientDataJSON: clientDataJSON, signature: validSignature},
+			}
+
+			for _, tamperedCase := range tamperedCases {
+				_, err = verifyAssertion(credential, tamperedCase.authenticatorData, tamperedCase.clientDataJSON, tamperedCase.signature)
+				if !errors.Is(err, ErrWebAuthnVerificationFailed) {
+					t.Fatalf("expected the assertion with the %s to be rejected, got %v", tamperedCase.name, err)
+				}
+			}
+		})
+	}
+}
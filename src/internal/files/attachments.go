@@ -0,0 +1,170 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mime/multipart"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+)
+
+// UploadAttachment takes a form data video or document and streams it to the storage as an attachment of the
+// project or the job. The duration and dimensions of the videos are read from their container headers, along with
+// the cover art which is stored as the poster frame - the videos without them are attached without the metadata.
+// Returns the attachments of the project or job. (along with the newly created)
+func UploadAttachment(file *multipart.FileHeader, request AttachmentRequestBody) (attachments json.RawMessage, err error) {
+	collection, targetName, err := attachmentTarget(request)
+	if err != nil {
+		return
+	}
+	if err = checkUploadTarget(collection, targetName); err != nil {
+		return
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	details := Attachment{Size: file.Size, FileName: file.Filename, Title: request.Title}
+	return saveAttachment(content, file.Header.Get("Content-Type"), collection, targetName, details)
+}
+
+// saveAttachment stores the attachment and appends it to the project or job, the size, file name and title are
+// taken from the given details. The stored objects are deleted if the attachment could not be added.
+func saveAttachment(content io.ReadSeeker, contentType, collection, targetName string, details Attachment) (attachments json.RawMessage, err error) {
+	randomId, _ := uuid.NewRandom()
+	fileKey := fmt.Sprintf("attachment-%s-%s-%s", attachmentKeyPrefix(collection), targetName, randomId.String())
+
+	attachment, storedKeys, err := storeAttachment(content, fileKey, contentType)
+	if err != nil {
+		return
+	}
+	attachment.Size, attachment.FileName, attachment.Title = details.Size, details.FileName, details.Title
+
+	attachments, err = addAttachment(collection, targetName, attachment)
+	if err != nil {
+		for _, storedKey := range storedKeys {
+			if deleteErr := utils.GetStorage().Delete(storedKey); deleteErr != nil {
+				utils.
+					GetLogger().
+					WithFields(log.Fields{"error": deleteErr.Error()}).
+					Errorf("Error on deleting the attachment %s", storedKey)
+			}
+		}
+	}
+	return
+}
+
+// attachmentTarget resolves the collection and the name of the project or job, exactly one of them is expected
+func attachmentTarget(request AttachmentRequestBody) (collection, targetName string, err error) {
+	switch {
+	case len(request.ProjectTitle) > 0 && len(request.CompanyName) > 0:
+		return "", "", fmt.Errorf("%w - expected either projectTitle or companyName", ErrInvalidUploadTarget)
+	case len(request.ProjectTitle) > 0:
+		return MediaCollectionProjects, request.ProjectTitle, nil
+	case len(request.CompanyName) > 0:
+		return MediaCollectionJobs, request.CompanyName, nil
+	default:
+		return "", "", fmt.Errorf("%w - expected projectTitle or companyName", ErrInvalidUploadTarget)
+	}
+}
+
+func attachmentKeyPrefix(collection string) string {
+	if collection == MediaCollectionJobs {
+		return "job"
+	}
+	return "project"
+}
+
+// storeAttachment probes the video metadata and stores the content with its poster frame, returning the keys of the
+// stored objects
+func storeAttachment(content io.ReadSeeker, fileKey, contentType string) (attachment Attachment, storedKeys []string, err error) {
+	var info mediaInfo
+	var probeErr error
+	switch contentType {
+	case "video/mp4":
+		info, probeErr = probeMP4(content)
+	case "video/webm":
+		info, probeErr = probeWebM(content)
+	}
+	if probeErr != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": probeErr.Error()}).
+			Warnf("Could not read the video metadata of %s", fileKey)
+		info = mediaInfo{}
+	}
+
+	if err = rewind(content); err != nil {
+		return
+	}
+	if err = utils.GetStorage().Put(fileKey, content, contentType); err != nil {
+		return
+	}
+	storedKeys = append(storedKeys, fileKey)
+
+	attachment = Attachment{
+		URL:      storageURL(fileKey),
+		MimeType: contentType,
+		Duration: info.Duration,
+		Width:    info.Width,
+		Height:   info.Height,
+	}
+
+	if len(info.Poster) > 0 {
+		posterKey := fileKey + "-poster"
+		if _, posterErr := putStrippedImage(bytes.NewReader(info.Poster), posterKey, info.PosterMediaType); posterErr != nil {
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": posterErr.Error()}).
+				Warnf("Could not store the poster frame of %s", fileKey)
+			_ = utils.GetStorage().Delete(posterKey)
+			return
+		}
+		storedKeys = append(storedKeys, posterKey)
+		attachment.PosterURL = storageURL(posterKey)
+	}
+	return
+}
+
+// addAttachment appends the attachment to the attachments of the project or job
+func addAttachment(collection, targetName string, attachment Attachment) (attachments json.RawMessage, err error) {
+	value, err := asJSONValue(attachment)
+	if err != nil {
+		return
+	}
+
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		collections, err := lockImageCollections(transaction)
+		if err != nil {
+			return
+		}
+
+		decoded, err := decodeCollections(collections)
+		if err != nil {
+			return
+		}
+		item, found := decoded.collectionItem(collection, targetName)
+		if !found {
+			return fmt.Errorf("%w - %s", ErrUploadTargetMissing, targetName)
+		}
+		item["attachments"] = append(asList(item["attachments"]), value)
+
+		if collections, err = decoded.encode(); err != nil {
+			return
+		}
+		if err = updateImageCollections(transaction, collections); err != nil {
+			return
+		}
+
+		attachments, err = json.Marshal(item["attachments"])
+		return
+	})
+	return
+}
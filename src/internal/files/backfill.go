@@ -0,0 +1,173 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"image"
+	"io"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+)
+
+// BackfillImagePlaceholders computes the placeholders of every image referenced in the projects, jobs, carousel
+// and partners collections which does not have them yet. The images are downloaded from the storage one by one,
+// the ones which fail are logged and skipped. The placeholders are merged in the end into the collections
+// re-read under the row lock, by the image URL, so the edits made while the images were processed are kept.
+func BackfillImagePlaceholders() (updatedImages int, err error) {
+	collections := imageCollections{}
+	err = database.GetSingleRecord(
+		&collections,
+		`SELECT COALESCE(projects, '[]') AS projects,
+					   COALESCE(jobs, '[]')     AS jobs,
+					   COALESCE(carousel, '[]') AS carousel,
+					   COALESCE(partners, '[]') AS partners
+				FROM users
+				WHERE id = 1;`,
+	)
+	if err != nil {
+		return
+	}
+
+	decoded, err := decodeCollections(collections)
+	if err != nil {
+		return
+	}
+	placeholders := computeMissingPlaceholders(decoded.images())
+	if len(placeholders) == 0 {
+		return
+	}
+
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		collections, err := lockImageCollections(transaction)
+		if err != nil {
+			return
+		}
+
+		decoded, err := decodeCollections(collections)
+		if err != nil {
+			return
+		}
+		updatedImages = mergePlaceholders(decoded.images(), placeholders)
+		if updatedImages == 0 {
+			return
+		}
+
+		if collections, err = decoded.encode(); err != nil {
+			return
+		}
+		return updateImageCollections(transaction, collections)
+	})
+	if err != nil {
+		updatedImages = 0
+	}
+	return
+}
+
+// computeMissingPlaceholders computes the placeholders of the images without them, once for each image URL
+func computeMissingPlaceholders(images []interface{}) (placeholders map[string]ImagePlaceholders) {
+	placeholders = map[string]ImagePlaceholders{}
+	skipped := map[string]bool{}
+
+	for _, entry := range images {
+		imageURL, missing := missingPlaceholders(entry)
+		if !missing || skipped[imageURL] {
+			continue
+		}
+		if _, computed := placeholders[imageURL]; computed {
+			continue
+		}
+
+		imagePlaceholders, err := computeStoredImagePlaceholders(imageURL)
+		if errors.Is(err, errVectorImage) {
+			skipped[imageURL] = true
+			continue
+		}
+		if err != nil {
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": err.Error()}).
+				Warnf("Skipping the placeholders of image - %s", imageURL)
+			skipped[imageURL] = true
+			continue
+		}
+		placeholders[imageURL] = imagePlaceholders
+	}
+	return
+}
+
+// mergePlaceholders sets the computed placeholders on the images which still do not have them
+func mergePlaceholders(images []interface{}, placeholders map[string]ImagePlaceholders) (updatedImages int) {
+	for _, entry := range images {
+		imageURL, missing := missingPlaceholders(entry)
+		imagePlaceholders, computed := placeholders[imageURL]
+		if !missing || !computed {
+			continue
+		}
+
+		imageObject := entry.(map[string]interface{})
+		imageObject["blurHash"] = imagePlaceholders.BlurHash
+		imageObject["lqip"] = imagePlaceholders.LQIP
+		imageObject["dominantColor"] = imagePlaceholders.DominantColor
+		updatedImages++
+	}
+	return
+}
+
+// missingPlaceholders gets the URL of the image when it is an image object without the placeholders
+func missingPlaceholders(entry interface{}) (imageURL string, missing bool) {
+	imageObject, isObject := entry.(map[string]interface{})
+	if !isObject {
+		return
+	}
+	if blurHash, _ := imageObject["blurHash"].(string); len(blurHash) > 0 {
+		return
+	}
+
+	imageURL, _ = imageObject["imgURL"].(string)
+	return imageURL, len(imageURL) > 0
+}
+
+// computeStoredImagePlaceholders decodes the stored image within the resolution limit and the decode budget shared
+// with the uploads and the transformations
+func computeStoredImagePlaceholders(imageURL string) (placeholders ImagePlaceholders, err error) {
+	fileKey, found := utils.GetStorage().KeyFromURL(imageURL)
+	if !found {
+		return placeholders, fmt.Errorf("%s is not a storage URL", imageURL)
+	}
+
+	content, info, err := utils.GetStorage().Get(fileKey)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	if info.ContentType == svgContentType {
+		return placeholders, errVectorImage
+	}
+
+	source, err := io.ReadAll(content)
+	if err != nil {
+		return
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return
+	}
+	if err = checkImageResolution(config); err != nil {
+		return
+	}
+
+	// the RGBA copy is held along with the decoded image, as on upload
+	budget := imageDecodes
+	weight := budget.acquire(2 * config.Width * config.Height)
+	defer budget.release(weight)
+
+	img, format, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return
+	}
+	return computePlaceholders(utils.ToRGBA(img), format)
+}
@@ -0,0 +1,79 @@
+package files
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/goccy/go-json"
+	"testing"
+)
+
+func TestMergePlaceholders(t *testing.T) {
+	// the collections re-read under the lock - an image was added and one was edited since the placeholders were
+	// computed, and the same image is referenced twice
+	collections := imageCollections{
+		Projects: json.RawMessage(`[{"title":"CMS","images":[{"imgURL":"a"},{"imgURL":"new"}]}]`),
+		Jobs:     json.RawMessage(`[{"company":"Acme","images":[{"imgURL":"a"}]}]`),
+		Carousel: json.RawMessage(`[{"imgURL":"b","blurHash":"edited"}]`),
+		Partners: json.RawMessage(`["c"]`),
+	}
+	placeholders := map[string]ImagePlaceholders{
+		"a": {BlurHash: "LKO2?U", LQIP: "data:image/jpeg;base64,", DominantColor: "#aabbcc"},
+		"b": {BlurHash: "computed"},
+		"c": {BlurHash: "computed"},
+	}
+
+	decoded, err := decodeCollections(collections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updatedImages := mergePlaceholders(decoded.images(), placeholders); updatedImages != 2 {
+		t.Fatalf("expected 2 updated images, got %d", updatedImages)
+	}
+
+	encoded, err := decoded.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := imageCollections{
+		Projects: json.RawMessage(`[{"images":[{"blurHash":"LKO2?U","dominantColor":"#aabbcc","imgURL":"a","lqip":"data:image/jpeg;base64,"},{"imgURL":"new"}],"title":"CMS"}]`),
+		Jobs:     json.RawMessage(`[{"company":"Acme","images":[{"blurHash":"LKO2?U","dominantColor":"#aabbcc","imgURL":"a","lqip":"data:image/jpeg;base64,"}]}]`),
+		Carousel: json.RawMessage(`[{"blurHash":"edited","imgURL":"b"}]`),
+		Partners: json.RawMessage(`["c"]`),
+	}
+	for _, collection := range []struct{ name, encoded, expected string }{
+		{"projects", string(encoded.Projects), string(expected.Projects)},
+		{"jobs", string(encoded.Jobs), string(expected.Jobs)},
+		{"carousel", string(encoded.Carousel), string(expected.Carousel)},
+		{"partners", string(encoded.Partners), string(expected.Partners)},
+	} {
+		if collection.encoded != collection.expected {
+			t.Fatalf("expected the %s %s, got %s", collection.name, collection.expected, collection.encoded)
+		}
+	}
+}
+
+func TestComputeStoredImagePlaceholdersChecksTheResolution(t *testing.T) {
+	useTestStorage(t)
+
+	// a PNG header announcing a resolution above the limit, the pixels are never decoded
+	ihdr := binary.BigEndian.AppendUint32(nil, 10_000)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 10_000)
+	oversizedPNG := append(append([]byte{}, pngSignature...), pngChunk("IHDR", append(ihdr, 8, 2, 0, 0, 0))...)
+	putTestObject(t, "project-oversized", string(oversizedPNG))
+
+	if _, err := computeStoredImagePlaceholders(testStorageURL + "/project-oversized"); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+
+	putTestObject(t, "project-small", string(encodeTestPNG(t, 4, 3)))
+	placeholders, err := computeStoredImagePlaceholders(testStorageURL + "/project-small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(placeholders.BlurHash) == 0 {
+		t.Fatal("expected the placeholders of the small image")
+	}
+	if imageDecodes.available != imageDecodes.capacity {
+		t.Errorf("expected the image to release its pixels, %d of %d are available", imageDecodes.available, imageDecodes.capacity)
+	}
+}
@@ -0,0 +1,299 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"mime/multipart"
+	"portfolio-cms-server/database"
+	"sync"
+)
+
+const (
+	// MaxBatchUploadFiles bounds the files of a batch upload, along with the request size
+	MaxBatchUploadFiles = 50
+	// batchUploadWorkers overlaps the hashing and uploads of the files, their decodes wait for the
+	// decode budget shared with the other uploads
+	batchUploadWorkers = 4
+)
+
+var (
+	ErrInvalidBatchUpload  = errors.New("invalid batch upload")
+	ErrUploadTargetMissing = errors.New("the project or job to upload to does not exist")
+
+	// uploadTargetCollections maps the image upload targets to the collections they add the images to
+	uploadTargetCollections = map[string]string{
+		UploadTargetProjectImage: MediaCollectionProjects,
+		UploadTargetJobImage:     MediaCollectionJobs,
+		UploadTargetCarousel:     MediaCollectionCarousel,
+		UploadTargetPartners:     MediaCollectionPartners,
+	}
+)
+
+// UploadImageBatch validates all the form data images (their content, size and metadata) before any of them is
+// stored - a single invalid file rejects the whole batch, so no objects are left behind for it. The images are then
+// stored concurrently, with a bounded number of workers and the decodes bounded by their pixels, and the stored ones
+// are added to the target collection in a single update - so the collection gets either all the stored images or
+// none. The metadata (a JSON array of the alt texts, captions, credits and focal points) matches the files by
+// position. The report has the outcome of every file in the order they were sent. If the update fails the stored
+// objects are left to the orphan collection, as they may be shared with the identical images uploaded before.
+func UploadImageBatch(request BatchUploadRequest, images []*multipart.FileHeader) (report BatchUploadReport, updatedCollections []UpdatedCollection, err error) {
+	if len(images) == 0 || len(images) > MaxBatchUploadFiles {
+		return report, nil, fmt.Errorf("%w - expected 1 to %d images", ErrInvalidBatchUpload, MaxBatchUploadFiles)
+	}
+
+	collection, found := uploadTargetCollections[request.Target]
+	if !found {
+		return report, nil, fmt.Errorf("%w - %s", ErrInvalidUploadTarget, request.Target)
+	}
+	targetName, err := uploadTargetName(request.Target, request.ProjectTitle, request.CompanyName)
+	if err != nil {
+		return
+	}
+
+	metadata := make([]ImageMetadata, len(images))
+	if len(request.Metadata) > 0 {
+		if err = json.Unmarshal([]byte(request.Metadata), &metadata); err != nil {
+			return report, nil, fmt.Errorf("%w - the metadata is not a JSON array", ErrInvalidBatchUpload)
+		}
+		if len(metadata) != len(images) {
+			return report, nil, fmt.Errorf("%w - expected the metadata of %d images", ErrInvalidBatchUpload, len(images))
+		}
+	}
+
+	if err = checkUploadTarget(collection, targetName); err != nil {
+		return
+	}
+
+	report.Results = make([]BatchUploadResult, len(images))
+	contentTypes := make([]string, len(images))
+	for index, file := range images {
+		report.Results[index] = BatchUploadResult{Index: index, FileName: file.Filename}
+		contentType, validationErr := validateBatchImage(file, metadata[index])
+		if validationErr != nil {
+			report.Results[index].Error = validationErr.Error()
+			report.Failed++
+			continue
+		}
+		contentTypes[index] = contentType
+	}
+	if report.Failed > 0 {
+		for index := range report.Results {
+			if len(report.Results[index].Error) == 0 {
+				report.Results[index].Error = "not uploaded, as the batch has invalid files"
+			}
+		}
+		report.Failed = len(images)
+		return
+	}
+
+	storedImages := make([]storedImage, len(images))
+	indexes := make(chan int)
+	workers := sync.WaitGroup{}
+
+	for worker := 0; worker < min(batchUploadWorkers, len(images)); worker++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				image, uploadErr := uploadBatchImage(images[index], contentTypes[index], metadata[index], request.Target, targetName)
+				if uploadErr != nil {
+					report.Results[index].Error = uploadErr.Error()
+					continue
+				}
+				storedImages[index] = image
+				report.Results[index].Uploaded, report.Results[index].Image = true, &storedImages[index].imageObject
+			}
+		}()
+	}
+	for index := range images {
+		indexes <- index
+	}
+	close(indexes)
+	workers.Wait()
+
+	for _, result := range report.Results {
+		if result.Uploaded {
+			report.Uploaded++
+		} else {
+			report.Failed++
+		}
+	}
+	if report.Uploaded == 0 {
+		return
+	}
+
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		collections, err := lockImageCollections(transaction)
+		if err != nil {
+			return
+		}
+
+		var addedImages []interface{}
+		for index := range report.Results {
+			if !report.Results[index].Uploaded {
+				continue
+			}
+			if err = claimBatchImage(transaction, images[index], &storedImages[index]); err != nil {
+				return
+			}
+
+			image, err := asJSONValue(storedImages[index].imageObject)
+			if err != nil {
+				return err
+			}
+			addedImages = append(addedImages, image)
+		}
+
+		decoded, err := decodeCollections(collections)
+		if err != nil {
+			return
+		}
+		if err = decoded.appendImages(collection, targetName, addedImages); err != nil {
+			return
+		}
+
+		if collections, err = decoded.encode(); err != nil {
+			return
+		}
+		if err = updateImageCollections(transaction, collections); err != nil {
+			return
+		}
+
+		updatedCollections, err = decoded.updatedCollections([]MediaReference{{Collection: collection, Name: targetName}})
+		return
+	})
+	return
+}
+
+// checkUploadTarget checks the project or job exists before the images are stored for it
+func checkUploadTarget(collection, targetName string) (err error) {
+	if collection != MediaCollectionProjects && collection != MediaCollectionJobs {
+		return
+	}
+
+	collections := imageCollections{}
+	err = database.GetSingleRecord(
+		&collections,
+		`SELECT COALESCE(projects, '[]') AS projects,
+					   COALESCE(jobs, '[]')     AS jobs,
+					   COALESCE(carousel, '[]') AS carousel,
+					   COALESCE(partners, '[]') AS partners,
+					   COALESCE(cv_link, '')    AS cv_link
+				FROM users
+				WHERE id = 1;`,
+	)
+	if err != nil {
+		return
+	}
+
+	decoded, err := decodeCollections(collections)
+	if err != nil {
+		return
+	}
+	if _, found := decoded.collectionItem(collection, targetName); !found {
+		return fmt.Errorf("%w - %s", ErrUploadTargetMissing, targetName)
+	}
+	return
+}
+
+// validateBatchImage checks the metadata, the size and the content of a file of the batch, before any image of the
+// batch is stored
+func validateBatchImage(file *multipart.FileHeader, metadata ImageMetadata) (contentType string, err error) {
+	if err = validateImageMetadata(metadata); err != nil {
+		return
+	}
+	return ValidateUpload(file, UploadTypeImage)
+}
+
+// uploadBatchImage stores a single validated image of the batch
+func uploadBatchImage(file *multipart.FileHeader, contentType string, metadata ImageMetadata, target, targetName string) (image storedImage, err error) {
+	content, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	if image, err = storeImage(content, batchFileKey(target, targetName), contentType); err != nil {
+		return
+	}
+	image.imageObject.ImageMetadata = metadata
+	return
+}
+
+// claimBatchImage claims the reused image of the batch file in the transaction adding the images, the file is
+// opened again only to store the image if it was trashed in the meantime
+func claimBatchImage(transaction *database.Transaction, file *multipart.FileHeader, image *storedImage) (err error) {
+	if len(image.reusedKey) == 0 {
+		return
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	return claimStoredImage(transaction, image, content)
+}
+
+// batchFileKey generates the key the same way as the single uploads to the target
+func batchFileKey(target, targetName string) string {
+	randomId, _ := uuid.NewRandom()
+
+	switch target {
+	case UploadTargetProjectImage:
+		return fmt.Sprintf("project-%s-%s", targetName, randomId.String())
+	case UploadTargetJobImage:
+		return fmt.Sprintf("job-%s-%s", targetName, randomId.String())
+	case UploadTargetPartners:
+		return fmt.Sprintf("partner-%s", randomId.String())
+	default:
+		return fmt.Sprintf("carousel-%s", randomId.String())
+	}
+}
+
+// appendImages adds the images to the end of the collection, or of the images of the project or job of the name
+func (decoded *decodedCollections) appendImages(collection, name string, images []interface{}) error {
+	switch collection {
+	case MediaCollectionCarousel:
+		decoded.Carousel = append(decoded.Carousel, images...)
+		return nil
+	case MediaCollectionPartners:
+		decoded.Partners = append(decoded.Partners, images...)
+		return nil
+	}
+
+	item, found := decoded.collectionItem(collection, name)
+	if !found {
+		return fmt.Errorf("%w - %s", ErrUploadTargetMissing, name)
+	}
+	item["images"] = append(asList(item["images"]), images...)
+	return nil
+}
+
+// collectionItem finds the project (by the title) or the job (by the company) of the name
+func (decoded decodedCollections) collectionItem(collection, name string) (map[string]interface{}, bool) {
+	items, nameField := decoded.Projects, "title"
+	if collection == MediaCollectionJobs {
+		items, nameField = decoded.Jobs, "company"
+	}
+
+	for _, item := range items {
+		if itemName, _ := item[nameField].(string); itemName == name {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// asJSONValue converts the image object (or the attachment) to the generic value of the decoded collections
+func asJSONValue(object interface{}) (value interface{}, err error) {
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(encoded, &value)
+	return
+}
@@ -0,0 +1,220 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"regexp"
+	"time"
+)
+
+// DefaultCVVariant is the variant of the CV linked from the users basic info (the cv_link)
+const DefaultCVVariant = "default"
+
+var (
+	ErrInvalidCVVariant = errors.New("invalid CV variant or language")
+	ErrCVNotFound       = errors.New("unknown CV version")
+
+	cvVariantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+)
+
+// saveCV stores the CV content as a new version of the variant and language, which becomes the current one. The
+// previous versions are kept so they can be rolled back to. Uploading the default variant without a language also
+// updates the CV link.
+func saveCV(content io.Reader, variant, language string) (version CVVersion, err error) {
+	if err = validateCVVariant(variant, language); err != nil {
+		return
+	}
+
+	versionID, err := uuid.NewRandom()
+	if err != nil {
+		return
+	}
+
+	fileKey := fmt.Sprintf("cv-%s-%s", variant, versionID.String())
+	if len(language) > 0 {
+		fileKey = fmt.Sprintf("cv-%s-%s-%s", variant, language, versionID.String())
+	}
+
+	if err = utils.GetStorage().Put(fileKey, content, "application/pdf"); err != nil {
+		return
+	}
+	info, err := utils.GetStorage().Stat(fileKey)
+	if err != nil {
+		return
+	}
+
+	version = CVVersion{
+		ID:        versionID.String(),
+		Variant:   variant,
+		Language:  language,
+		FileKey:   fileKey,
+		Size:      info.Size,
+		Current:   true,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		_, err = transaction.ExecuteNamedQuery(
+			`UPDATE cv_versions
+					SET is_current = FALSE
+					WHERE variant = :variant
+					  AND language = :language
+					  AND is_current;`,
+			map[string]interface{}{"variant": variant, "language": language},
+		)
+		if err != nil {
+			return
+		}
+
+		_, err = transaction.ExecuteNamedQuery(
+			`INSERT INTO cv_versions (id, variant, language, file_key, size, is_current, created_at)
+					VALUES (:id, :variant, :language, :file_key, :size, :is_current, :created_at);`,
+			version,
+		)
+		if err != nil {
+			return
+		}
+		return updateCVLink(transaction, version)
+	})
+	if err != nil {
+		_ = utils.GetStorage().Delete(fileKey)
+		return
+	}
+
+	version.URL = utils.GetStorage().PublicURL(fileKey)
+	return
+}
+
+// ListCVVersions lists the CV versions, newest first, optionally only the ones of the variant and language
+func ListCVVersions(variant, language string) (versions []CVVersion, err error) {
+	versions = []CVVersion{}
+	err = database.GetMultipleRecordsNamedQuery(
+		&versions,
+		`SELECT id, variant, language, file_key, size, is_current, created_at
+				FROM cv_versions
+				WHERE (CAST(:variant AS TEXT) = '' OR variant = :variant)
+				  AND (CAST(:language AS TEXT) = '' OR language = :language)
+				ORDER BY variant, language, created_at DESC;`,
+		map[string]interface{}{"variant": variant, "language": language},
+	)
+	if err != nil {
+		return
+	}
+
+	for index := range versions {
+		versions[index].URL = utils.GetStorage().PublicURL(versions[index].FileKey)
+	}
+	return
+}
+
+// SetCurrentCVVersion makes the version the current one of its variant and language, which rolls the CV back (or
+// forward) to it
+func SetCurrentCVVersion(versionID string) (version CVVersion, err error) {
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		err = transaction.GetSingleRecordNamedQuery(
+			&version,
+			`SELECT id, variant, language, file_key, size, is_current, created_at
+					FROM cv_versions
+					WHERE id = :id
+					FOR UPDATE;`,
+			map[string]interface{}{"id": versionID},
+		)
+		if err != nil {
+			if err.Error() == "sql: no rows in result set" {
+				err = ErrCVNotFound
+			}
+			return
+		}
+
+		_, err = transaction.ExecuteNamedQuery(
+			`UPDATE cv_versions
+					SET is_current = FALSE
+					WHERE variant = :variant
+					  AND language = :language
+					  AND is_current;`,
+			version,
+		)
+		if err != nil {
+			return
+		}
+
+		_, err = transaction.ExecuteNamedQuery(`UPDATE cv_versions SET is_current = TRUE WHERE id = :id;`, version)
+		if err != nil {
+			return
+		}
+
+		version.Current = true
+		return updateCVLink(transaction, version)
+	})
+	if err != nil {
+		return
+	}
+
+	version.URL = utils.GetStorage().PublicURL(version.FileKey)
+	return
+}
+
+// CurrentCVURL gets the URL of the current CV of the variant in the language. Without a current version in the
+// language, the version without a language is used, and without a language requested the most recent current
+// version of the variant in any language.
+func CurrentCVURL(variant, language string) (cvURL string, err error) {
+	if err = validateCVVariant(variant, language); err != nil {
+		return
+	}
+
+	var fileKey string
+	err = database.GetSingleRecordNamedQuery(
+		&fileKey,
+		`SELECT file_key
+				FROM cv_versions
+				WHERE variant = :variant
+				  AND is_current
+				  AND (language = :language OR language = '' OR CAST(:language AS TEXT) = '')
+				ORDER BY language = :language DESC, language = '' DESC, created_at DESC
+				LIMIT 1;`,
+		map[string]interface{}{"variant": variant, "language": language},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = ErrCVNotFound
+		}
+		return
+	}
+	return utils.GetStorage().PublicURL(fileKey), nil
+}
+
+// listAllCVVersions lists the stored CV versions of all variants, without their URLs
+func listAllCVVersions() (versions []CVVersion, err error) {
+	err = database.GetMultipleRecords(
+		&versions,
+		`SELECT id, variant, language, file_key, size, is_current, created_at FROM cv_versions;`,
+	)
+	return
+}
+
+// updateCVLink points the CV link of the basic info to the version, if it is the current default CV
+func updateCVLink(transaction *database.Transaction, version CVVersion) (err error) {
+	if version.Variant != DefaultCVVariant || len(version.Language) > 0 {
+		return
+	}
+
+	_, err = transaction.ExecuteNamedQuery(
+		`UPDATE users SET cv_link = :cv_link WHERE id = 1;`,
+		map[string]interface{}{"cv_link": utils.GetStorage().PublicURL(version.FileKey)},
+	)
+	return
+}
+
+func validateCVVariant(variant, language string) error {
+	if !cvVariantPattern.MatchString(variant) {
+		return fmt.Errorf("%w - the variant has to be up to 40 lowercase letters, digits and dashes", ErrInvalidCVVariant)
+	}
+	if len(language) > 0 && !languageTagPattern.MatchString(language) {
+		return fmt.Errorf("%w - %q is not a language tag", ErrInvalidCVVariant, language)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package files
+
+import "sync"
+
+// decodeBudget bounds the pixels decoded at the same time by the uploads and the transformations, as a decoded
+// image takes about 4 bytes per pixel. The decodes wait in order of arrival, so a large image is not starved by
+// the smaller ones, and a decode larger than the whole budget waits for all the others to finish.
+type decodeBudget struct {
+	mutex     sync.Mutex
+	released  *sync.Cond
+	capacity  int
+	available int
+	next      uint64
+	serving   uint64
+}
+
+var imageDecodes = newDecodeBudget(maxImagePixels)
+
+// ConfigureDecodeBudget sets the pixels which can be decoded at the same time, the default allows a single image of
+// the maximum resolution
+func ConfigureDecodeBudget(pixels int) {
+	if pixels > 0 {
+		imageDecodes = newDecodeBudget(pixels)
+	}
+}
+
+func newDecodeBudget(capacity int) *decodeBudget {
+	budget := &decodeBudget{capacity: capacity, available: capacity}
+	budget.released = sync.NewCond(&budget.mutex)
+	return budget
+}
+
+// acquire waits for its turn and for the pixels to be available, the returned weight has to be released
+func (budget *decodeBudget) acquire(pixels int) (weight int) {
+	weight = min(max(pixels, 1), budget.capacity)
+
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	ticket := budget.next
+	budget.next++
+	for ticket != budget.serving || budget.available < weight {
+		budget.released.Wait()
+	}
+
+	budget.available -= weight
+	budget.serving++
+	budget.released.Broadcast()
+	return
+}
+
+func (budget *decodeBudget) release(weight int) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	budget.available += weight
+	budget.released.Broadcast()
+}
@@ -0,0 +1,111 @@
+package files
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDecodeBudgetClampsTheWeight(t *testing.T) {
+	budget := newDecodeBudget(100)
+
+	testCases := []struct {
+		pixels int
+		weight int
+	}{
+		{pixels: 40, weight: 40},
+		{pixels: 0, weight: 1},
+		{pixels: 1000, weight: 100},
+	}
+
+	for _, testCase := range testCases {
+		weight := budget.acquire(testCase.pixels)
+		if weight != testCase.weight {
+			t.Errorf("expected %d pixels to weigh %d, got %d", testCase.pixels, testCase.weight, weight)
+		}
+		budget.release(weight)
+	}
+
+	if budget.available != budget.capacity {
+		t.Errorf("expected the whole budget of %d to be available, got %d", budget.capacity, budget.available)
+	}
+}
+
+func TestDecodeBudgetServesInOrder(t *testing.T) {
+	budget := newDecodeBudget(100)
+	held := budget.acquire(60)
+
+	first, second := make(chan int, 1), make(chan int, 1)
+	go func() {
+		first <- budget.acquire(60)
+	}()
+	waitForDecodes(t, budget, 2)
+	// the smaller decode fits in the budget left, but waits behind the larger one
+	go func() {
+		second <- budget.acquire(20)
+	}()
+	waitForDecodes(t, budget, 3)
+
+	select {
+	case weight := <-first:
+		t.Fatalf("expected the first decode to wait for the budget, got %d pixels", weight)
+	case weight := <-second:
+		t.Fatalf("expected the second decode to wait behind the first, got %d pixels", weight)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	budget.release(held)
+	if weight := <-first; weight != 60 {
+		t.Errorf("expected the first decode to get 60 pixels, got %d", weight)
+	}
+	if weight := <-second; weight != 20 {
+		t.Errorf("expected the second decode to get 20 pixels, got %d", weight)
+	}
+}
+
+// waitForDecodes waits until the budget handed out the given number of tickets
+func waitForDecodes(t *testing.T, budget *decodeBudget, tickets uint64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		budget.mutex.Lock()
+		next := budget.next
+		budget.mutex.Unlock()
+		if next >= tickets {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d decodes to be waiting", tickets)
+}
+
+func TestStoreNewImageWaitsForTheDecodeBudget(t *testing.T) {
+	useTestStorage(t)
+	previous := imageDecodes
+	imageDecodes = newDecodeBudget(maxImagePixels)
+	t.Cleanup(func() { imageDecodes = previous })
+
+	// a batch worker decoding an image holds the whole budget
+	held := imageDecodes.acquire(maxImagePixels)
+
+	content := encodeTestPNG(t, 4, 3)
+	stored := make(chan error, 1)
+	go func() {
+		_, err := storeNewImage(bytes.NewReader(content), "partner-budget", "image/png")
+		stored <- err
+	}()
+
+	select {
+	case err := <-stored:
+		t.Fatalf("expected the image to wait for the decode budget, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	imageDecodes.release(held)
+	if err := <-stored; err != nil {
+		t.Fatal(err)
+	}
+	if imageDecodes.available != imageDecodes.capacity {
+		t.Errorf("expected the image to release its pixels, %d of %d are available", imageDecodes.available, imageDecodes.capacity)
+	}
+}
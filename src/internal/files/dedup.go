@@ -0,0 +1,121 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/goccy/go-json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+)
+
+type storedImageHash struct {
+	FileKey string          `db:"file_key"`
+	Image   json.RawMessage `db:"image"`
+}
+
+// hashContent calculates the SHA-256 of the uploaded bytes
+func hashContent(content io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findStoredImage looks up the image object stored for the content hash, along with its key. The index entries of
+// the objects which were deleted since (e.g. by the orphan collection) are dropped, so the content is stored again.
+// The lookup is made before the collections are locked, the image is claimed by the transaction adding it.
+func findStoredImage(contentHash string) (imageObject ImageObject, fileKey string, found bool, err error) {
+	storedImage := storedImageHash{}
+	err = database.GetSingleRecordNamedQuery(
+		&storedImage,
+		`SELECT file_key, image FROM media_hashes WHERE sha256 = :sha256;`,
+		map[string]interface{}{"sha256": contentHash},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = nil
+		}
+		return
+	}
+
+	_, err = utils.GetStorage().Stat(storedImage.FileKey)
+	if errors.Is(err, utils.ErrObjectNotFound) {
+		_, err = database.ExecuteNamedQuery(
+			`DELETE FROM media_hashes WHERE sha256 = :sha256;`,
+			map[string]interface{}{"sha256": contentHash},
+		)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(storedImage.Image, &imageObject)
+	return imageObject, storedImage.FileKey, err == nil, err
+}
+
+// claimStoredImage checks the reused image is still stored, in the transaction which adds the reference to it while
+// holding the collections lock. The deletion of the last reference drops the index entry under the same lock, before
+// the objects are moved to the trash - so the image is still stored if its entry is, and the deletions which follow
+// count the added reference. An image trashed since it was looked up is stored again from the content.
+func claimStoredImage(transaction *database.Transaction, image *storedImage, content io.ReadSeeker) (err error) {
+	if len(image.reusedKey) == 0 {
+		return
+	}
+
+	var fileKey string
+	err = transaction.GetSingleRecordNamedQuery(
+		&fileKey,
+		`SELECT file_key FROM media_hashes WHERE sha256 = :sha256 AND file_key = :file_key;`,
+		map[string]interface{}{"sha256": image.contentHash, "file_key": image.reusedKey},
+	)
+	if err == nil || err.Error() != "sql: no rows in result set" {
+		return
+	}
+
+	if err = rewind(content); err != nil {
+		return
+	}
+	metadata := image.imageObject.ImageMetadata
+	if image.imageObject, err = storeNewImage(content, image.fileKey, image.contentType); err != nil {
+		return
+	}
+	image.imageObject.ImageMetadata, image.reusedKey = metadata, ""
+
+	rememberStoredImage(image.contentHash, image.fileKey, image.imageObject)
+	return
+}
+
+// forgetStoredImage drops the index entries of the image in the transaction deleting its last reference, so no
+// upload reuses the image while its objects are moved to the trash
+func forgetStoredImage(transaction *database.Transaction, fileKey string) (err error) {
+	_, err = transaction.ExecuteNamedQuery(
+		`DELETE FROM media_hashes WHERE file_key = :file_key;`,
+		map[string]interface{}{"file_key": fileKey},
+	)
+	return
+}
+
+// rememberStoredImage indexes the stored image by the content hash, a failure only means the next identical
+// upload is stored again
+func rememberStoredImage(contentHash, fileKey string, imageObject ImageObject) {
+	image, err := marshalImage(imageObject)
+	if err == nil {
+		_, err = database.ExecuteNamedQuery(
+			`INSERT INTO media_hashes (sha256, file_key, image)
+					VALUES (:sha256, :file_key, CAST(:image AS JSONB))
+					ON CONFLICT (sha256) DO UPDATE SET file_key = EXCLUDED.file_key, image = EXCLUDED.image;`,
+			map[string]interface{}{"sha256": contentHash, "file_key": fileKey, "image": image},
+		)
+	}
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Warnf("Failed to index the stored image %s by its content hash", fileKey)
+	}
+}
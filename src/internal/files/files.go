@@ -1,300 +1,268 @@
 package files
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"github.com/goccy/go-json"
 	"github.com/google/uuid"
-	"image"
+	log "github.com/sirupsen/logrus"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"mime/multipart"
 	"portfolio-cms-server/database"
 	"portfolio-cms-server/utils"
-	"strings"
 )
 
-// UploadCV takes a form data file, processes it and transforms it to a bytes reader with a file key and
-// content type of application/pdf and uploads it to the s3 bucket. When the file is uploaded it uses a
-// database function to determine if the database cv link should be updated or not and updates it if needed.
-func UploadCV(file *multipart.FileHeader) (fileURL string, err error) {
-	fileKey := "cv"
-	contentType := "application/pdf"
+// ErrNotStorageURL is returned for the URLs which are not served from the storage
+var ErrNotStorageURL = errors.New("the URL is not served from the storage")
 
-	fileContent, _ := file.Open()
-	buffer := make([]byte, file.Size)
-	_, _ = fileContent.Read(buffer)
-	fileBytes := bytes.NewReader(buffer)
-
-	err = utils.UploadToS3(fileBytes, fileKey, contentType)
+// UploadCV takes a form data file and streams it as a new version of the CV variant and language to the storage,
+// the new version becomes the current one.
+func UploadCV(file *multipart.FileHeader, variant, language string) (version CVVersion, err error) {
+	fileContent, err := file.Open()
 	if err != nil {
 		return
 	}
+	defer fileContent.Close()
 
-	fileURL = utils.GetTheFullS3BucketURL() + "/" + fileKey
-
-	_, err = database.ExecuteNamedQuery(
-		`SELECT set_user_cv_link_if_not_already_exist( :user_id, :URL )`,
-		map[string]interface{}{"URL": fileURL, "user_id": 1},
-	)
+	return saveCV(fileContent, variant, language)
+}
 
+// UploadProjectImage takes a form data file, generates a key and streams the image with its resized variants to
+// the storage. When the file is uploaded - inserts the image into the database and returns an array of existing
+// images for the given project. (along with the newly created)
+func UploadProjectImage(file *multipart.FileHeader, projectTitle string) (projectImages json.RawMessage, err error) {
+	fileContent, err := file.Open()
 	if err != nil {
 		return
 	}
-	return
+	defer fileContent.Close()
+
+	return saveProjectImage(fileContent, file.Header.Get("Content-Type"), projectTitle)
 }
 
-// UploadProjectImage takes a form data file, processes it and transforms it to a bytes reader, generates a key
-// and uploads the image to the s3 bucket. When the file is uploaded - inserts the image into the database and
-// returns an array of existing images for the given project. (along with the newly created)
-func UploadProjectImage(file *multipart.FileHeader, projectTitle string) (projectImages json.RawMessage, err error) {
+// saveProjectImage stores the image content with its variants and adds it to the project images
+func saveProjectImage(content io.ReadSeeker, contentType, projectTitle string) (projectImages json.RawMessage, err error) {
+	if err = checkUploadTarget(MediaCollectionProjects, projectTitle); err != nil {
+		return
+	}
+
 	randomId, _ := uuid.NewRandom()
 
 	fileKey := fmt.Sprintf("project-%s-%s", projectTitle, randomId.String())
-	contentType := file.Header.Get("Content-Type")
 
-	fileContent, _ := file.Open()
-	buffer := make([]byte, file.Size)
-	_, _ = fileContent.Read(buffer)
-	fileBytes := bytes.NewReader(buffer)
-
-	err = utils.UploadToS3(fileBytes, fileKey, contentType)
+	image, err := storeImage(content, fileKey, contentType)
 	if err != nil {
 		return
 	}
 
-	imageURL := utils.GetTheFullS3BucketURL() + "/" + fileKey
-
-	fileBytes2 := bytes.NewReader(buffer)
-	img, _, err := image.DecodeConfig(fileBytes2)
-	if err != nil {
-		return
-	}
+	return addImage(MediaCollectionProjects, projectTitle, &image, content)
+}
 
-	err = executeUploadProjectImageQuery(imageURL, projectTitle, img.Width, img.Height)
+// UploadJobImage takes a form data file, generates a key and streams the image with its resized variants to the
+// storage. When the file is uploaded - inserts the image into the database and returns an array of existing images
+// for the given job. (along with the newly created)
+func UploadJobImage(file *multipart.FileHeader, company string) (jobImages json.RawMessage, err error) {
+	fileContent, err := file.Open()
 	if err != nil {
 		return
 	}
+	defer fileContent.Close()
 
-	err = database.GetSingleRecordNamedQuery(
-		&projectImages,
-		`SELECT CAST(arr.object AS JSONB) -> 'images' AS project_images
-				FROM users,
-					 JSONB_ARRAY_ELEMENTS(projects) WITH ORDINALITY arr(object)
-				WHERE CAST(arr.object AS JSONB) ->> 'title' = :project_name;`,
-		map[string]interface{}{"project_name": projectTitle},
-	)
-
-	return
+	return saveJobImage(fileContent, file.Header.Get("Content-Type"), company)
 }
 
-func executeUploadProjectImageQuery(imageURL, projectTitle string, width, height int) error {
-	formattedImage := strings.ReplaceAll(imageURL, " ", "+")
-	_, err := database.ExecuteNamedQuery(
-		`WITH result_objects AS (SELECT arr.object AS object_result
-                        FROM users,
-                             JSONB_ARRAY_ELEMENTS(projects) WITH ORDINALITY arr(object)
-                        WHERE CAST(arr.object AS JSONB) ->> 'title' != :project_title)
-				UPDATE users
-				SET projects = (
-						(SELECT JSONB_AGG(object_result) FROM result_objects)
-						||
-						JSONB_SET(
-								(SELECT arr.object
-								 FROM users,
-									  JSONB_ARRAY_ELEMENTS(projects) WITH ORDINALITY arr(object)
-								 WHERE CAST(arr.object AS JSONB) ->> 'title' = :project_title),
-								'{images}',
-								(SELECT JSONB_AGG(image)
-								 FROM (SELECT jsonb_array_elements(CAST(arr.object AS JSONB) -> 'images') AS image
-									   FROM users,
-											JSONB_ARRAY_ELEMENTS(projects) WITH ORDINALITY arr(object)
-									   WHERE CAST(arr.object AS JSONB) ->> 'title' = :project_title
-									   UNION
-		                         SELECT JSONB_BUILD_OBJECT('imgURL', CAST(:img_url AS TEXT), 'width', CAST(:width AS INT), 'height', CAST(:height AS INT)) AS image) AS subquery)
-							)
-					);`,
-		map[string]interface{}{"project_title": projectTitle, "img_url": formattedImage, "width": width, "height": height},
-	)
-	return err
-}
+// saveJobImage stores the image content with its variants and adds it to the job images
+func saveJobImage(content io.ReadSeeker, contentType, company string) (jobImages json.RawMessage, err error) {
+	if err = checkUploadTarget(MediaCollectionJobs, company); err != nil {
+		return
+	}
 
-// UploadJobImage takes a form data file, processes it and transforms it to a bytes reader, generates a key
-// and uploads the image to the s3 bucket. When the file is uploaded - inserts the image into the database and
-// returns an array of existing images for the given job. (along with the newly created)
-func UploadJobImage(file *multipart.FileHeader, company string) (jobImages json.RawMessage, err error) {
 	randomId, _ := uuid.NewRandom()
 
 	fileKey := fmt.Sprintf("job-%s-%s", company, randomId.String())
-	contentType := file.Header.Get("Content-Type")
-
-	fileContent, _ := file.Open()
-	buffer := make([]byte, file.Size)
-	_, _ = fileContent.Read(buffer)
-	fileBytes := bytes.NewReader(buffer)
 
-	err = utils.UploadToS3(fileBytes, fileKey, contentType)
+	image, err := storeImage(content, fileKey, contentType)
 	if err != nil {
 		return
 	}
 
-	imageURL := utils.GetTheFullS3BucketURL() + "/" + fileKey
-
-	fileBytes2 := bytes.NewReader(buffer)
-	img, _, err := image.DecodeConfig(fileBytes2)
-	if err != nil {
-		return
-	}
+	return addImage(MediaCollectionJobs, company, &image, content)
+}
 
-	err = executeUploadJobImageQuery(imageURL, company, img.Width, img.Height)
+// UploadPartnerImage takes a form data file, generates a key and streams the image with its resized variants to
+// the storage. When the file is uploaded - inserts the image into the database and returns an array of existing
+// images for all partners. (along with the newly created)
+func UploadPartnerImage(file *multipart.FileHeader) (partnerImages json.RawMessage, err error) {
+	fileContent, err := file.Open()
 	if err != nil {
-		fmt.Println(err)
 		return
 	}
+	defer fileContent.Close()
 
-	err = database.GetSingleRecordNamedQuery(
-		&jobImages,
-		`SELECT CAST(arr.object AS JSONB) -> 'images' AS job_images
-				FROM users,
-					 JSONB_ARRAY_ELEMENTS(jobs) WITH ORDINALITY arr(object)
-				WHERE CAST(arr.object AS JSONB)  ->> 'company' = :company_name;`,
-		map[string]interface{}{"company_name": company},
-	)
-
-	return
-}
-
-func executeUploadJobImageQuery(imageURL, company string, width, height int) error {
-	formattedImage := strings.ReplaceAll(imageURL, " ", "+")
-
-	_, err := database.ExecuteNamedQuery(
-		`WITH result_objects AS (SELECT arr.object AS object_result
-                        FROM users,
-                             JSONB_ARRAY_ELEMENTS(jobs) WITH ORDINALITY arr(object)
-                        WHERE CAST(arr.object AS JSONB) ->> 'company' != :company_name)
-				UPDATE users
-				SET jobs = (
-						(SELECT JSONB_AGG(object_result) FROM result_objects)
-						||
-						JSONB_SET(
-								(SELECT arr.object
-								 FROM users,
-									  JSONB_ARRAY_ELEMENTS(jobs) WITH ORDINALITY arr(object)
-								 WHERE CAST(arr.object AS JSONB) ->> 'company' = :company_name),
-								'{images}',
-								(SELECT JSONB_AGG(image)
-								 FROM (SELECT jsonb_array_elements(CAST(arr.object AS JSONB) -> 'images') AS image
-									   FROM users,
-											JSONB_ARRAY_ELEMENTS(jobs) WITH ORDINALITY arr(object)
-									   WHERE CAST(arr.object AS JSONB) ->> 'title' = :company_name
-									   UNION
-									   SELECT JSONB_BUILD_OBJECT('imgURL', CAST(:img_url AS TEXT), 'width', CAST(:width AS INT), 'height', CAST(:height AS INT)) AS image) AS subquery)
-							)
-					);`,
-		map[string]interface{}{"company_name": company, "img_url": formattedImage, "width": width, "height": height},
-	)
-	return err
+	return savePartnerImage(fileContent, file.Header.Get("Content-Type"))
 }
 
-// UploadPartnerImage takes a form data file, processes it and transforms it to a bytes reader, generates a key
-// and uploads the image to the s3 bucket. When the file is uploaded - inserts the image into the database and
-// returns an array of existing images for all partners. (along with the newly created)
-func UploadPartnerImage(file *multipart.FileHeader) (partnerImages json.RawMessage, err error) {
+// savePartnerImage stores the image content with its variants and adds it to the partner images
+func savePartnerImage(content io.ReadSeeker, contentType string) (partnerImages json.RawMessage, err error) {
 	randomId, _ := uuid.NewRandom()
 
 	fileKey := fmt.Sprintf("partner-%s", randomId.String())
-	contentType := file.Header.Get("Content-Type")
 
-	fileContent, _ := file.Open()
-	buffer := make([]byte, file.Size)
-	_, _ = fileContent.Read(buffer)
-	fileBytes := bytes.NewReader(buffer)
-
-	err = utils.UploadToS3(fileBytes, fileKey, contentType)
+	image, err := storeImage(content, fileKey, contentType)
 	if err != nil {
 		return
 	}
 
-	fileBytes2 := bytes.NewReader(buffer)
-	img, _, err := image.DecodeConfig(fileBytes2)
+	return addImage(MediaCollectionPartners, "", &image, content)
+}
+
+// UploadCarouselImage takes a form data file, generates a key and streams the image with its resized variants to
+// the storage. When the file is uploaded - inserts the image into the database and returns an array of existing
+// images for all carousels. (along with the newly created) Only the URL and the dimensions of the images are
+// returned, as before the variants and placeholders were added.
+func UploadCarouselImage(file *multipart.FileHeader) (carouselImages []CarouselImages, err error) {
+	fileContent, err := file.Open()
 	if err != nil {
 		return
 	}
+	defer fileContent.Close()
 
-	imageURL := utils.GetTheFullS3BucketURL() + "/" + fileKey
-
-	err = database.GetSingleRecordNamedQuery(
-		&partnerImages,
-		`UPDATE users
-				SET partners = partners || (SELECT JSONB_BUILD_OBJECT(
-														   'imgURL', CAST(:img_url AS TEXT),
-														   'width', CAST(:width AS INT),
-														   'height', CAST(:height AS INT)
-												   ))
-				RETURNING partners;`,
-		map[string]interface{}{"img_url": imageURL, "width": img.Width, "height": img.Height},
-	)
+	carousel, err := saveCarouselImage(fileContent, file.Header.Get("Content-Type"))
+	if err != nil {
+		return
+	}
 
+	carouselImages = []CarouselImages{}
+	err = json.Unmarshal(carousel, &carouselImages)
 	return
 }
 
-// UploadCarouselImage takes a form data file, processes it and transforms it to a bytes reader, generates a key
-// and uploads the image to the s3 bucket. When the file is uploaded - inserts the image into the database and
-// returns an array of existing images for all carousels. (along with the newly created)
-func UploadCarouselImage(file *multipart.FileHeader) (carouselImages []CarouselImages, err error) {
+// saveCarouselImage stores the image content with its variants and adds it to the carousel images
+func saveCarouselImage(content io.ReadSeeker, contentType string) (carouselImages json.RawMessage, err error) {
 	randomId, _ := uuid.NewRandom()
 
 	fileKey := fmt.Sprintf("carousel-%s", randomId.String())
-	contentType := file.Header.Get("Content-Type")
 
-	fileContent, _ := file.Open()
-	buffer := make([]byte, file.Size)
-	_, _ = fileContent.Read(buffer)
-	fileBytes := bytes.NewReader(buffer)
-
-	err = utils.UploadToS3(fileBytes, fileKey, contentType)
+	image, err := storeImage(content, fileKey, contentType)
 	if err != nil {
 		return
 	}
 
-	imageURL := utils.GetTheFullS3BucketURL() + "/" + fileKey
+	return addImage(MediaCollectionCarousel, "", &image, content)
+}
 
-	fileBytes2 := bytes.NewReader(buffer)
-	img, _, err := image.DecodeConfig(fileBytes2)
-	if err != nil {
+// addImage appends the image to the collection, or to the images of the project or job of the name, under the
+// same row lock as the batch uploads and the deletions - the reused image of an identical upload is claimed under
+// the lock too. Returns the images of the collection or the project or job.
+func addImage(collection, name string, image *storedImage, content io.ReadSeeker) (images json.RawMessage, err error) {
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		collections, err := lockImageCollections(transaction)
+		if err != nil {
+			return
+		}
+
+		if err = claimStoredImage(transaction, image, content); err != nil {
+			return
+		}
+		value, err := asJSONValue(image.imageObject)
+		if err != nil {
+			return
+		}
+
+		decoded, err := decodeCollections(collections)
+		if err != nil {
+			return
+		}
+		if err = decoded.appendImages(collection, name, []interface{}{value}); err != nil {
+			return
+		}
+
+		if collections, err = decoded.encode(); err != nil {
+			return
+		}
+		if err = updateImageCollections(transaction, collections); err != nil {
+			return
+		}
+
+		images, err = json.Marshal(decoded.collectionImages(MediaReference{Collection: collection, Name: name}))
 		return
+	})
+	return
+}
+
+// DeleteImage removes the image from every collection which references it (or only from the given collection and
+// project or job name) and moves it to the trash along with its variants and the archived original, recording
+// where it was removed from so it can be restored. The objects shared with other references (by the identical
+// uploads) stay in place until the last reference is removed. The objects are moved after the transaction is
+// committed, so the collections are not locked during the storage requests - the objects which fail to be moved
+// stay in place for the orphan collection. Deleting an image which was already deleted is not an error, the trash
+// ID is empty then. The result holds the updated images of
+// the collections the image was removed from (the images of each project or job, or the whole carousel or
+// partners collection).
+func DeleteImage(imageURL, collection, name string) (trashID string, updatedCollections []UpdatedCollection, err error) {
+	fileKey, found := utils.GetStorage().KeyFromURL(imageURL)
+	if !found {
+		return "", nil, fmt.Errorf("%w - %s", ErrNotStorageURL, imageURL)
 	}
 
-	_, err = database.ExecuteNamedQuery(
-		`UPDATE users SET carousel = carousel || (SELECT JSONB_BUILD_OBJECT(
-                                                        'imgURL', CAST(:img_url AS TEXT),
-                                                        'width', CAST(:width AS INT),
-                                                        'height', CAST(:height AS INT)
-                                                    ));`,
-		map[string]interface{}{"img_url": imageURL, "width": img.Width, "height": img.Height},
-	)
-	if err != nil {
+	var trashedObjects []trashedObject
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		collections, err := lockImageCollections(transaction)
+		if err != nil {
+			return
+		}
+
+		decoded, err := decodeCollections(collections)
+		if err != nil {
+			return
+		}
+
+		detached := decoded.detachImage(fileKey, collection, name)
+		if len(detached) > 0 {
+			if collections, err = decoded.encode(); err != nil {
+				return
+			}
+			if err = updateImageCollections(transaction, collections); err != nil {
+				return
+			}
+		}
+
+		references := make([]MediaReference, 0, len(detached))
+		for _, image := range detached {
+			references = append(references, image.Reference)
+		}
+		if updatedCollections, err = decoded.updatedCollections(references); err != nil {
+			return
+		}
+
+		isShared := decoded.countImageReferences(fileKey) > 0
+		if !isShared {
+			if err = forgetStoredImage(transaction, fileKey); err != nil {
+				return
+			}
+		}
+		trashID, trashedObjects, err = trashImage(transaction, imageURL, fileKey, detached, isShared)
+		return
+	})
+	if err != nil || len(trashedObjects) == 0 {
 		return
 	}
 
-	err = database.GetMultipleRecords(
-		&carouselImages,
-		`SELECT arr.object::JSONB ->> 'imgURL' AS img_url,
-					   arr.object::JSONB -> 'width' AS width,
-					   arr.object::JSONB -> 'height' AS height
-				FROM users,
-					 JSONB_ARRAY_ELEMENTS(carousel) WITH ORDINALITY arr(object)
-				WHERE id = 1;`,
-	)
-
-	return
-}
+	if moveErr := moveToTrash(trashedObjects); moveErr != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": moveErr.Error()}).
+			Warnf("Failed to move the objects of the image %s to the trash", fileKey)
+	}
 
-// DeleteImage deletes an image from the s3 bucket
-func DeleteImage(imageURL string) (err error) {
-	err = utils.DeleteFromS3(imageURL)
-	if err != nil {
-		return
+	// the cached transformations are made again on demand if the image is restored
+	if purgeErr := purgeImageTransforms(fileKey); purgeErr != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": purgeErr.Error()}).
+			Warnf("Failed to delete the cached transformations of the image %s", fileKey)
 	}
 	return
 }
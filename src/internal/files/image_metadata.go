@@ -0,0 +1,153 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	maxAltTextLength = 500
+	maxCaptionLength = 1000
+	maxCreditLength  = 300
+)
+
+var (
+	ErrInvalidImageMetadata = errors.New("invalid image metadata")
+	ErrImageNotFound        = errors.New("the image is not referenced in any collection")
+
+	languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+)
+
+// UpdateImageMetadata replaces the alt texts, caption, credit and focal point of every reference to the image,
+// given by its URL or its ID (the storage key). The result holds the updated images of the collections which
+// reference the image, as the deletion does.
+func UpdateImageMetadata(request ImageMetadataRequestBody) (updatedCollections []UpdatedCollection, err error) {
+	if err = validateImageMetadata(request.ImageMetadata); err != nil {
+		return
+	}
+
+	fileKey := request.ImageID
+	if len(request.ImageURL) > 0 {
+		var found bool
+		if fileKey, found = utils.GetStorage().KeyFromURL(request.ImageURL); !found {
+			return nil, fmt.Errorf("%w - %s", ErrNotStorageURL, request.ImageURL)
+		}
+	}
+	if len(fileKey) == 0 {
+		return nil, fmt.Errorf("%w - expected imageURL or imageID", ErrInvalidImageMetadata)
+	}
+
+	metadata, err := metadataFields(request.ImageMetadata)
+	if err != nil {
+		return
+	}
+
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		collections, err := lockImageCollections(transaction)
+		if err != nil {
+			return
+		}
+
+		decoded, err := decodeCollections(collections)
+		if err != nil {
+			return
+		}
+
+		references := decoded.findImage(fileKey)
+		if len(references) == 0 {
+			return ErrImageNotFound
+		}
+
+		mediaReferences := make([]MediaReference, 0, len(references))
+		for _, reference := range references {
+			for field, value := range metadata {
+				if value == nil {
+					delete(reference.Image, field)
+				} else {
+					reference.Image[field] = value
+				}
+			}
+			mediaReferences = append(mediaReferences, reference.Reference)
+		}
+		if updatedCollections, err = decoded.updatedCollections(mediaReferences); err != nil {
+			return
+		}
+
+		if collections, err = decoded.encode(); err != nil {
+			return
+		}
+		return updateImageCollections(transaction, collections)
+	})
+	return
+}
+
+func validateImageMetadata(metadata ImageMetadata) error {
+	for languageTag, altText := range metadata.Alt {
+		if !languageTagPattern.MatchString(languageTag) {
+			return fmt.Errorf("%w - %q is not a language tag", ErrInvalidImageMetadata, languageTag)
+		}
+		if utf8.RuneCountInString(altText) > maxAltTextLength {
+			return fmt.Errorf("%w - the alt text exceeds %d characters", ErrInvalidImageMetadata, maxAltTextLength)
+		}
+	}
+
+	if utf8.RuneCountInString(metadata.Caption) > maxCaptionLength {
+		return fmt.Errorf("%w - the caption exceeds %d characters", ErrInvalidImageMetadata, maxCaptionLength)
+	}
+	if utf8.RuneCountInString(metadata.Credit) > maxCreditLength {
+		return fmt.Errorf("%w - the credit exceeds %d characters", ErrInvalidImageMetadata, maxCreditLength)
+	}
+
+	focalPoint := metadata.FocalPoint
+	if focalPoint != nil && (focalPoint.X < 0 || focalPoint.X > 1 || focalPoint.Y < 0 || focalPoint.Y > 1) {
+		return fmt.Errorf("%w - the focal point coordinates have to be between 0 and 1", ErrInvalidImageMetadata)
+	}
+	return nil
+}
+
+// metadataFields converts the metadata to the image object fields, the empty values are mapped to nil so they
+// are removed from the image objects
+func metadataFields(metadata ImageMetadata) (fields map[string]interface{}, err error) {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return
+	}
+
+	fields = map[string]interface{}{"alt": nil, "caption": nil, "credit": nil, "focalPoint": nil}
+	err = json.Unmarshal(encoded, &fields)
+	return
+}
+
+// findImage finds the image objects with the given key in all collections
+func (decoded decodedCollections) findImage(fileKey string) (images []detachedImage) {
+	find := func(collection, name string, entries []interface{}) {
+		for position, entry := range entries {
+			imageObject, isObject := entry.(map[string]interface{})
+			imageURL, _ := imageObject["imgURL"].(string)
+
+			if imageKey, found := utils.GetStorage().KeyFromURL(imageURL); isObject && found && imageKey == fileKey {
+				images = append(images, detachedImage{
+					Reference: MediaReference{Collection: collection, Name: name, Position: position, Usage: MediaUsageImage},
+					Image:     imageObject,
+				})
+			}
+		}
+	}
+
+	for _, project := range decoded.Projects {
+		title, _ := project["title"].(string)
+		find(MediaCollectionProjects, title, asList(project["images"]))
+	}
+	for _, job := range decoded.Jobs {
+		company, _ := job["company"].(string)
+		find(MediaCollectionJobs, company, asList(job["images"]))
+	}
+	find(MediaCollectionCarousel, "", decoded.Carousel)
+	find(MediaCollectionPartners, "", decoded.Partners)
+	return
+}
@@ -0,0 +1,47 @@
+package files
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateImageMetadata(t *testing.T) {
+	testCases := []struct {
+		name     string
+		metadata ImageMetadata
+		valid    bool
+	}{
+		{name: "empty", metadata: ImageMetadata{}, valid: true},
+		{
+			name: "all fields",
+			metadata: ImageMetadata{
+				Alt:        map[string]string{"en": "A bridge", "pt-BR": "Uma ponte", "zh-Hant": "橋"},
+				Caption:    "The bridge at dawn",
+				Credit:     "Someone",
+				FocalPoint: &FocalPoint{X: 0, Y: 1},
+			},
+			valid: true,
+		},
+		{name: "alt text at the limit", metadata: ImageMetadata{Alt: map[string]string{"en": strings.Repeat("é", maxAltTextLength)}}, valid: true},
+		{name: "invalid language tag", metadata: ImageMetadata{Alt: map[string]string{"English": "A bridge"}}},
+		{name: "empty language tag", metadata: ImageMetadata{Alt: map[string]string{"": "A bridge"}}},
+		{name: "alt text too long", metadata: ImageMetadata{Alt: map[string]string{"en": strings.Repeat("a", maxAltTextLength+1)}}},
+		{name: "caption too long", metadata: ImageMetadata{Caption: strings.Repeat("a", maxCaptionLength+1)}},
+		{name: "credit too long", metadata: ImageMetadata{Credit: strings.Repeat("a", maxCreditLength+1)}},
+		{name: "focal point below 0", metadata: ImageMetadata{FocalPoint: &FocalPoint{X: -0.1, Y: 0.5}}},
+		{name: "focal point above 1", metadata: ImageMetadata{FocalPoint: &FocalPoint{X: 0.5, Y: 1.1}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := validateImageMetadata(testCase.metadata)
+			if testCase.valid && err != nil {
+				t.Fatalf("expected the metadata to be valid, got %s", err.Error())
+			}
+			if !testCase.valid && !errors.Is(err, ErrInvalidImageMetadata) {
+				t.Fatalf("expected ErrInvalidImageMetadata, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/goccy/go-json"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"portfolio-cms-server/utils"
+	"sort"
+	"strings"
+)
+
+const variantJPEGQuality = 82
+
+var variantWidths = []int{320, 640, 1280}
+
+// ConfigureImageVariants sets the widths of the resized image variants generated on upload. Passing no widths
+// keeps the defaults.
+func ConfigureImageVariants(widths []int) {
+	if len(widths) == 0 {
+		return
+	}
+
+	variantWidths = append([]int{}, widths...)
+	sort.Ints(variantWidths)
+}
+
+// storedImage is the image stored for an upload, or the image of an identical upload which it reuses
+type storedImage struct {
+	imageObject ImageObject
+	contentHash string
+	fileKey     string
+	contentType string
+	// reusedKey is the key of the reused image, it has to be claimed by the transaction adding the image
+	reusedKey string
+}
+
+// storeImage stores the image unless the same bytes were uploaded before, in which case the stored image object is
+// reused, so identical uploads share the stored objects. The reused image is claimed by the transaction which adds
+// it to the collections.
+func storeImage(content io.ReadSeeker, fileKey, contentType string) (image storedImage, err error) {
+	image = storedImage{fileKey: fileKey, contentType: contentType}
+	if image.contentHash, err = hashContent(content); err != nil {
+		return
+	}
+
+	imageObject, reusedKey, found, err := findStoredImage(image.contentHash)
+	if err != nil {
+		return
+	}
+	if found {
+		image.imageObject, image.reusedKey = imageObject, reusedKey
+		return
+	}
+
+	if err = rewind(content); err != nil {
+		return
+	}
+	if image.imageObject, err = storeNewImage(content, fileKey, contentType); err != nil {
+		return
+	}
+
+	rememberStoredImage(image.contentHash, fileKey, image.imageObject)
+	return
+}
+
+// storeNewImage streams the image without its metadata to the storage, uploads its resized variants and builds the
+// image object stored in the JSONB collections, including the loading placeholders. The dimensions are read from
+// the image header, the image is fully decoded only once for the variants and placeholders.
+func storeNewImage(content io.ReadSeeker, fileKey, contentType string) (imageObject ImageObject, err error) {
+	if contentType == svgContentType {
+		return storeSVGImage(content, fileKey)
+	}
+
+	config, format, err := image.DecodeConfig(content)
+	if err != nil {
+		return
+	}
+	if err = checkImageResolution(config); err != nil {
+		return
+	}
+
+	if keepOriginals {
+		if err = rewind(content); err != nil {
+			return
+		}
+		if err = utils.GetStorage().PutPrivate("originals/"+fileKey, content, contentType); err != nil {
+			return
+		}
+	}
+
+	if err = rewind(content); err != nil {
+		return
+	}
+	orientation, err := putStrippedImage(content, fileKey, contentType)
+	if err != nil {
+		return
+	}
+
+	if err = rewind(content); err != nil {
+		return
+	}
+	// the RGBA copy is held along with the decoded image
+	budget := imageDecodes
+	weight := budget.acquire(2 * config.Width * config.Height)
+	defer budget.release(weight)
+
+	decoded, _, err := image.Decode(content)
+	if err != nil {
+		return
+	}
+	img := utils.OrientImage(decoded, orientation)
+
+	// the stripped image lost its orientation, so the upright pixels replace it
+	if orientation > 1 {
+		oriented, _, encodeErr := encodeImage(img, format, orientedJPEGQuality)
+		if encodeErr != nil {
+			return imageObject, encodeErr
+		}
+		if err = utils.GetStorage().Put(fileKey, bytes.NewReader(oriented), contentType); err != nil {
+			return
+		}
+	}
+
+	imageObject = ImageObject{ImgURL: storageURL(fileKey), Width: config.Width, Height: config.Height}
+	if orientation >= 5 {
+		imageObject.Width, imageObject.Height = config.Height, config.Width
+	}
+
+	imageObject.ImagePlaceholders, err = computePlaceholders(img, format)
+	if err != nil {
+		return
+	}
+
+	imageObject.Variants, err = storeImageVariants(img, format, fileKey)
+	return
+}
+
+// storeSVGImage stores the sanitized SVG. The vector images are not resized, so they have neither variants nor
+// placeholders.
+func storeSVGImage(content io.ReadSeeker, fileKey string) (imageObject ImageObject, err error) {
+	if keepOriginals {
+		if err = utils.GetStorage().PutPrivate("originals/"+fileKey, content, svgContentType); err != nil {
+			return
+		}
+		if err = rewind(content); err != nil {
+			return
+		}
+	}
+
+	sanitized, width, height, err := sanitizeSVG(content)
+	if err != nil {
+		return
+	}
+	if err = utils.GetStorage().Put(fileKey, bytes.NewReader(sanitized), svgContentType); err != nil {
+		return
+	}
+	return ImageObject{ImgURL: storageURL(fileKey), Width: width, Height: height}, nil
+}
+
+// putStrippedImage strips the image metadata while it is uploaded and returns its EXIF orientation
+func putStrippedImage(content io.Reader, fileKey, contentType string) (orientation int, err error) {
+	reader, writer := io.Pipe()
+	stripped := make(chan error, 1)
+
+	go func() {
+		var stripErr error
+		orientation, stripErr = stripImageMetadata(writer, content)
+		_ = writer.CloseWithError(stripErr)
+		stripped <- stripErr
+	}()
+
+	err = utils.GetStorage().Put(fileKey, reader, contentType)
+	// unblocks the stripping if the upload failed before reading all of it
+	_ = reader.CloseWithError(io.ErrClosedPipe)
+
+	if stripErr := <-stripped; err == nil {
+		err = stripErr
+	}
+	return
+}
+
+func rewind(content io.Seeker) error {
+	_, err := content.Seek(0, io.SeekStart)
+	return err
+}
+
+// storeImageVariants resizes the image to each of the configured widths smaller than the original and uploads
+// the variants next to it, keeping the original format
+func storeImageVariants(img *image.RGBA, format, fileKey string) (variants []ImageVariant, err error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	for _, variantWidth := range variantWidths {
+		if variantWidth >= width {
+			break
+		}
+
+		variantHeight := utils.ScaledHeight(width, height, variantWidth)
+		resized := utils.ResizeImage(img, variantWidth, variantHeight)
+
+		content, contentType, encodeErr := encodeImage(resized, format, variantJPEGQuality)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+
+		variantKey := fmt.Sprintf("%s-%dw", fileKey, variantWidth)
+		if err = utils.GetStorage().Put(variantKey, bytes.NewReader(content), contentType); err != nil {
+			return
+		}
+
+		variants = append(variants, ImageVariant{URL: storageURL(variantKey), Width: variantWidth, Height: variantHeight})
+	}
+	return
+}
+
+func encodeImage(img image.Image, format string, jpegQuality int) (content []byte, contentType string, err error) {
+	buffer := bytes.Buffer{}
+
+	switch format {
+	case "png":
+		err = png.Encode(&buffer, img)
+		contentType = "image/png"
+	default:
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: jpegQuality})
+		contentType = "image/jpeg"
+	}
+	return buffer.Bytes(), contentType, err
+}
+
+// storageURL builds the public URL of the stored object, the spaces in the keys are stored as + in the URLs
+func storageURL(fileKey string) string {
+	return strings.ReplaceAll(utils.GetStorage().PublicURL(fileKey), " ", "+")
+}
+
+func marshalImage(imageObject ImageObject) (string, error) {
+	encoded, err := json.Marshal(imageObject)
+	return string(encoded), err
+}
@@ -0,0 +1,421 @@
+package files
+
+import (
+	"errors"
+	"github.com/goccy/go-json"
+	"path"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MediaTypeImage    = "image"
+	MediaTypeVideo    = "video"
+	MediaTypeDocument = "document"
+	MediaTypeOther    = "other"
+
+	MediaCollectionProjects = "projects"
+	MediaCollectionJobs     = "jobs"
+	MediaCollectionCarousel = "carousel"
+	MediaCollectionPartners = "partners"
+	MediaCollectionCV       = "cv"
+
+	MediaUsageImage      = "image"
+	MediaUsageVariant    = "variant"
+	MediaUsageOriginal   = "original"
+	MediaUsageCV         = "cv"
+	MediaUsageAttachment = "attachment"
+	MediaUsagePoster     = "poster"
+
+	defaultMediaPageSize = 50
+	// statConcurrency bounds the parallel metadata requests to the storage
+	statConcurrency = 8
+	// mediaListingLifetime is how long the storage listing is reused by the following pages
+	mediaListingLifetime = 30 * time.Second
+)
+
+var (
+	// publicMediaPrefixes are the key prefixes of the uploaded media, along with their variants and posters
+	publicMediaPrefixes = []string{"project-", "job-", "partner-", "carousel-", "attachment-", "cv-"}
+	// imagePrefixes are the key prefixes of the objects which are always images
+	imagePrefixes = []string{"project-", "job-", "partner-", "carousel-", transformPrefix}
+
+	// variantSuffix ends the keys of the resized variants, which are stored next to their image
+	variantSuffix = regexp.MustCompile(`-\d+w$`)
+
+	mediaListings      = map[string]mediaListing{}
+	mediaListingsMutex = sync.Mutex{}
+)
+
+// mediaListing is the storage listing of a prefix, newest first
+type mediaListing struct {
+	objects  []utils.ObjectInfo
+	listedAt time.Time
+}
+
+type imageDimensions struct {
+	width, height int
+}
+
+// ListMedia lists the stored objects which keys start with the prefix, newest first, with their type, dimensions
+// and references in the users collections. The listing of the prefix is reused for a short while, so paging
+// through the library does not list the whole storage for every page. The content types are read from the
+// storage for the requested page only - the objects are filtered by the type of their key (or the content type
+// recorded in the collections), only the objects which keys do not tell their type are looked up.
+func ListMedia(query MediaLibraryQuery) (page MediaLibraryPage, err error) {
+	page.Page, page.PageSize = max(query.Page, 1), query.PageSize
+	if page.PageSize == 0 {
+		page.PageSize = defaultMediaPageSize
+	}
+
+	objects, err := listMediaObjects(query.Prefix)
+	if err != nil {
+		return
+	}
+
+	references, dimensions, mediaTypes, err := collectMediaReferences()
+	if err != nil {
+		return
+	}
+
+	if len(query.Type) > 0 {
+		var unknownObjects []utils.ObjectInfo
+		for _, object := range objects {
+			if _, known := keyMediaType(object.Key, mediaTypes); !known {
+				unknownObjects = append(unknownObjects, object)
+			}
+		}
+		if err = statObjects(unknownObjects); err != nil {
+			return
+		}
+		for _, object := range unknownObjects {
+			mediaTypes[object.Key] = mediaType(object.ContentType)
+		}
+
+		filteredObjects := objects[:0]
+		for _, object := range objects {
+			if objectType, _ := keyMediaType(object.Key, mediaTypes); objectType == query.Type {
+				filteredObjects = append(filteredObjects, object)
+			}
+		}
+		objects = filteredObjects
+	}
+
+	page.Total = len(objects)
+	start := min((page.Page-1)*page.PageSize, len(objects))
+	objects = objects[start:min(start+page.PageSize, len(objects))]
+
+	if err = statObjects(objects); err != nil {
+		return
+	}
+
+	var unknownImageKeys []string
+	for _, object := range objects {
+		if _, found := dimensions[object.Key]; !found && mediaType(object.ContentType) == MediaTypeImage {
+			unknownImageKeys = append(unknownImageKeys, object.Key)
+		}
+	}
+	indexedDimensions, err := indexedImageDimensions(unknownImageKeys)
+	if err != nil {
+		return
+	}
+
+	page.Objects = make([]MediaObject, 0, len(objects))
+	for _, object := range objects {
+		mediaObject := MediaObject{
+			ObjectInfo: object,
+			URL:        storageURL(object.Key),
+			Type:       mediaType(object.ContentType),
+			References: references[object.Key],
+		}
+		if mediaObject.References == nil {
+			mediaObject.References = []MediaReference{}
+		}
+
+		if mediaObject.Type == MediaTypeImage {
+			objectDimensions, found := dimensions[object.Key]
+			if !found {
+				objectDimensions = indexedDimensions[object.Key]
+			}
+			mediaObject.Width, mediaObject.Height = objectDimensions.width, objectDimensions.height
+		}
+		page.Objects = append(page.Objects, mediaObject)
+	}
+	return
+}
+
+// listMediaObjects lists the objects of the prefix newest first, reusing the listing made within the listing
+// lifetime. The caller gets a copy, as the content types are filled in.
+func listMediaObjects(prefix string) (objects []utils.ObjectInfo, err error) {
+	mediaListingsMutex.Lock()
+	listing, found := mediaListings[prefix]
+	mediaListingsMutex.Unlock()
+	if found && time.Since(listing.listedAt) < mediaListingLifetime {
+		return slices.Clone(listing.objects), nil
+	}
+
+	listedAt := time.Now()
+	objects, err = utils.GetStorage().List(prefix)
+	if err != nil {
+		return
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].LastModified.After(objects[j].LastModified)
+	})
+
+	mediaListingsMutex.Lock()
+	defer mediaListingsMutex.Unlock()
+	for listedPrefix, listing := range mediaListings {
+		if time.Since(listing.listedAt) >= mediaListingLifetime {
+			delete(mediaListings, listedPrefix)
+		}
+	}
+	mediaListings[prefix] = mediaListing{objects: objects, listedAt: listedAt}
+	return slices.Clone(objects), nil
+}
+
+// keyMediaType tells the media type of the object from its key - the uploaded images (along with their variants,
+// archived originals and trashed copies), the posters and the CVs have their own prefixes, the attachments are
+// looked up in the media types of the referenced objects
+func keyMediaType(key string, mediaTypes map[string]string) (objectType string, known bool) {
+	if objectType, known = mediaTypes[key]; known {
+		return
+	}
+
+	if strings.HasPrefix(key, trashPrefix) {
+		_, key, _ = strings.Cut(strings.TrimPrefix(key, trashPrefix), "/")
+	}
+	key = strings.TrimPrefix(key, "originals/")
+	if objectType, known = mediaTypes[key]; known {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(key, "attachment-") && strings.HasSuffix(key, "-poster"):
+		return MediaTypeImage, true
+	case slices.ContainsFunc(imagePrefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) }):
+		return MediaTypeImage, true
+	case key == "cv" || strings.HasPrefix(key, "cv-"):
+		return MediaTypeDocument, true
+	default:
+		return "", false
+	}
+}
+
+// collectMediaReferences maps the object keys to the places they are used in the users collections, along with
+// the dimensions of the referenced images (and their variants) and the media types of the attachments
+func collectMediaReferences() (references map[string][]MediaReference, dimensions map[string]imageDimensions, mediaTypes map[string]string, err error) {
+	collections := imageCollections{}
+	err = database.GetSingleRecord(
+		&collections,
+		`SELECT COALESCE(projects, '[]') AS projects,
+					   COALESCE(jobs, '[]')     AS jobs,
+					   COALESCE(carousel, '[]') AS carousel,
+					   COALESCE(partners, '[]') AS partners,
+					   COALESCE(cv_link, '')    AS cv_link
+				FROM users
+				WHERE id = 1;`,
+	)
+	if err != nil {
+		return
+	}
+
+	references, dimensions, mediaTypes = map[string][]MediaReference{}, map[string]imageDimensions{}, map[string]string{}
+	addImages := func(collection, name string, images []ImageObject) {
+		for position, imageObject := range images {
+			reference := MediaReference{Collection: collection, Name: name, Position: position}
+
+			if fileKey, found := utils.GetStorage().KeyFromURL(imageObject.ImgURL); found {
+				references[fileKey] = append(references[fileKey], withUsage(reference, MediaUsageImage))
+				references["originals/"+fileKey] = append(references["originals/"+fileKey], withUsage(reference, MediaUsageOriginal))
+				dimensions[fileKey] = imageDimensions{imageObject.Width, imageObject.Height}
+			}
+
+			for _, variant := range imageObject.Variants {
+				if variantKey, found := utils.GetStorage().KeyFromURL(variant.URL); found {
+					references[variantKey] = append(references[variantKey], withUsage(reference, MediaUsageVariant))
+					dimensions[variantKey] = imageDimensions{variant.Width, variant.Height}
+				}
+			}
+		}
+	}
+	addAttachments := func(collection, name string, attachments []Attachment) {
+		for position, attachment := range attachments {
+			reference := MediaReference{Collection: collection, Name: name, Position: position}
+
+			if fileKey, found := utils.GetStorage().KeyFromURL(attachment.URL); found {
+				references[fileKey] = append(references[fileKey], withUsage(reference, MediaUsageAttachment))
+				mediaTypes[fileKey] = mediaType(attachment.MimeType)
+			}
+			if posterKey, found := utils.GetStorage().KeyFromURL(attachment.PosterURL); found {
+				references[posterKey] = append(references[posterKey], withUsage(reference, MediaUsagePoster))
+			}
+		}
+	}
+
+	var projects, jobs []collectionItem
+	if err = json.Unmarshal(collections.Projects, &projects); err != nil {
+		return
+	}
+	for _, project := range projects {
+		addImages(MediaCollectionProjects, project.Title, project.Images)
+		addAttachments(MediaCollectionProjects, project.Title, project.Attachments)
+	}
+
+	if err = json.Unmarshal(collections.Jobs, &jobs); err != nil {
+		return
+	}
+	for _, job := range jobs {
+		addImages(MediaCollectionJobs, job.Company, job.Images)
+		addAttachments(MediaCollectionJobs, job.Company, job.Attachments)
+	}
+
+	var carousel, partners []ImageObject
+	if err = json.Unmarshal(collections.Carousel, &carousel); err != nil {
+		return
+	}
+	addImages(MediaCollectionCarousel, "", carousel)
+
+	if err = json.Unmarshal(collections.Partners, &partners); err != nil {
+		return
+	}
+	addImages(MediaCollectionPartners, "", partners)
+
+	if cvKey, found := utils.GetStorage().KeyFromURL(collections.CVLink); found {
+		references[cvKey] = append(references[cvKey], MediaReference{Collection: MediaCollectionCV, Usage: MediaUsageCV})
+	}
+
+	// the previous CV versions are kept for the rollbacks
+	cvVersions, err := listAllCVVersions()
+	if err != nil {
+		return
+	}
+	for _, version := range cvVersions {
+		name := version.Variant
+		if len(version.Language) > 0 {
+			name += "/" + version.Language
+		}
+		if !slices.ContainsFunc(references[version.FileKey], func(reference MediaReference) bool { return reference.Collection == MediaCollectionCV }) {
+			references[version.FileKey] = append(references[version.FileKey], MediaReference{Collection: MediaCollectionCV, Name: name, Usage: MediaUsageCV})
+		}
+	}
+	return
+}
+
+// IsPublicMediaKey reports whether the key is of the uploaded media, which is public - the other objects of the
+// storage (such as the archived originals, the trash or the staged uploads) are not
+func IsPublicMediaKey(key string) bool {
+	if len(key) == 0 || path.Clean("/"+key) != "/"+key {
+		return false
+	}
+	// the CV uploaded before the versioning is stored at a fixed key
+	if key == "cv" {
+		return true
+	}
+	return slices.ContainsFunc(publicMediaPrefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+func withUsage(reference MediaReference, usage string) MediaReference {
+	reference.Usage = usage
+	return reference
+}
+
+// statObjects fills in the content types the storage listing does not return
+func statObjects(objects []utils.ObjectInfo) (err error) {
+	semaphore := make(chan struct{}, statConcurrency)
+	waitGroup := sync.WaitGroup{}
+	errorOnce := sync.Once{}
+
+	for index := range objects {
+		if len(objects[index].ContentType) > 0 {
+			continue
+		}
+
+		waitGroup.Add(1)
+		semaphore <- struct{}{}
+		go func(object *utils.ObjectInfo) {
+			defer func() {
+				<-semaphore
+				waitGroup.Done()
+			}()
+
+			// an object deleted since the listing is shown without the content type
+			info, statErr := utils.GetStorage().Stat(object.Key)
+			if statErr != nil && !errors.Is(statErr, utils.ErrObjectNotFound) {
+				errorOnce.Do(func() { err = statErr })
+				return
+			}
+			object.ContentType = info.ContentType
+		}(&objects[index])
+	}
+
+	waitGroup.Wait()
+	return
+}
+
+// indexedImageDimensions reads the dimensions of the images which are not referenced (and of their variants) from
+// the image objects indexed by the content hash, so the images are not downloaded. The images which are not
+// indexed are left without the dimensions.
+func indexedImageDimensions(keys []string) (dimensions map[string]imageDimensions, err error) {
+	dimensions = map[string]imageDimensions{}
+	if len(keys) == 0 {
+		return
+	}
+
+	fileKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fileKeys = append(fileKeys, key, variantSuffix.ReplaceAllString(key, ""))
+	}
+
+	var indexedImages []json.RawMessage
+	err = database.GetMultipleRecordsNamedQuery(
+		&indexedImages,
+		`SELECT image FROM media_hashes WHERE file_key IN (:file_keys);`,
+		map[string]interface{}{"file_keys": fileKeys},
+	)
+	if err != nil {
+		return
+	}
+
+	for _, indexedImage := range indexedImages {
+		imageObject := ImageObject{}
+		if json.Unmarshal(indexedImage, &imageObject) != nil {
+			continue
+		}
+
+		if fileKey, found := utils.GetStorage().KeyFromURL(imageObject.ImgURL); found {
+			dimensions[fileKey] = imageDimensions{imageObject.Width, imageObject.Height}
+		}
+		for _, variant := range imageObject.Variants {
+			if variantKey, found := utils.GetStorage().KeyFromURL(variant.URL); found {
+				dimensions[variantKey] = imageDimensions{variant.Width, variant.Height}
+			}
+		}
+	}
+	return
+}
+
+func mediaType(contentType string) string {
+	mimeType, _, _ := strings.Cut(contentType, ";")
+
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return MediaTypeImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return MediaTypeVideo
+	case mimeType == "application/pdf",
+		strings.HasPrefix(mimeType, "text/"),
+		strings.HasPrefix(mimeType, "application/msword"),
+		strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument"),
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument"):
+		return MediaTypeDocument
+	default:
+		return MediaTypeOther
+	}
+}
@@ -0,0 +1,116 @@
+package files
+
+import (
+	"portfolio-cms-server/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testStorageURL = "http://localhost:8080/media"
+
+func useTestStorage(t *testing.T) {
+	if err := utils.CreateLocalStorage(t.TempDir(), testStorageURL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsPublicMediaKey(t *testing.T) {
+	testCases := []struct {
+		key      string
+		isPublic bool
+	}{
+		{key: "project-Portfolio CMS-4b6f2e1c", isPublic: true},
+		{key: "project-CI/CD pipeline-4b6f2e1c-640w", isPublic: true},
+		{key: "job-Acme-4b6f2e1c", isPublic: true},
+		{key: "partner-4b6f2e1c", isPublic: true},
+		{key: "carousel-4b6f2e1c-1280w", isPublic: true},
+		{key: "attachment-project-Portfolio CMS-4b6f2e1c-poster", isPublic: true},
+		{key: "cv-default-en-4b6f2e1c", isPublic: true},
+		{key: "cv", isPublic: true},
+		{key: "", isPublic: false},
+		{key: "originals/project-Portfolio CMS-4b6f2e1c", isPublic: false},
+		{key: "trash/4b6f2e1c/project-Portfolio CMS-4b6f2e1c", isPublic: false},
+		{key: "uploads/4b6f2e1c", isPublic: false},
+		{key: "transforms/project-a-1/0a1b2c", isPublic: false},
+		{key: "GeoLite2-Country.mmdb", isPublic: false},
+		{key: "cv.pdf", isPublic: false},
+		{key: "project-a/../../uploads/4b6f2e1c", isPublic: false},
+		{key: "./project-a", isPublic: false},
+		{key: "/project-a", isPublic: false},
+	}
+
+	for _, testCase := range testCases {
+		if isPublic := IsPublicMediaKey(testCase.key); isPublic != testCase.isPublic {
+			t.Errorf("expected %q to be public: %v, got %v", testCase.key, testCase.isPublic, isPublic)
+		}
+	}
+}
+
+func TestKeyMediaType(t *testing.T) {
+	mediaTypes := map[string]string{
+		"attachment-project-CMS-4b6f2e1c": MediaTypeVideo,
+		"attachment-job-Acme-9d2e7f10":    MediaTypeDocument,
+	}
+
+	testCases := []struct {
+		key       string
+		mediaType string
+		known     bool
+	}{
+		{key: "project-CMS-4b6f2e1c", mediaType: MediaTypeImage, known: true},
+		{key: "carousel-4b6f2e1c-640w", mediaType: MediaTypeImage, known: true},
+		{key: "originals/partner-4b6f2e1c", mediaType: MediaTypeImage, known: true},
+		{key: "trash/0c3e9a52/job-Acme-4b6f2e1c-1280w", mediaType: MediaTypeImage, known: true},
+		{key: "trash/0c3e9a52/originals/job-Acme-4b6f2e1c", mediaType: MediaTypeImage, known: true},
+		{key: "transforms/project-CMS-4b6f2e1c/0a1b2c", mediaType: MediaTypeImage, known: true},
+		{key: "attachment-project-CMS-4b6f2e1c-poster", mediaType: MediaTypeImage, known: true},
+		{key: "attachment-project-CMS-4b6f2e1c", mediaType: MediaTypeVideo, known: true},
+		{key: "attachment-job-Acme-9d2e7f10", mediaType: MediaTypeDocument, known: true},
+		{key: "cv", mediaType: MediaTypeDocument, known: true},
+		{key: "cv-default-en-4b6f2e1c", mediaType: MediaTypeDocument, known: true},
+		{key: "attachment-project-CMS-unreferenced", known: false},
+		{key: "trash/0c3e9a52/attachment-project-CMS-unreferenced", known: false},
+		{key: "uploads/4b6f2e1c", known: false},
+		{key: "GeoLite2-Country.mmdb", known: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.key, func(t *testing.T) {
+			mediaType, known := keyMediaType(testCase.key, mediaTypes)
+			if mediaType != testCase.mediaType || known != testCase.known {
+				t.Fatalf("expected %q (%t), got %q (%t)", testCase.mediaType, testCase.known, mediaType, known)
+			}
+		})
+	}
+}
+
+func TestListMediaObjectsReusesTheListing(t *testing.T) {
+	useTestStorage(t)
+	t.Cleanup(func() { mediaListings = map[string]mediaListing{} })
+
+	put := func(key string) {
+		if err := utils.GetStorage().Put(key, strings.NewReader(key), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("project-a")
+
+	objects, err := listMediaObjects("project-")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("expected 1 object, got %d and %v", len(objects), err)
+	}
+	// the callers fill in the content types of their copy
+	objects[0].ContentType = "image/png"
+
+	put("project-b")
+	objects, err = listMediaObjects("project-")
+	if err != nil || len(objects) != 1 || len(objects[0].ContentType) > 0 {
+		t.Fatalf("expected an unchanged copy of the listing, got %+v and %v", objects, err)
+	}
+
+	mediaListings["project-"] = mediaListing{objects: mediaListings["project-"].objects, listedAt: time.Now().Add(-mediaListingLifetime)}
+	if objects, err = listMediaObjects("project-"); err != nil || len(objects) != 2 {
+		t.Fatalf("expected the expired listing to be listed again, got %d objects and %v", len(objects), err)
+	}
+}
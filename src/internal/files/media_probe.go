@@ -0,0 +1,425 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+const (
+	mp4CoverArtJPEG = 13
+	mp4CoverArtPNG  = 14
+
+	ebmlHeaderID     = 0x1A45DFA3
+	ebmlDocTypeID    = 0x4282
+	ebmlSegmentID    = 0x18538067
+	ebmlInfoID       = 0x1549A966
+	ebmlTimescaleID  = 0x2AD7B1
+	ebmlDurationID   = 0x4489
+	ebmlTracksID     = 0x1654AE6B
+	ebmlTrackEntryID = 0xAE
+	ebmlVideoID      = 0xE0
+	ebmlPixelWidth   = 0xB0
+	ebmlPixelHeight  = 0xBA
+	// ebmlUnknownSize is the size of the elements streamed without knowing their length
+	ebmlUnknownSize = -1
+	// maxProbedElementSize bounds the metadata elements read to the memory
+	maxProbedElementSize = 1 << 20
+)
+
+var (
+	errMalformedMedia = errors.New("malformed media container")
+
+	// mp4Brands are the major brands of the MP4 videos, the other ISO media files (such as HEIC or AVIF images)
+	// share the ftyp box
+	mp4Brands = []string{"isom", "iso2", "iso3", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V ", "dash", "mmp4", "MSNV"}
+)
+
+// mediaInfo is the metadata read from the video container headers, the zero values are unknown
+type mediaInfo struct {
+	Duration        float64
+	Width           int
+	Height          int
+	Poster          []byte
+	PosterMediaType string
+}
+
+// isMP4 recognises the ftyp box of the MP4 videos at the start of the file
+func isMP4(head []byte) bool {
+	return len(head) >= 12 && string(head[4:8]) == "ftyp" && slices.Contains(mp4Brands, string(head[8:12]))
+}
+
+// isWebM recognises the EBML header with the webm document type at the start of the file
+func isWebM(head []byte) bool {
+	reader := &ebmlReader{content: bytes.NewReader(head)}
+	id, size, err := reader.readElementHeader()
+	if err != nil || id != ebmlHeaderID || size == ebmlUnknownSize {
+		return false
+	}
+
+	docType := ""
+	// the header may be cut off by the sniffed length after the document type
+	_ = reader.readChildren(min(size, int64(len(head))-reader.offset), func(childID uint64, value []byte) {
+		if childID == ebmlDocTypeID {
+			docType = strings.TrimRight(string(value), "\x00")
+		}
+	}, nil)
+	return docType == "webm"
+}
+
+// probeMP4 reads the duration from the movie header, the dimensions from the first video track header and the cover
+// art (if any) from the iTunes metadata, which is used as the poster frame
+func probeMP4(content io.ReadSeeker) (info mediaInfo, err error) {
+	end, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	var walk func(start, end int64, path string) error
+	walk = func(start, end int64, path string) error {
+		return walkMP4Boxes(content, start, end, func(boxType string, payloadStart, payloadEnd int64) error {
+			boxPath := path + "/" + boxType
+
+			switch boxPath {
+			case "/moov", "/moov/trak", "/moov/udta", "/moov/udta/meta/ilst", "/moov/udta/meta/ilst/covr":
+				return walk(payloadStart, payloadEnd, boxPath)
+			case "/moov/udta/meta":
+				// the meta box is a full box, the children follow the version and flags
+				return walk(payloadStart+4, payloadEnd, boxPath)
+			case "/moov/mvhd":
+				return readMP4Duration(content, payloadStart, payloadEnd, &info)
+			case "/moov/trak/tkhd":
+				if info.Width == 0 {
+					return readMP4TrackDimensions(content, payloadStart, payloadEnd, &info)
+				}
+			case "/moov/udta/meta/ilst/covr/data":
+				if info.Poster == nil {
+					return readMP4CoverArt(content, payloadStart, payloadEnd, &info)
+				}
+			}
+			return nil
+		})
+	}
+
+	err = walk(0, end, "")
+	return
+}
+
+// walkMP4Boxes visits the boxes between the offsets with the offsets of their payloads
+func walkMP4Boxes(content io.ReadSeeker, start, end int64, visit func(boxType string, payloadStart, payloadEnd int64) error) error {
+	for offset := start; offset+8 <= end; {
+		header := make([]byte, 16)
+		if _, err := content.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(content, header[:8]); err != nil {
+			return err
+		}
+
+		size, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := io.ReadFull(content, header[8:]); err != nil {
+				return err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize || offset+size > end {
+			return errMalformedMedia
+		}
+
+		if err := visit(string(header[4:8]), offset+headerSize, offset+size); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+func readMP4Duration(content io.ReadSeeker, start, end int64, info *mediaInfo) error {
+	payload, err := readMediaPayload(content, start, end)
+	if err != nil {
+		return err
+	}
+
+	var timescale, duration uint64
+	switch {
+	case len(payload) >= 32 && payload[0] == 1:
+		timescale, duration = uint64(binary.BigEndian.Uint32(payload[20:24])), binary.BigEndian.Uint64(payload[24:32])
+	case len(payload) >= 20 && payload[0] == 0:
+		timescale, duration = uint64(binary.BigEndian.Uint32(payload[12:16])), uint64(binary.BigEndian.Uint32(payload[16:20]))
+	default:
+		return errMalformedMedia
+	}
+
+	// the all-ones duration is unknown
+	if timescale > 0 && duration != math.MaxUint32 && duration != math.MaxUint64 {
+		info.Duration = roundDuration(float64(duration) / float64(timescale))
+	}
+	return nil
+}
+
+func readMP4TrackDimensions(content io.ReadSeeker, start, end int64, info *mediaInfo) error {
+	payload, err := readMediaPayload(content, start, end)
+	if err != nil {
+		return err
+	}
+
+	// the dimensions are the 16.16 fixed point numbers closing the track header, the audio tracks have none
+	if len(payload) < 84 {
+		return errMalformedMedia
+	}
+	info.Width = int(binary.BigEndian.Uint32(payload[len(payload)-8:len(payload)-4]) >> 16)
+	info.Height = int(binary.BigEndian.Uint32(payload[len(payload)-4:]) >> 16)
+	return nil
+}
+
+func readMP4CoverArt(content io.ReadSeeker, start, end int64, info *mediaInfo) error {
+	if end-start > uploadLimits.MaxImageSize {
+		return nil
+	}
+	payload, err := readMediaPayload(content, start, end)
+	if err != nil || len(payload) < 8 {
+		return err
+	}
+
+	switch binary.BigEndian.Uint32(payload[:4]) {
+	case mp4CoverArtJPEG:
+		info.PosterMediaType = "image/jpeg"
+	case mp4CoverArtPNG:
+		info.PosterMediaType = "image/png"
+	default:
+		return nil
+	}
+	info.Poster = payload[8:]
+	return nil
+}
+
+// probeWebM reads the duration from the segment info and the dimensions from the first video track. The clusters
+// holding the frames are skipped, the probing stops at a cluster of unknown size as the rest can not be skipped.
+func probeWebM(content io.ReadSeeker) (info mediaInfo, err error) {
+	reader := &ebmlReader{content: content}
+
+	id, size, err := reader.readElementHeader()
+	if err != nil {
+		return
+	}
+	if id != ebmlHeaderID || size == ebmlUnknownSize {
+		return info, errMalformedMedia
+	}
+	if err = reader.skip(size); err != nil {
+		return
+	}
+
+	id, segmentSize, err := reader.readElementHeader()
+	if err != nil {
+		return
+	}
+	if id != ebmlSegmentID {
+		return info, errMalformedMedia
+	}
+
+	segmentEnd := int64(math.MaxInt64)
+	if segmentSize != ebmlUnknownSize {
+		segmentEnd = reader.offset + segmentSize
+	}
+
+	timescale, duration := uint64(1_000_000), 0.0
+	foundInfo, foundTracks := false, false
+
+	for reader.offset < segmentEnd && !(foundInfo && foundTracks) {
+		id, size, headerErr := reader.readElementHeader()
+		if headerErr == io.EOF || headerErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if headerErr != nil {
+			return info, headerErr
+		}
+		if size == ebmlUnknownSize {
+			break
+		}
+
+		switch id {
+		case ebmlInfoID:
+			foundInfo = true
+			err = reader.readChildren(size, func(childID uint64, value []byte) {
+				switch childID {
+				case ebmlTimescaleID:
+					timescale = ebmlUint(value)
+				case ebmlDurationID:
+					duration = ebmlFloat(value)
+				}
+			}, nil)
+		case ebmlTracksID:
+			foundTracks = true
+			err = reader.readChildren(size, nil, map[uint64]func(childID uint64, value []byte){
+				ebmlTrackEntryID: nil,
+				ebmlVideoID: func(childID uint64, value []byte) {
+					switch {
+					case childID == ebmlPixelWidth && info.Width == 0:
+						info.Width = int(ebmlUint(value))
+					case childID == ebmlPixelHeight && info.Height == 0:
+						info.Height = int(ebmlUint(value))
+					}
+				},
+			})
+		default:
+			err = reader.skip(size)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	// the duration is in the timescale units, which are nanoseconds
+	if duration > 0 {
+		info.Duration = roundDuration(duration * float64(timescale) / 1e9)
+	}
+	return
+}
+
+// ebmlReader reads the EBML elements (the container format of WebM) keeping track of the offset
+type ebmlReader struct {
+	content io.ReadSeeker
+	offset  int64
+}
+
+func (reader *ebmlReader) readElementHeader() (id uint64, size int64, err error) {
+	id, _, err = reader.readVarInt(true)
+	if err != nil {
+		return
+	}
+
+	rawSize, length, err := reader.readVarInt(false)
+	if err != nil {
+		return
+	}
+	if rawSize == (uint64(1)<<(7*length))-1 {
+		return id, ebmlUnknownSize, nil
+	}
+	if rawSize > math.MaxInt64 {
+		return 0, 0, errMalformedMedia
+	}
+	return id, int64(rawSize), nil
+}
+
+// readVarInt reads the variable length integers of EBML, the element IDs keep their length marker bits
+func (reader *ebmlReader) readVarInt(keepMarker bool) (value uint64, length int, err error) {
+	first := make([]byte, 1)
+	if _, err = io.ReadFull(reader.content, first); err != nil {
+		return
+	}
+	reader.offset++
+
+	for length = 1; length <= 8 && first[0]&(0x80>>(length-1)) == 0; length++ {
+	}
+	if length > 8 {
+		return 0, 0, errMalformedMedia
+	}
+
+	value = uint64(first[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+
+	rest := make([]byte, length-1)
+	if _, err = io.ReadFull(reader.content, rest); err != nil {
+		return
+	}
+	reader.offset += int64(len(rest))
+
+	for _, octet := range rest {
+		value = value<<8 | uint64(octet)
+	}
+	return
+}
+
+// readChildren reads the child elements of the master element of the size. The values of the children are passed to
+// the visit function, the children which are master elements themselves are descended into if they are listed in the
+// nested visits (with their own visit function, or the parent's if it is nil).
+func (reader *ebmlReader) readChildren(size int64, visit func(childID uint64, value []byte), nested map[uint64]func(childID uint64, value []byte)) error {
+	end := reader.offset + size
+
+	for reader.offset < end {
+		id, childSize, err := reader.readElementHeader()
+		if err != nil {
+			return err
+		}
+		if childSize == ebmlUnknownSize || reader.offset+childSize > end {
+			return errMalformedMedia
+		}
+
+		if nestedVisit, isNested := nested[id]; isNested {
+			if nestedVisit == nil {
+				nestedVisit = visit
+			}
+			if err = reader.readChildren(childSize, nestedVisit, nested); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if visit == nil || childSize > maxProbedElementSize {
+			if err = reader.skip(childSize); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value := make([]byte, childSize)
+		if _, err = io.ReadFull(reader.content, value); err != nil {
+			return err
+		}
+		reader.offset += childSize
+		visit(id, value)
+	}
+	return nil
+}
+
+func (reader *ebmlReader) skip(size int64) (err error) {
+	reader.offset, err = reader.content.Seek(size, io.SeekCurrent)
+	return
+}
+
+func ebmlUint(value []byte) (number uint64) {
+	for _, octet := range value {
+		number = number<<8 | uint64(octet)
+	}
+	return
+}
+
+func ebmlFloat(value []byte) float64 {
+	switch len(value) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(value)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(value))
+	default:
+		return 0
+	}
+}
+
+func readMediaPayload(content io.ReadSeeker, start, end int64) ([]byte, error) {
+	if end-start > maxProbedElementSize && end-start > uploadLimits.MaxImageSize {
+		return nil, fmt.Errorf("%w - the box of %d bytes is too large", errMalformedMedia, end-start)
+	}
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, end-start)
+	_, err := io.ReadFull(content, payload)
+	return payload, err
+}
+
+// roundDuration rounds the duration in seconds to milliseconds
+func roundDuration(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
@@ -0,0 +1,288 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+func mp4Box(boxType string, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(box, boxType...), payload...)
+}
+
+// mp4LargeBox has the 64-bit size following the type
+func mp4LargeBox(boxType string, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	box := append(binary.BigEndian.AppendUint32(nil, 1), boxType...)
+	box = binary.BigEndian.AppendUint64(box, uint64(16+len(payload)))
+	return append(box, payload...)
+}
+
+func mp4MovieHeader(timescale, duration uint32) []byte {
+	payload := make([]byte, 100)
+	binary.BigEndian.PutUint32(payload[12:16], timescale)
+	binary.BigEndian.PutUint32(payload[16:20], duration)
+	return mp4Box("mvhd", payload)
+}
+
+func mp4LargeMovieHeader(timescale uint32, duration uint64) []byte {
+	payload := make([]byte, 112)
+	payload[0] = 1
+	binary.BigEndian.PutUint32(payload[20:24], timescale)
+	binary.BigEndian.PutUint64(payload[24:32], duration)
+	return mp4Box("mvhd", payload)
+}
+
+func mp4TrackHeader(width, height int) []byte {
+	payload := make([]byte, 84)
+	binary.BigEndian.PutUint32(payload[76:80], uint32(width)<<16)
+	binary.BigEndian.PutUint32(payload[80:84], uint32(height)<<16)
+	return mp4Box("tkhd", payload)
+}
+
+func mp4CoverArt(dataType uint32, image []byte) []byte {
+	data := append(binary.BigEndian.AppendUint32(nil, dataType), 0, 0, 0, 0)
+	covr := mp4Box("covr", mp4Box("data", append(data, image...)))
+	return mp4Box("udta", mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("hdlr", make([]byte, 25)), mp4Box("ilst", covr)))
+}
+
+func TestProbeMP4(t *testing.T) {
+	fileType := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	mediaData := mp4Box("mdat", make([]byte, 64))
+	audioTrack := mp4Box("trak", mp4TrackHeader(0, 0))
+	videoTrack := mp4Box("trak", mp4TrackHeader(1920, 1080))
+	poster := []byte("\xFF\xD8poster")
+
+	testCases := []struct {
+		name    string
+		content []byte
+		info    mediaInfo
+		err     error
+	}{
+		{
+			name:    "minimal",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4MovieHeader(1000, 2500), videoTrack), mediaData}, nil),
+			info:    mediaInfo{Duration: 2.5, Width: 1920, Height: 1080},
+		},
+		{
+			name:    "movie after the media data",
+			content: bytes.Join([][]byte{fileType, mediaData, mp4Box("moov", mp4MovieHeader(600, 1000), videoTrack)}, nil),
+			info:    mediaInfo{Duration: 1.667, Width: 1920, Height: 1080},
+		},
+		{
+			name:    "audio track first",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4MovieHeader(1000, 2500), audioTrack, videoTrack)}, nil),
+			info:    mediaInfo{Duration: 2.5, Width: 1920, Height: 1080},
+		},
+		{
+			name:    "64-bit sizes and duration",
+			content: bytes.Join([][]byte{fileType, mp4LargeBox("moov", mp4LargeMovieHeader(90_000, 90_000*7200), videoTrack), mp4LargeBox("mdat", make([]byte, 8))}, nil),
+			info:    mediaInfo{Duration: 7200, Width: 1920, Height: 1080},
+		},
+		{
+			name:    "media data to the end of the file",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4MovieHeader(1000, 2500)), binary.BigEndian.AppendUint32(nil, 0), []byte("mdat"), make([]byte, 32)}, nil),
+			info:    mediaInfo{Duration: 2.5},
+		},
+		{
+			name:    "unknown duration",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4MovieHeader(1000, math.MaxUint32), videoTrack)}, nil),
+			info:    mediaInfo{Width: 1920, Height: 1080},
+		},
+		{
+			name:    "cover art",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4MovieHeader(1000, 2500), videoTrack, mp4CoverArt(mp4CoverArtJPEG, poster))}, nil),
+			info:    mediaInfo{Duration: 2.5, Width: 1920, Height: 1080, Poster: poster, PosterMediaType: "image/jpeg"},
+		},
+		{
+			name:    "unsupported cover art",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4MovieHeader(1000, 2500), mp4CoverArt(27, poster))}, nil),
+			info:    mediaInfo{Duration: 2.5},
+		},
+		{
+			name:    "box past the end",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4MovieHeader(1000, 2500), videoTrack)[:60]}, nil),
+			err:     errMalformedMedia,
+		},
+		{
+			name:    "box smaller than its header",
+			content: bytes.Join([][]byte{fileType, binary.BigEndian.AppendUint32(nil, 4), []byte("moov")}, nil),
+			err:     errMalformedMedia,
+		},
+		{
+			name:    "truncated 64-bit size",
+			content: bytes.Join([][]byte{fileType, binary.BigEndian.AppendUint32(nil, 1), []byte("moov"), {0, 0, 0, 0}}, nil),
+			err:     io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "truncated movie header",
+			content: bytes.Join([][]byte{fileType, mp4Box("moov", mp4Box("mvhd", make([]byte, 12)))}, nil),
+			err:     errMalformedMedia,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			info, err := probeMP4(bytes.NewReader(testCase.content))
+			if testCase.err != nil {
+				if !errors.Is(err, testCase.err) {
+					t.Fatalf("expected %v, got %v", testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectMediaInfo(t, info, testCase.info)
+		})
+	}
+}
+
+// ebmlElement encodes the element with an 8 byte size, the IDs keep their length marker bits
+func ebmlElement(id uint64, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	element := bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, id), "\x00")
+	element = append(element, 0x01)
+	element = append(element, binary.BigEndian.AppendUint64(nil, uint64(len(payload)))[1:]...)
+	return append(element, payload...)
+}
+
+// ebmlUnknownSizeElement starts an element streamed without its size
+func ebmlUnknownSizeElement(id uint64, children ...[]byte) []byte {
+	element := bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, id), "\x00")
+	element = append(element, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	return append(element, bytes.Join(children, nil)...)
+}
+
+func ebmlUintElement(id, value uint64) []byte {
+	return ebmlElement(id, bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, value), "\x00"))
+}
+
+func TestProbeWebM(t *testing.T) {
+	header := ebmlElement(ebmlHeaderID, ebmlElement(0x4286, []byte{1}), ebmlElement(ebmlDocTypeID, []byte("webm")))
+	segmentInfo := ebmlElement(ebmlInfoID,
+		ebmlUintElement(ebmlTimescaleID, 1_000_000),
+		ebmlElement(ebmlDurationID, binary.BigEndian.AppendUint64(nil, math.Float64bits(2500))),
+	)
+	tracks := ebmlElement(ebmlTracksID,
+		ebmlElement(ebmlTrackEntryID, ebmlUintElement(0xD7, 1), ebmlUintElement(0x83, 2)),
+		ebmlElement(ebmlTrackEntryID, ebmlUintElement(0xD7, 2), ebmlElement(ebmlVideoID,
+			ebmlUintElement(ebmlPixelWidth, 640),
+			ebmlUintElement(ebmlPixelHeight, 360),
+		)),
+	)
+	cluster := ebmlElement(0x1F43B675, ebmlUintElement(0xE7, 0), ebmlElement(0xA3, make([]byte, 32)))
+
+	testCases := []struct {
+		name    string
+		content []byte
+		info    mediaInfo
+		err     error
+	}{
+		{
+			name:    "minimal",
+			content: bytes.Join([][]byte{header, ebmlElement(ebmlSegmentID, segmentInfo, tracks, cluster)}, nil),
+			info:    mediaInfo{Duration: 2.5, Width: 640, Height: 360},
+		},
+		{
+			name: "float32 duration and a custom timescale",
+			content: bytes.Join([][]byte{header, ebmlElement(ebmlSegmentID, ebmlElement(ebmlInfoID,
+				ebmlUintElement(ebmlTimescaleID, 1_000),
+				ebmlElement(ebmlDurationID, binary.BigEndian.AppendUint32(nil, math.Float32bits(1_500_000))),
+			), tracks)}, nil),
+			info: mediaInfo{Duration: 1.5, Width: 640, Height: 360},
+		},
+		{
+			name:    "cluster before the tracks",
+			content: bytes.Join([][]byte{header, ebmlElement(ebmlSegmentID, segmentInfo, cluster, tracks)}, nil),
+			info:    mediaInfo{Duration: 2.5, Width: 640, Height: 360},
+		},
+		{
+			name:    "unknown size segment",
+			content: bytes.Join([][]byte{header, ebmlUnknownSizeElement(ebmlSegmentID, segmentInfo, tracks, cluster)}, nil),
+			info:    mediaInfo{Duration: 2.5, Width: 640, Height: 360},
+		},
+		{
+			name:    "unknown size cluster before the tracks",
+			content: bytes.Join([][]byte{header, ebmlUnknownSizeElement(ebmlSegmentID, segmentInfo, ebmlUnknownSizeElement(0x1F43B675, ebmlUintElement(0xE7, 0)), tracks)}, nil),
+			info:    mediaInfo{Duration: 2.5},
+		},
+		{
+			name:    "truncated after the info",
+			content: bytes.Join([][]byte{header, ebmlUnknownSizeElement(ebmlSegmentID, segmentInfo, tracks[:5])}, nil),
+			info:    mediaInfo{Duration: 2.5},
+		},
+		{
+			name:    "truncated info",
+			content: bytes.Join([][]byte{header, ebmlElement(ebmlSegmentID, segmentInfo[:len(segmentInfo)-4])}, nil),
+			err:     io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "child past its parent",
+			content: bytes.Join([][]byte{header, ebmlElement(ebmlSegmentID, ebmlElement(ebmlTracksID, ebmlElement(ebmlTrackEntryID, make([]byte, 8))[:9]), make([]byte, 16))}, nil),
+			err:     errMalformedMedia,
+		},
+		{
+			name:    "not an EBML document",
+			content: ebmlElement(ebmlSegmentID, segmentInfo),
+			err:     errMalformedMedia,
+		},
+		{
+			name:    "invalid variable length integer",
+			content: bytes.Join([][]byte{header, {0x18, 0x53, 0x80, 0x67, 0x00}}, nil),
+			err:     errMalformedMedia,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			info, err := probeWebM(bytes.NewReader(testCase.content))
+			if testCase.err != nil {
+				if !errors.Is(err, testCase.err) {
+					t.Fatalf("expected %v, got %v", testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectMediaInfo(t, info, testCase.info)
+		})
+	}
+}
+
+func TestIsWebM(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content []byte
+		isWebM  bool
+	}{
+		{name: "webm", content: ebmlElement(ebmlHeaderID, ebmlElement(ebmlDocTypeID, []byte("webm"))), isWebM: true},
+		{name: "padded document type", content: ebmlElement(ebmlHeaderID, ebmlElement(ebmlDocTypeID, []byte("webm\x00"))), isWebM: true},
+		{name: "matroska", content: ebmlElement(ebmlHeaderID, ebmlElement(ebmlDocTypeID, []byte("matroska"))), isWebM: false},
+		{name: "no document type", content: ebmlElement(ebmlHeaderID, ebmlUintElement(0x4286, 1)), isWebM: false},
+		{name: "unknown size header", content: ebmlUnknownSizeElement(ebmlHeaderID, ebmlElement(ebmlDocTypeID, []byte("webm"))), isWebM: false},
+		{name: "not EBML", content: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), isWebM: false},
+	}
+
+	for _, testCase := range testCases {
+		if isWebM := isWebM(testCase.content); isWebM != testCase.isWebM {
+			t.Errorf("expected %s to be WebM: %v, got %v", testCase.name, testCase.isWebM, isWebM)
+		}
+	}
+}
+
+func expectMediaInfo(t *testing.T, info, expected mediaInfo) {
+	t.Helper()
+	if info.Duration != expected.Duration || info.Width != expected.Width || info.Height != expected.Height {
+		t.Errorf("expected %vs %dx%d, got %vs %dx%d", expected.Duration, expected.Width, expected.Height, info.Duration, info.Width, info.Height)
+	}
+	if !bytes.Equal(info.Poster, expected.Poster) || info.PosterMediaType != expected.PosterMediaType {
+		t.Errorf("expected the %q poster %q, got the %q poster %q", expected.PosterMediaType, expected.Poster, info.PosterMediaType, info.Poster)
+	}
+}
@@ -0,0 +1,204 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	orientedJPEGQuality = 92
+	exifOrientationTag  = 0x0112
+	maxEXIFChunkSize    = 1 << 20
+)
+
+var (
+	keepOriginals = false
+
+	jpegSignature = []byte{0xFF, 0xD8}
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader    = []byte("Exif\x00\x00")
+
+	// strippedPNGChunks hold the EXIF data and free-form text (camera, author, software, timestamps)
+	strippedPNGChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+)
+
+// ConfigureOriginalArchival enables storing the untouched uploads (with all their metadata) under the originals/
+// prefix before the metadata is stripped. The prefix is never referenced by the collections nor served by the
+// media endpoint, and the originals are stored without the public ACL.
+func ConfigureOriginalArchival(enabled bool) {
+	keepOriginals = enabled
+}
+
+// stripImageMetadata streams the image without the privacy-sensitive metadata (EXIF with GPS coordinates and
+// camera serials, XMP, IPTC and comments) from JPEG and PNG images. The metadata segments are dropped without
+// re-encoding and the EXIF orientation is returned (0 if not set), as it is lost along with the rest of the EXIF
+// and the caller has to rotate the pixels instead. Other formats are copied as they are.
+func stripImageMetadata(destination io.Writer, source io.Reader) (orientation int, err error) {
+	reader := bufio.NewReader(source)
+	signature, _ := reader.Peek(len(pngSignature))
+
+	switch {
+	case bytes.HasPrefix(signature, jpegSignature):
+		return stripJPEGMetadata(destination, reader)
+	case bytes.HasPrefix(signature, pngSignature):
+		return stripPNGMetadata(destination, reader)
+	default:
+		_, err = io.Copy(destination, reader)
+		return
+	}
+}
+
+// stripJPEGMetadata copies the JPEG segments up to the image data, leaving out APP1 (EXIF and XMP), APP13 (IPTC)
+// and the comments. The JFIF, ICC profile and Adobe segments are kept as they affect the colours. The segments
+// are at most 64KB, the image data after them is streamed.
+func stripJPEGMetadata(destination io.Writer, reader *bufio.Reader) (orientation int, err error) {
+	if _, err = reader.Discard(len(jpegSignature)); err != nil {
+		return
+	}
+	if _, err = destination.Write(jpegSignature); err != nil {
+		return
+	}
+
+	for {
+		prefix, readErr := reader.ReadByte()
+		if readErr != nil || prefix != 0xFF {
+			return 0, errors.New("invalid JPEG segment marker")
+		}
+
+		marker, readErr := reader.ReadByte()
+		// fill bytes
+		for readErr == nil && marker == 0xFF {
+			marker, readErr = reader.ReadByte()
+		}
+		if readErr != nil {
+			return 0, errors.New("truncated JPEG segment")
+		}
+
+		// the standalone markers have no length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			if _, err = destination.Write([]byte{0xFF, marker}); err != nil {
+				return
+			}
+			continue
+		}
+
+		// the start of scan is followed by the entropy-coded image data up to the end of the file
+		if marker == 0xDA {
+			if _, err = destination.Write([]byte{0xFF, marker}); err != nil {
+				return
+			}
+			_, err = io.Copy(destination, reader)
+			return
+		}
+
+		header := []byte{0xFF, marker, 0, 0}
+		if _, err = io.ReadFull(reader, header[2:]); err != nil {
+			return 0, errors.New("truncated JPEG segment")
+		}
+
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 {
+			return 0, errors.New("invalid JPEG segment length")
+		}
+
+		payload := make([]byte, length-2)
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			return 0, errors.New("truncated JPEG segment")
+		}
+
+		switch marker {
+		case 0xE1:
+			if bytes.HasPrefix(payload, exifHeader) {
+				orientation = exifOrientation(payload[len(exifHeader):])
+			}
+		case 0xED, 0xFE:
+		default:
+			if _, err = destination.Write(append(header, payload...)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// stripPNGMetadata copies the PNG chunks, leaving out the EXIF and text chunks. The chunk data is streamed.
+func stripPNGMetadata(destination io.Writer, reader *bufio.Reader) (orientation int, err error) {
+	if _, err = reader.Discard(len(pngSignature)); err != nil {
+		return
+	}
+	if _, err = destination.Write(pngSignature); err != nil {
+		return
+	}
+
+	for {
+		// length and type, followed by the data and CRC
+		header := make([]byte, 8)
+		if _, err = io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return orientation, nil
+			}
+			return 0, errors.New("truncated PNG chunk")
+		}
+
+		remaining := int64(binary.BigEndian.Uint32(header[:4])) + 4
+		chunkType := string(header[4:])
+
+		switch {
+		case chunkType == "eXIf" && remaining <= maxEXIFChunkSize:
+			chunk := make([]byte, remaining)
+			if _, err = io.ReadFull(reader, chunk); err != nil {
+				return 0, errors.New("truncated PNG chunk")
+			}
+			orientation = exifOrientation(chunk[:remaining-4])
+		case strippedPNGChunks[chunkType]:
+			if _, err = io.CopyN(io.Discard, reader, remaining); err != nil {
+				return 0, errors.New("truncated PNG chunk")
+			}
+		default:
+			if _, err = destination.Write(header); err != nil {
+				return
+			}
+			if _, err = io.CopyN(destination, reader, remaining); err != nil {
+				return 0, errors.New("truncated PNG chunk")
+			}
+		}
+	}
+}
+
+// exifOrientation reads the orientation tag from the first IFD of the EXIF (TIFF structured) data, 0 means the
+// orientation is not set
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var byteOrder binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifdOffset := int(byteOrder.Uint32(tiff[4:8]))
+	if ifdOffset+2 > len(tiff) || ifdOffset < 8 {
+		return 0
+	}
+
+	entries := int(byteOrder.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for entry := 0; entry < entries; entry++ {
+		entryOffset := ifdOffset + 2 + entry*12
+		if entryOffset+12 > len(tiff) {
+			return 0
+		}
+
+		if byteOrder.Uint16(tiff[entryOffset:entryOffset+2]) == exifOrientationTag {
+			return int(byteOrder.Uint16(tiff[entryOffset+8 : entryOffset+10]))
+		}
+	}
+	return 0
+}
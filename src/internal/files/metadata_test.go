@@ -0,0 +1,143 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"testing"
+)
+
+func TestStripImageMetadataJPEG(t *testing.T) {
+	encoded := encodeTestJPEG(t, 4, 2)
+	segments := append(jpegSegment(0xE1, append(append([]byte{}, exifHeader...), orientationTIFF(binary.BigEndian, 6)...)),
+		jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPS</x:xmpmeta>"))...)
+	segments = append(segments, jpegSegment(0xED, []byte("Photoshop 3.0\x00IPTC"))...)
+	segments = append(segments, jpegSegment(0xFE, []byte("taken at home"))...)
+	withMetadata := append(append(append([]byte{}, encoded[:2]...), segments...), encoded[2:]...)
+
+	stripped := &bytes.Buffer{}
+	orientation, err := stripImageMetadata(stripped, bytes.NewReader(withMetadata))
+	if err != nil {
+		t.Fatalf("expected the JPEG to be stripped, got %s", err.Error())
+	}
+	if orientation != 6 {
+		t.Fatalf("expected the orientation 6, got %d", orientation)
+	}
+	if !bytes.Equal(stripped.Bytes(), encoded) {
+		t.Fatal("expected the metadata segments to be removed and everything else to be kept")
+	}
+	if _, err = jpeg.Decode(stripped); err != nil {
+		t.Fatalf("expected the stripped JPEG to be decoded, got %s", err.Error())
+	}
+}
+
+func TestStripImageMetadataPNG(t *testing.T) {
+	encoded := encodeTestPNG(t, 4, 2)
+	// the chunks are inserted after the signature and the IHDR chunk
+	headerLength := len(pngSignature) + 25
+	chunks := append(pngChunk("eXIf", orientationTIFF(binary.LittleEndian, 3)), pngChunk("tEXt", []byte("Author\x00someone"))...)
+	chunks = append(chunks, pngChunk("tIME", []byte{0x07, 0xea, 1, 1, 0, 0, 0})...)
+	withMetadata := append(append(append([]byte{}, encoded[:headerLength]...), chunks...), encoded[headerLength:]...)
+
+	stripped := &bytes.Buffer{}
+	orientation, err := stripImageMetadata(stripped, bytes.NewReader(withMetadata))
+	if err != nil {
+		t.Fatalf("expected the PNG to be stripped, got %s", err.Error())
+	}
+	if orientation != 3 {
+		t.Fatalf("expected the orientation 3, got %d", orientation)
+	}
+	if !bytes.Equal(stripped.Bytes(), encoded) {
+		t.Fatal("expected the metadata chunks to be removed and everything else to be kept")
+	}
+}
+
+func TestStripImageMetadataCopiesOtherContent(t *testing.T) {
+	content := []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)
+	stripped := &bytes.Buffer{}
+	if orientation, err := stripImageMetadata(stripped, bytes.NewReader(content)); err != nil || orientation != 0 {
+		t.Fatalf("expected the content to be copied, got %d and %v", orientation, err)
+	}
+	if !bytes.Equal(stripped.Bytes(), content) {
+		t.Fatal("expected the content to be copied unchanged")
+	}
+}
+
+func TestStripImageMetadataRejectsMalformedImages(t *testing.T) {
+	encodedJPEG := encodeTestJPEG(t, 4, 2)
+	encodedPNG := encodeTestPNG(t, 4, 2)
+
+	testCases := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "JPEG without a segment marker", content: []byte{0xFF, 0xD8, 0x00, 0xE1}},
+		{name: "JPEG ending after the marker", content: []byte{0xFF, 0xD8, 0xFF, 0xFF}},
+		{name: "JPEG segment without the length", content: []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}},
+		{name: "JPEG segment length below 2", content: []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01}},
+		{name: "truncated JPEG segment", content: encodedJPEG[:8]},
+		{name: "truncated PNG chunk header", content: encodedPNG[:len(pngSignature)+4]},
+		{name: "truncated PNG chunk", content: encodedPNG[:len(pngSignature)+20]},
+		{name: "truncated PNG text chunk", content: append(append([]byte{}, pngSignature...), pngChunk("tEXt", []byte("comment"))[:12]...)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := stripImageMetadata(&bytes.Buffer{}, bytes.NewReader(testCase.content)); err == nil {
+				t.Fatal("expected the malformed image to be rejected")
+			}
+		})
+	}
+}
+
+func TestEXIFOrientation(t *testing.T) {
+	withoutOrientation := orientationTIFF(binary.BigEndian, 6)
+	// the tag of the only entry is changed to the image width
+	withoutOrientation[11] = 0x00
+
+	testCases := []struct {
+		name        string
+		tiff        []byte
+		orientation int
+	}{
+		{name: "big endian", tiff: orientationTIFF(binary.BigEndian, 8), orientation: 8},
+		{name: "little endian", tiff: orientationTIFF(binary.LittleEndian, 5), orientation: 5},
+		{name: "without the orientation tag", tiff: withoutOrientation, orientation: 0},
+		{name: "unknown byte order", tiff: append([]byte("XX"), orientationTIFF(binary.BigEndian, 6)[2:]...), orientation: 0},
+		{name: "too short", tiff: []byte("MM\x00\x2a"), orientation: 0},
+		{name: "IFD offset beyond the data", tiff: []byte("MM\x00\x2a\x00\x00\x01\x00"), orientation: 0},
+		{name: "IFD offset inside the header", tiff: []byte("MM\x00\x2a\x00\x00\x00\x02\x00\x01"), orientation: 0},
+		{name: "entries beyond the data", tiff: orientationTIFF(binary.BigEndian, 6)[:16], orientation: 0},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if orientation := exifOrientation(testCase.tiff); orientation != testCase.orientation {
+				t.Fatalf("expected the orientation %d, got %d", testCase.orientation, orientation)
+			}
+		})
+	}
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := binary.BigEndian.AppendUint16([]byte{0xFF, marker}, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// orientationTIFF builds the EXIF data with the orientation as the only entry of the first IFD
+func orientationTIFF(byteOrder binary.AppendByteOrder, orientation uint16) []byte {
+	tiff := []byte("MM")
+	if byteOrder == binary.LittleEndian {
+		tiff = []byte("II")
+	}
+	tiff = byteOrder.AppendUint16(tiff, 42)
+	tiff = byteOrder.AppendUint32(tiff, 8)
+	tiff = byteOrder.AppendUint16(tiff, 1)
+	tiff = byteOrder.AppendUint16(tiff, exifOrientationTag)
+	// the SHORT type, a single value padded to 4 bytes
+	tiff = byteOrder.AppendUint16(tiff, 3)
+	tiff = byteOrder.AppendUint32(tiff, 1)
+	tiff = byteOrder.AppendUint16(tiff, orientation)
+	tiff = byteOrder.AppendUint16(tiff, 0)
+	return byteOrder.AppendUint32(tiff, 0)
+}
@@ -0,0 +1,133 @@
+package files
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+// minOrphanGracePeriod protects the objects of the uploads in progress, which are stored before the collections
+// reference them
+const minOrphanGracePeriod = 1 * time.Hour
+
+// OrphanCollectionConfig configures the background orphan collection. The collection is disabled without an
+// interval and only reports the orphans unless the deletion is enabled. The protected keys are never collected.
+type OrphanCollectionConfig struct {
+	Interval      time.Duration
+	GracePeriod   time.Duration
+	Delete        bool
+	ProtectedKeys []string
+}
+
+var (
+	ErrInvalidGracePeriod = fmt.Errorf("the grace period has to be at least %s", minOrphanGracePeriod)
+
+	orphanCollection = OrphanCollectionConfig{GracePeriod: 72 * time.Hour}
+)
+
+// ConfigureOrphanCollection sets the background collection options, the zero grace period keeps the default
+func ConfigureOrphanCollection(collectionConfig OrphanCollectionConfig) {
+	if collectionConfig.GracePeriod == 0 {
+		collectionConfig.GracePeriod = orphanCollection.GracePeriod
+	}
+	orphanCollection = collectionConfig
+}
+
+// DefaultOrphanGracePeriod gets the configured grace period of the orphans
+func DefaultOrphanGracePeriod() time.Duration {
+	return orphanCollection.GracePeriod
+}
+
+// CollectOrphans finds the stored objects which are not referenced from the users collections (the images with
+// their variants, originals and cached transformations, and the CV), nor by an upload in progress or the trash,
+// and were not modified for the grace period. Unless it is a dry run the orphans are deleted - limited to the given
+// keys, if any, so a reviewed dry-run report can be confirmed. The report lists the orphans found (and deleted).
+func CollectOrphans(gracePeriod time.Duration, dryRun bool, keys []string) (report OrphanReport, err error) {
+	if gracePeriod < minOrphanGracePeriod {
+		return report, ErrInvalidGracePeriod
+	}
+	report = OrphanReport{Orphans: []utils.ObjectInfo{}, GracePeriod: gracePeriod.String(), DryRun: dryRun}
+
+	objects, err := utils.GetStorage().List("")
+	if err != nil {
+		return
+	}
+
+	references, _, _, err := collectMediaReferences()
+	if err != nil {
+		return
+	}
+
+	var stagingKeys []string
+	err = database.GetMultipleRecords(
+		&stagingKeys,
+		`SELECT staging_key FROM pending_uploads WHERE expires_at > NOW()
+				UNION
+				SELECT staging_key FROM tus_uploads WHERE expires_at > NOW();`,
+	)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-gracePeriod)
+	for _, object := range objects {
+		transformSourceKey, isTransform := transformSource(object.Key)
+		isReferenced := len(references[object.Key]) > 0 ||
+			(isTransform && len(references[transformSourceKey]) > 0) ||
+			slices.Contains(stagingKeys, object.Key) ||
+			strings.HasPrefix(object.Key, trashPrefix) ||
+			slices.Contains(orphanCollection.ProtectedKeys, object.Key)
+		if isReferenced || object.LastModified.After(cutoff) {
+			continue
+		}
+		if len(keys) > 0 && !slices.Contains(keys, object.Key) {
+			continue
+		}
+
+		if !dryRun {
+			if err = utils.GetStorage().Delete(object.Key); err != nil {
+				return
+			}
+		}
+		report.Orphans = append(report.Orphans, object)
+		report.TotalSize += object.Size
+	}
+	return
+}
+
+// StartOrphanCollector runs the orphan collection in the background at the configured interval. Without the
+// deletion enabled the orphans are only logged.
+func StartOrphanCollector() {
+	if orphanCollection.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(orphanCollection.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := CollectOrphans(orphanCollection.GracePeriod, !orphanCollection.Delete, nil)
+			if err != nil {
+				utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Error on collecting the orphaned media")
+				continue
+			}
+			if len(report.Orphans) == 0 {
+				continue
+			}
+
+			orphanKeys := make([]string, 0, len(report.Orphans))
+			for _, orphan := range report.Orphans {
+				orphanKeys = append(orphanKeys, orphan.Key)
+			}
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"orphans": orphanKeys, "total_size": report.TotalSize, "dry_run": report.DryRun}).
+				Warnf("Found %d orphaned media objects", len(report.Orphans))
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+package files
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"math"
+	"portfolio-cms-server/utils"
+	"strings"
+)
+
+const (
+	placeholderSampleWidth = 32
+	lqipWidth              = 16
+	lqipJPEGQuality        = 60
+	blurHashComponentsX    = 4
+	blurHashComponentsY    = 3
+	blurHashCharacters     = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// computePlaceholders calculates the BlurHash, the tiny LQIP thumbnail and the dominant colour of the image. They
+// are all calculated from a downscaled copy so the cost does not depend on the original resolution.
+func computePlaceholders(img *image.RGBA, format string) (placeholders ImagePlaceholders, err error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	sampleWidth := min(width, placeholderSampleWidth)
+	sample := utils.ResizeImage(img, sampleWidth, utils.ScaledHeight(width, height, sampleWidth))
+
+	lqipImageWidth := min(width, lqipWidth)
+	lqip := utils.ResizeImage(img, lqipImageWidth, utils.ScaledHeight(width, height, lqipImageWidth))
+
+	content, contentType, err := encodeImage(lqip, format, lqipJPEGQuality)
+	if err != nil {
+		return
+	}
+
+	placeholders.BlurHash = encodeBlurHash(sample, blurHashComponentsX, blurHashComponentsY)
+	placeholders.LQIP = fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(content))
+	placeholders.DominantColor = dominantColor(sample)
+	return
+}
+
+// encodeBlurHash implements the BlurHash encoding (https://github.com/woltapp/blurhash). The transparent pixels
+// are blended with white, as the images are mostly displayed on a light background.
+func encodeBlurHash(img *image.RGBA, componentsX, componentsY int) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	factors := make([][3]float64, 0, componentsX*componentsY)
+
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			factor := [3]float64{}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					red, green, blue := opaquePixel(img, x, y)
+					factor[0] += basis * sRGBToLinear(red)
+					factor[1] += basis * sRGBToLinear(green)
+					factor[2] += basis * sRGBToLinear(blue)
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	hash := strings.Builder{}
+	hash.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMaximum := 0.0
+		for _, factor := range factors[1:] {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		quantise := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quantise(factor[0])*19*19+quantise(factor[1])*19+quantise(factor[2]), 2))
+	}
+	return hash.String()
+}
+
+// dominantColor buckets the visible pixels by their most significant colour bits and averages the largest bucket
+func dominantColor(img *image.RGBA) string {
+	type bucket struct {
+		count            int
+		red, green, blue int
+	}
+	buckets := map[int]*bucket{}
+	var dominant *bucket
+
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			if img.RGBAAt(x, y).A < 128 {
+				continue
+			}
+
+			red, green, blue := opaquePixel(img, x, y)
+			key := red>>4<<8 | green>>4<<4 | blue>>4
+			if buckets[key] == nil {
+				buckets[key] = &bucket{}
+			}
+
+			current := buckets[key]
+			current.count++
+			current.red, current.green, current.blue = current.red+red, current.green+green, current.blue+blue
+			if dominant == nil || current.count > dominant.count {
+				dominant = current
+			}
+		}
+	}
+
+	if dominant == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", dominant.red/dominant.count, dominant.green/dominant.count, dominant.blue/dominant.count)
+}
+
+// opaquePixel blends the premultiplied pixel with a white background
+func opaquePixel(img *image.RGBA, x, y int) (red, green, blue int) {
+	pixel := img.RGBAAt(x, y)
+	background := 255 - int(pixel.A)
+	return int(pixel.R) + background, int(pixel.G) + background, int(pixel.B) + background
+}
+
+func sRGBToLinear(value int) float64 {
+	normalised := float64(value) / 255
+	if normalised <= 0.04045 {
+		return normalised / 12.92
+	}
+	return math.Pow((normalised+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	normalised := math.Max(0, math.Min(1, value))
+	if normalised <= 0.0031308 {
+		return int(normalised*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(normalised, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
+
+func encodeBase83(value, length int) string {
+	encoded := make([]byte, length)
+	for index := 0; index < length; index++ {
+		digit := value / int(math.Pow(83, float64(length-index-1))) % 83
+		encoded[index] = blurHashCharacters[digit]
+	}
+	return string(encoded)
+}
@@ -0,0 +1,297 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"time"
+)
+
+const (
+	UploadTargetCV           = "cv"
+	UploadTargetProjectImage = "project-image"
+	UploadTargetJobImage     = "job-image"
+	UploadTargetPartners     = "partners"
+	UploadTargetCarousel     = "carousel"
+	// the attachment targets add a video or document to the attachments of the project or job
+	UploadTargetProjectAttachment = "project-attachment"
+	UploadTargetJobAttachment     = "job-attachment"
+
+	presignedUploadLifetime = 15 * time.Minute
+	// uploadCompletionWindow is the time the client has to complete the upload after the presigned URL expires
+	uploadCompletionWindow = 1 * time.Hour
+)
+
+var (
+	ErrDirectUploadNotSupported = errors.New("the storage backend does not support direct uploads")
+	ErrInvalidUploadTarget      = errors.New("invalid upload target")
+	ErrUploadNotFound           = errors.New("unknown, expired or already completed upload")
+	ErrUploadIncomplete         = errors.New("the file was not uploaded to the storage yet")
+
+	// attachmentTargetCollections maps the attachment upload targets to the collections of their projects or jobs
+	attachmentTargetCollections = map[string]string{
+		UploadTargetProjectAttachment: MediaCollectionProjects,
+		UploadTargetJobAttachment:     MediaCollectionJobs,
+	}
+)
+
+// PresignUpload validates the announced file against the limits of the upload target and signs a direct upload
+// of it to a private staging key. The upload is remembered so it can be completed with CompleteUpload, along with
+// the title and file name of an attachment - whose project or job has to exist. The expired uploads are discarded
+// on the way.
+func PresignUpload(request PresignRequestBody) (presignedUpload PresignedUploadResponse, err error) {
+	uploader, ok := utils.GetDirectUploader()
+	if !ok {
+		return presignedUpload, ErrDirectUploadNotSupported
+	}
+
+	targetName, err := uploadTargetName(request.Target, request.ProjectTitle, request.CompanyName)
+	if err != nil {
+		return
+	}
+
+	uploadType := targetUploadType(request.Target)
+	if request.Size > MaxUploadSize(uploadType) {
+		return presignedUpload, fmt.Errorf("%w - the file exceeds the maximum size of %d bytes", ErrFileTooLarge, MaxUploadSize(uploadType))
+	}
+	if !isAllowedContentType(request.ContentType, uploadType) {
+		return presignedUpload, unsupportedMediaTypeError(uploadType)
+	}
+	if collection, isAttachment := attachmentTargetCollections[request.Target]; isAttachment {
+		if err = checkUploadTarget(collection, targetName); err != nil {
+			return
+		}
+	}
+
+	uploadID, err := uuid.NewRandom()
+	if err != nil {
+		return
+	}
+	stagingKey := "uploads/" + uploadID.String()
+
+	presignedUpload.UploadID = uploadID.String()
+	presignedUpload.PresignedUpload, err = uploader.PresignUpload(
+		stagingKey,
+		request.ContentType,
+		request.Size,
+		MaxUploadSize(uploadType),
+		presignedUploadLifetime,
+	)
+	if err != nil {
+		return
+	}
+
+	discardExpiredUploads()
+
+	_, err = database.ExecuteNamedQuery(
+		`INSERT INTO pending_uploads (id, staging_key, target, target_name, content_type, max_size, title, file_name, expires_at)
+				VALUES (:id, :staging_key, :target, :target_name, :content_type, :max_size, :title, :file_name, :expires_at);`,
+		map[string]interface{}{
+			"id":           presignedUpload.UploadID,
+			"staging_key":  stagingKey,
+			"target":       request.Target,
+			"target_name":  targetName,
+			"content_type": request.ContentType,
+			"max_size":     MaxUploadSize(uploadType),
+			"title":        request.Title,
+			"file_name":    request.FileName,
+			"expires_at":   presignedUpload.ExpiresAt.Add(uploadCompletionWindow),
+		},
+	)
+	return
+}
+
+// discardExpiredUploads deletes the staging objects of the uploads which were not completed in time along with
+// their rows, the failures are only logged as the uploads are retried on the next presigning
+func discardExpiredUploads() {
+	var expiredUploads []pendingUpload
+	err := database.GetMultipleRecords(
+		&expiredUploads,
+		`SELECT id, staging_key, target, target_name, content_type, max_size
+				FROM pending_uploads
+				WHERE expires_at < NOW();`,
+	)
+	if err != nil {
+		utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Failed to get the expired uploads")
+		return
+	}
+
+	for _, upload := range expiredUploads {
+		// the row is kept until the object is deleted, so the failed deletions are retried
+		if err = utils.GetStorage().Delete(upload.StagingKey); err == nil {
+			_, err = database.ExecuteNamedQuery(`DELETE FROM pending_uploads WHERE id = :id;`, map[string]interface{}{"id": upload.ID})
+		}
+		if err != nil {
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": err.Error()}).
+				Warnf("Failed to discard the expired upload - %s", upload.ID)
+		}
+	}
+}
+
+// CompleteUpload verifies the directly uploaded file exists, validates its size and content and processes it the
+// same way as the form data uploads - the result is the updated collection of the target (the attachments of the
+// project or job, or the CV link). The staging object is removed afterwards. An upload can be completed only once.
+func CompleteUpload(uploadID string) (target string, result interface{}, err error) {
+	upload := pendingUpload{}
+	err = database.GetSingleRecordNamedQuery(
+		&upload,
+		`SELECT id, staging_key, target, target_name, content_type, max_size, title, file_name
+				FROM pending_uploads
+				WHERE id = :id
+				  AND expires_at > NOW();`,
+		map[string]interface{}{"id": uploadID},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = ErrUploadNotFound
+		}
+		return
+	}
+
+	info, err := utils.GetStorage().Stat(upload.StagingKey)
+	if errors.Is(err, utils.ErrObjectNotFound) {
+		return "", nil, ErrUploadIncomplete
+	}
+	if err != nil {
+		return
+	}
+
+	// consuming the upload guards against completing it twice concurrently
+	err = database.GetSingleRecordNamedQuery(
+		&upload,
+		`DELETE FROM pending_uploads WHERE id = :id RETURNING id, staging_key, target, target_name, content_type, max_size, title, file_name;`,
+		map[string]interface{}{"id": uploadID},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = ErrUploadNotFound
+		}
+		return
+	}
+	defer func() {
+		_ = utils.GetStorage().Delete(upload.StagingKey)
+	}()
+
+	if info.Size > upload.MaxSize {
+		return "", nil, fmt.Errorf("%w - the file exceeds the maximum size of %d bytes", ErrFileTooLarge, upload.MaxSize)
+	}
+
+	result, err = processStagedUpload(upload)
+	return upload.Target, result, err
+}
+
+// processStagedUpload downloads the uploaded staging object, detects its content type and saves it to the upload
+// target the same way as the form data uploads
+func processStagedUpload(upload pendingUpload) (result interface{}, err error) {
+	content, err := downloadToTemporaryFile(upload.StagingKey)
+	if err != nil {
+		return
+	}
+	defer func() {
+		content.Close()
+		os.Remove(content.Name())
+	}()
+
+	contentType, err := DetectContentType(content, targetUploadType(upload.Target))
+	if err != nil {
+		return
+	}
+	if err = rewind(content); err != nil {
+		return
+	}
+
+	targetName := upload.TargetName
+	switch upload.Target {
+	case UploadTargetCV:
+		version, saveErr := saveCV(content, DefaultCVVariant, "")
+		return version.URL, saveErr
+	case UploadTargetProjectImage:
+		return saveProjectImage(content, contentType, targetName)
+	case UploadTargetJobImage:
+		return saveJobImage(content, contentType, targetName)
+	case UploadTargetPartners:
+		return savePartnerImage(content, contentType)
+	case UploadTargetCarousel:
+		return saveCarouselImage(content, contentType)
+	case UploadTargetProjectAttachment, UploadTargetJobAttachment:
+		info, statErr := content.Stat()
+		if statErr != nil {
+			return nil, statErr
+		}
+		details := Attachment{Size: info.Size(), FileName: upload.FileName, Title: upload.Title}
+		return saveAttachment(content, contentType, attachmentTargetCollections[upload.Target], targetName, details)
+	default:
+		return nil, ErrInvalidUploadTarget
+	}
+}
+
+// uploadTargetName gets the name of the project or job the upload is for
+func uploadTargetName(target, projectTitle, companyName string) (string, error) {
+	isProjectTarget := target == UploadTargetProjectImage || target == UploadTargetProjectAttachment
+	isJobTarget := target == UploadTargetJobImage || target == UploadTargetJobAttachment
+
+	switch {
+	case isProjectTarget && len(projectTitle) == 0:
+		return "", fmt.Errorf("%w - expected projectTitle", ErrInvalidUploadTarget)
+	case isProjectTarget:
+		return projectTitle, nil
+	case isJobTarget && len(companyName) == 0:
+		return "", fmt.Errorf("%w - expected companyName", ErrInvalidUploadTarget)
+	case isJobTarget:
+		return companyName, nil
+	default:
+		return "", nil
+	}
+}
+
+func targetUploadType(target string) string {
+	switch target {
+	case UploadTargetCV:
+		return UploadTypeCV
+	case UploadTargetProjectAttachment, UploadTargetJobAttachment:
+		return UploadTypeAttachment
+	default:
+		return UploadTypeImage
+	}
+}
+
+func isAllowedContentType(contentType, uploadType string) bool {
+	for _, allowedContentType := range AllowedContentTypes(uploadType) {
+		if contentType == allowedContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadToTemporaryFile downloads the stored object to a temporary file, so it can be processed without holding
+// it in memory. The caller should close and remove the file.
+func downloadToTemporaryFile(fileKey string) (file *os.File, err error) {
+	content, _, err := utils.GetStorage().Get(fileKey)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	file, err = os.CreateTemp("", "upload-*")
+	if err != nil {
+		return
+	}
+
+	if _, err = io.Copy(file, content); err == nil {
+		err = rewind(file)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return
+}
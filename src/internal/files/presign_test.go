@@ -0,0 +1,45 @@
+package files
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUploadTargetName(t *testing.T) {
+	testCases := []struct {
+		target     string
+		targetName string
+		uploadType string
+		err        error
+	}{
+		{target: UploadTargetProjectImage, targetName: "Portfolio CMS", uploadType: UploadTypeImage},
+		{target: UploadTargetJobImage, targetName: "Acme", uploadType: UploadTypeImage},
+		{target: UploadTargetProjectAttachment, targetName: "Portfolio CMS", uploadType: UploadTypeAttachment},
+		{target: UploadTargetJobAttachment, targetName: "Acme", uploadType: UploadTypeAttachment},
+		{target: UploadTargetCarousel, uploadType: UploadTypeImage},
+		{target: UploadTargetCV, uploadType: UploadTypeCV},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.target, func(t *testing.T) {
+			targetName, err := uploadTargetName(testCase.target, "Portfolio CMS", "Acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if targetName != testCase.targetName {
+				t.Errorf("expected the target name %q, got %q", testCase.targetName, targetName)
+			}
+			if uploadType := targetUploadType(testCase.target); uploadType != testCase.uploadType {
+				t.Errorf("expected the upload type %s, got %s", testCase.uploadType, uploadType)
+			}
+		})
+	}
+}
+
+func TestUploadTargetNameRequiresTheProjectOrJob(t *testing.T) {
+	for _, target := range []string{UploadTargetProjectImage, UploadTargetJobImage, UploadTargetProjectAttachment, UploadTargetJobAttachment} {
+		if _, err := uploadTargetName(target, "", ""); !errors.Is(err, ErrInvalidUploadTarget) {
+			t.Errorf("expected the %s upload without a project or job to be rejected, got %v", target, err)
+		}
+	}
+}
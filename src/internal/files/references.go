@@ -0,0 +1,247 @@
+package files
+
+import (
+	"fmt"
+	"github.com/goccy/go-json"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"slices"
+)
+
+// decodedCollections are the users collections decoded to generic values, so they can be changed and encoded
+// back without losing the fields the file handling does not know about
+type decodedCollections struct {
+	Projects []map[string]interface{}
+	Jobs     []map[string]interface{}
+	Carousel []interface{}
+	Partners []interface{}
+}
+
+// detachedImage is an image removed from a collection, along with the place it was removed from
+type detachedImage struct {
+	Reference MediaReference
+	Image     map[string]interface{}
+}
+
+// lockImageCollections selects the collections for an update in the transaction
+func lockImageCollections(transaction *database.Transaction) (collections imageCollections, err error) {
+	err = transaction.GetSingleRecord(
+		&collections,
+		`SELECT COALESCE(projects, '[]') AS projects,
+					   COALESCE(jobs, '[]')     AS jobs,
+					   COALESCE(carousel, '[]') AS carousel,
+					   COALESCE(partners, '[]') AS partners,
+					   COALESCE(cv_link, '')    AS cv_link
+				FROM users
+				WHERE id = 1
+				FOR UPDATE;`,
+	)
+	return
+}
+
+// updateImageCollections stores the collections in the transaction
+func updateImageCollections(transaction *database.Transaction, collections imageCollections) (err error) {
+	_, err = transaction.ExecuteNamedQuery(
+		`UPDATE users
+				SET projects = CAST(:projects AS JSONB),
+					jobs     = CAST(:jobs AS JSONB),
+					carousel = CAST(:carousel AS JSONB),
+					partners = CAST(:partners AS JSONB)
+				WHERE id = 1;`,
+		map[string]interface{}{
+			"projects": string(collections.Projects),
+			"jobs":     string(collections.Jobs),
+			"carousel": string(collections.Carousel),
+			"partners": string(collections.Partners),
+		},
+	)
+	return
+}
+
+func decodeCollections(collections imageCollections) (decoded decodedCollections, err error) {
+	if err = json.Unmarshal(collections.Projects, &decoded.Projects); err != nil {
+		return
+	}
+	if err = json.Unmarshal(collections.Jobs, &decoded.Jobs); err != nil {
+		return
+	}
+	if err = json.Unmarshal(collections.Carousel, &decoded.Carousel); err != nil {
+		return
+	}
+	err = json.Unmarshal(collections.Partners, &decoded.Partners)
+	return
+}
+
+func (decoded decodedCollections) encode() (collections imageCollections, err error) {
+	if collections.Projects, err = json.Marshal(decoded.Projects); err != nil {
+		return
+	}
+	if collections.Jobs, err = json.Marshal(decoded.Jobs); err != nil {
+		return
+	}
+	if collections.Carousel, err = json.Marshal(decoded.Carousel); err != nil {
+		return
+	}
+	collections.Partners, err = json.Marshal(decoded.Partners)
+	return
+}
+
+// detachImage removes the references to the image with the given key from the collections - from all of them,
+// or only from the given collection (and the project or job of the name, if any)
+func (decoded *decodedCollections) detachImage(fileKey, collection, name string) (detached []detachedImage) {
+	inScope := func(itemCollection, itemName string) bool {
+		return (len(collection) == 0 || collection == itemCollection) && (len(name) == 0 || name == itemName)
+	}
+
+	for _, project := range decoded.Projects {
+		title, _ := project["title"].(string)
+		if !inScope(MediaCollectionProjects, title) {
+			continue
+		}
+
+		images, removed := removeImage(asList(project["images"]), fileKey, MediaCollectionProjects, title)
+		if len(removed) > 0 {
+			project["images"] = images
+			detached = append(detached, removed...)
+		}
+	}
+
+	for _, job := range decoded.Jobs {
+		company, _ := job["company"].(string)
+		if !inScope(MediaCollectionJobs, company) {
+			continue
+		}
+
+		images, removed := removeImage(asList(job["images"]), fileKey, MediaCollectionJobs, company)
+		if len(removed) > 0 {
+			job["images"] = images
+			detached = append(detached, removed...)
+		}
+	}
+
+	var removed []detachedImage
+	if inScope(MediaCollectionCarousel, "") {
+		decoded.Carousel, removed = removeImage(decoded.Carousel, fileKey, MediaCollectionCarousel, "")
+		detached = append(detached, removed...)
+	}
+
+	if inScope(MediaCollectionPartners, "") {
+		decoded.Partners, removed = removeImage(decoded.Partners, fileKey, MediaCollectionPartners, "")
+		detached = append(detached, removed...)
+	}
+	return
+}
+
+// countImageReferences counts the references to the image with the given key left in the collections, the
+// stored objects are shared by the identical uploads until the last reference is removed
+func (decoded decodedCollections) countImageReferences(fileKey string) (references int) {
+	for _, entry := range decoded.images() {
+		imageObject, _ := entry.(map[string]interface{})
+		imageURL, _ := imageObject["imgURL"].(string)
+		if imageKey, found := utils.GetStorage().KeyFromURL(imageURL); found && imageKey == fileKey {
+			references++
+		}
+	}
+	return
+}
+
+// images gets the images of every collection - the carousel and partners images and the images of the projects
+// and jobs
+func (decoded decodedCollections) images() []interface{} {
+	images := append(append([]interface{}{}, decoded.Carousel...), decoded.Partners...)
+	for _, item := range append(append([]map[string]interface{}{}, decoded.Projects...), decoded.Jobs...) {
+		images = append(images, asList(item["images"])...)
+	}
+	return images
+}
+
+// updatedCollections encodes the images of the collections the references point to, once for each collection
+// and project or job
+func (decoded decodedCollections) updatedCollections(references []MediaReference) (updated []UpdatedCollection, err error) {
+	updated = []UpdatedCollection{}
+	for _, reference := range references {
+		if slices.ContainsFunc(updated, func(collection UpdatedCollection) bool {
+			return collection.Collection == reference.Collection && collection.Name == reference.Name
+		}) {
+			continue
+		}
+
+		images, err := json.Marshal(decoded.collectionImages(reference))
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, UpdatedCollection{Collection: reference.Collection, Name: reference.Name, Images: images})
+	}
+	return
+}
+
+// collectionImages gets the images of the collection the reference points to - the images of the project or the
+// job, or the whole carousel or partners collection
+func (decoded decodedCollections) collectionImages(reference MediaReference) []interface{} {
+	var items []map[string]interface{}
+	nameField := "title"
+
+	switch reference.Collection {
+	case MediaCollectionCarousel:
+		return decoded.Carousel
+	case MediaCollectionPartners:
+		return decoded.Partners
+	case MediaCollectionProjects:
+		items = decoded.Projects
+	case MediaCollectionJobs:
+		items, nameField = decoded.Jobs, "company"
+	}
+
+	for _, item := range items {
+		if name, _ := item[nameField].(string); name == reference.Name {
+			return asList(item["images"])
+		}
+	}
+	return nil
+}
+
+func removeImage(images []interface{}, fileKey, collection, name string) (remaining []interface{}, removed []detachedImage) {
+	remaining = make([]interface{}, 0, len(images))
+
+	for position, entry := range images {
+		imageObject, isObject := entry.(map[string]interface{})
+		imageURL, _ := imageObject["imgURL"].(string)
+
+		if imageKey, found := utils.GetStorage().KeyFromURL(imageURL); isObject && found && imageKey == fileKey {
+			removed = append(removed, detachedImage{
+				Reference: MediaReference{Collection: collection, Name: name, Position: position, Usage: MediaUsageImage},
+				Image:     imageObject,
+			})
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+	return
+}
+
+// imageObjectKeys lists the keys of the stored objects of the image - the image itself, the archived original and
+// the variants. The variants of the configured widths are included even if the image objects do not list them, so
+// the variants are cleaned up for the images which are not referenced any more.
+func imageObjectKeys(fileKey string, imageObjects ...map[string]interface{}) (objectKeys []string) {
+	objectKeys = []string{fileKey, "originals/" + fileKey}
+	for _, variantWidth := range variantWidths {
+		objectKeys = append(objectKeys, fmt.Sprintf("%s-%dw", fileKey, variantWidth))
+	}
+
+	for _, imageObject := range imageObjects {
+		for _, entry := range asList(imageObject["variants"]) {
+			variant, _ := entry.(map[string]interface{})
+			variantURL, _ := variant["url"].(string)
+			variantKey, found := utils.GetStorage().KeyFromURL(variantURL)
+			if found && !slices.Contains(objectKeys, variantKey) {
+				objectKeys = append(objectKeys, variantKey)
+			}
+		}
+	}
+	return
+}
+
+func asList(value interface{}) []interface{} {
+	list, _ := value.([]interface{})
+	return list
+}
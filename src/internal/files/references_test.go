@@ -0,0 +1,165 @@
+package files
+
+import (
+	"errors"
+	"github.com/goccy/go-json"
+	"reflect"
+	"testing"
+)
+
+func TestDetachImage(t *testing.T) {
+	useTestStorage(t)
+
+	testCases := []struct {
+		name       string
+		collection string
+		itemName   string
+		detached   []MediaReference
+		remaining  int
+	}{
+		{
+			name: "every collection",
+			detached: []MediaReference{
+				{Collection: MediaCollectionProjects, Name: "CMS", Position: 1, Usage: MediaUsageImage},
+				{Collection: MediaCollectionProjects, Name: "Blog", Position: 0, Usage: MediaUsageImage},
+				{Collection: MediaCollectionJobs, Name: "Acme", Position: 0, Usage: MediaUsageImage},
+				{Collection: MediaCollectionCarousel, Position: 2, Usage: MediaUsageImage},
+			},
+			remaining: 0,
+		},
+		{
+			name:       "one collection",
+			collection: MediaCollectionProjects,
+			detached: []MediaReference{
+				{Collection: MediaCollectionProjects, Name: "CMS", Position: 1, Usage: MediaUsageImage},
+				{Collection: MediaCollectionProjects, Name: "Blog", Position: 0, Usage: MediaUsageImage},
+			},
+			remaining: 2,
+		},
+		{
+			name:       "one project",
+			collection: MediaCollectionProjects,
+			itemName:   "Blog",
+			detached:   []MediaReference{{Collection: MediaCollectionProjects, Name: "Blog", Position: 0, Usage: MediaUsageImage}},
+			remaining:  3,
+		},
+		{name: "collection without the image", collection: MediaCollectionPartners, remaining: 4},
+		{name: "unknown project", collection: MediaCollectionProjects, itemName: "Shop", remaining: 4},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			decoded := testCollections(t)
+
+			var detached []MediaReference
+			for _, image := range decoded.detachImage("project-shared", testCase.collection, testCase.itemName) {
+				if image.Image["imgURL"] != testStorageURL+"/project-shared" {
+					t.Fatalf("expected only the shared image to be detached, got %v", image.Image)
+				}
+				detached = append(detached, image.Reference)
+			}
+
+			if !reflect.DeepEqual(detached, testCase.detached) {
+				t.Fatalf("expected the references %+v, got %+v", testCase.detached, detached)
+			}
+			if remaining := decoded.countImageReferences("project-shared"); remaining != testCase.remaining {
+				t.Fatalf("expected %d remaining references, got %d", testCase.remaining, remaining)
+			}
+			if others := decoded.countImageReferences("carousel-other"); others != 1 {
+				t.Fatalf("expected the other images to be kept, got %d references", others)
+			}
+		})
+	}
+}
+
+func TestDetachImageKeepsTheOrder(t *testing.T) {
+	useTestStorage(t)
+
+	decoded := testCollections(t)
+	decoded.detachImage("project-shared", MediaCollectionCarousel, "")
+
+	carousel, err := json.Marshal(decoded.Carousel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `["not an image",{"imgURL":"http://localhost:8080/media/carousel-other"}]`
+	if string(carousel) != expected {
+		t.Fatalf("expected the carousel %s, got %s", expected, carousel)
+	}
+}
+
+func TestUpdatedCollectionsKeepsEveryProject(t *testing.T) {
+	useTestStorage(t)
+
+	decoded := testCollections(t)
+	var references []MediaReference
+	for _, image := range decoded.detachImage("project-shared", "", "") {
+		references = append(references, image.Reference)
+	}
+	// the carousel is listed once for its references
+	references = append(references, MediaReference{Collection: MediaCollectionCarousel, Position: 0})
+
+	updated, err := decoded.updatedCollections(references)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []UpdatedCollection{
+		{Collection: MediaCollectionProjects, Name: "CMS", Images: json.RawMessage(`[{"imgURL":"` + testStorageURL + `/project-CMS-1"}]`)},
+		{Collection: MediaCollectionProjects, Name: "Blog", Images: json.RawMessage(`[]`)},
+		{Collection: MediaCollectionJobs, Name: "Acme", Images: json.RawMessage(`[]`)},
+		{Collection: MediaCollectionCarousel, Images: json.RawMessage(`["not an image",{"imgURL":"` + testStorageURL + `/carousel-other"}]`)},
+	}
+	if len(updated) != len(expected) {
+		t.Fatalf("expected %d updated collections, got %d", len(expected), len(updated))
+	}
+	for index, collection := range updated {
+		if collection.Collection != expected[index].Collection || collection.Name != expected[index].Name ||
+			string(collection.Images) != string(expected[index].Images) {
+			t.Fatalf("expected %s %s %s, got %s %s %s", expected[index].Collection, expected[index].Name,
+				expected[index].Images, collection.Collection, collection.Name, collection.Images)
+		}
+	}
+}
+
+func TestAppendImagesKeepsTheExistingImages(t *testing.T) {
+	useTestStorage(t)
+
+	decoded := testCollections(t)
+	added := map[string]interface{}{"imgURL": testStorageURL + "/job-Acme-2"}
+	if err := decoded.appendImages(MediaCollectionJobs, "Acme", []interface{}{added}); err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := json.Marshal(decoded.collectionImages(MediaReference{Collection: MediaCollectionJobs, Name: "Acme"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"imgURL":"` + testStorageURL + `/project-shared"},{"imgURL":"` + testStorageURL + `/job-Acme-2"}]`
+	if string(images) != expected {
+		t.Fatalf("expected the job images %s, got %s", expected, images)
+	}
+
+	if err = decoded.appendImages(MediaCollectionJobs, "Globex", []interface{}{added}); !errors.Is(err, ErrUploadTargetMissing) {
+		t.Fatalf("expected ErrUploadTargetMissing for an unknown job, got %v", err)
+	}
+}
+
+// testCollections references the shared image in two projects, a job and the carousel
+func testCollections(t *testing.T) decodedCollections {
+	shared := `{"imgURL":"` + testStorageURL + `/project-shared"}`
+	decoded, err := decodeCollections(imageCollections{
+		Projects: json.RawMessage(`[
+			{"title":"CMS","images":[{"imgURL":"` + testStorageURL + `/project-CMS-1"},` + shared + `]},
+			{"title":"Blog","images":[` + shared + `]},
+			{"title":"Shop"}
+		]`),
+		Jobs:     json.RawMessage(`[{"company":"Acme","images":[` + shared + `]}]`),
+		Carousel: json.RawMessage(`["not an image",{"imgURL":"` + testStorageURL + `/carousel-other"},` + shared + `]`),
+		Partners: json.RawMessage(`[{"imgURL":"https://elsewhere.example/project-shared"}]`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
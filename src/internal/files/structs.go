@@ -1,11 +1,316 @@
 package files
 
-type ImageDeleteRequestBody struct {
-	ImageURL string `json:"imageURL" valid:"required"`
-}
+import (
+	"github.com/goccy/go-json"
+	"portfolio-cms-server/utils"
+	"time"
+)
 
+// CarouselImages is the carousel image returned by the carousel upload
 type CarouselImages struct {
 	ImgURL string `json:"imgURL" db:"img_url"`
 	Width  int    `json:"width" db:"width"`
 	Height int    `json:"height" db:"height"`
 }
+
+// UpdatedCollection holds the images of the collection changed by the request - the images of the project or job
+// of the name, or the whole carousel or partners collection
+type UpdatedCollection struct {
+	Collection string          `json:"collection"`
+	Name       string          `json:"name,omitempty"`
+	Images     json.RawMessage `json:"images"`
+}
+
+// ImageDeleteRequestBody deletes the image from every collection, or only from the given one (and the project or
+// job of the name) when the image is shared
+type ImageDeleteRequestBody struct {
+	ImageURL   string `json:"imageURL" valid:"required"`
+	Collection string `json:"collection" valid:"in(projects|jobs|carousel|partners)"`
+	Name       string `json:"name"`
+}
+
+// ImageObject is the image stored in the users JSONB collections (projects and jobs images, partners and carousel)
+type ImageObject struct {
+	ImgURL   string         `json:"imgURL"`
+	Width    int            `json:"width"`
+	Height   int            `json:"height"`
+	Variants []ImageVariant `json:"variants,omitempty"`
+	ImagePlaceholders
+	ImageMetadata
+}
+
+// ImageMetadata describes the image for the accessibility and the layout. The alt text is localized by the
+// language tags (e.g. en, de-AT) and the focal point is relative to the image size, so crops can keep it visible.
+type ImageMetadata struct {
+	Alt        map[string]string `json:"alt,omitempty"`
+	Caption    string            `json:"caption,omitempty"`
+	Credit     string            `json:"credit,omitempty"`
+	FocalPoint *FocalPoint       `json:"focalPoint,omitempty"`
+}
+
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// ImagePlaceholders are shown by the frontend while the image is loading
+type ImagePlaceholders struct {
+	BlurHash      string `json:"blurHash,omitempty"`
+	LQIP          string `json:"lqip,omitempty"`
+	DominantColor string `json:"dominantColor,omitempty"`
+}
+
+// ImageVariant is a resized copy of the image, used by the frontend to build the srcset
+type ImageVariant struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type imageCollections struct {
+	Projects json.RawMessage `db:"projects"`
+	Jobs     json.RawMessage `db:"jobs"`
+	Carousel json.RawMessage `db:"carousel"`
+	Partners json.RawMessage `db:"partners"`
+	CVLink   string          `db:"cv_link"`
+}
+
+// collectionItem is a project or a job with its images and attachments, only the fields the file handling needs
+// are decoded
+type collectionItem struct {
+	Title       string        `json:"title"`
+	Company     string        `json:"company"`
+	Images      []ImageObject `json:"images"`
+	Attachments []Attachment  `json:"attachments"`
+}
+
+// Attachment is a video or a document attached to a project or a job. The duration (in seconds), the dimensions
+// and the poster frame are set for the videos they could be read from.
+type Attachment struct {
+	URL       string  `json:"url"`
+	MimeType  string  `json:"mimeType"`
+	Size      int64   `json:"size"`
+	FileName  string  `json:"fileName,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	PosterURL string  `json:"posterURL,omitempty"`
+}
+
+// AttachmentRequestBody is the form data of an attachment upload, to either a project or a job
+type AttachmentRequestBody struct {
+	ProjectTitle string `form:"projectTitle"`
+	CompanyName  string `form:"companyName"`
+	Title        string `form:"title" valid:"maxstringlength(300)"`
+}
+
+// PresignRequestBody announces a direct upload, the title and file name are kept for the attachment targets
+type PresignRequestBody struct {
+	Target       string `json:"target" valid:"required,in(cv|project-image|job-image|partners|carousel|project-attachment|job-attachment)"`
+	ContentType  string `json:"contentType" valid:"required"`
+	Size         int64  `json:"size" valid:"required"`
+	ProjectTitle string `json:"projectTitle"`
+	CompanyName  string `json:"companyName"`
+	Title        string `json:"title" valid:"maxstringlength(300)"`
+	FileName     string `json:"fileName" valid:"maxstringlength(255)"`
+}
+
+type PresignedUploadResponse struct {
+	UploadID string `json:"uploadID"`
+	utils.PresignedUpload
+}
+
+type CompleteUploadRequestBody struct {
+	UploadID string `json:"uploadID" valid:"required"`
+}
+
+// ImageMetadataRequestBody replaces the metadata of the image given by its URL or its ID (the storage key)
+type ImageMetadataRequestBody struct {
+	ImageURL string `json:"imageURL"`
+	ImageID  string `json:"imageID"`
+	ImageMetadata
+}
+
+// ImageTransformOptions are the requested dimensions (0 keeps the aspect ratio), the fit (cover, contain or
+// fill), the JPEG quality and the format (jpeg or png, the format of the image by default) of a transformation
+type ImageTransformOptions struct {
+	Width   int    `form:"w"`
+	Height  int    `form:"h"`
+	Fit     string `form:"fit"`
+	Quality int    `form:"q"`
+	Format  string `form:"fmt"`
+}
+
+type ImageTransformQuery struct {
+	ImageTransformOptions
+	Signature string `form:"s" valid:"required"`
+}
+
+type ImageTransformSignQuery struct {
+	ImageTransformOptions
+	Key string `form:"key" valid:"required"`
+}
+
+// BatchUploadRequest is the form data of a batch image upload, the metadata is an optional JSON array of the image
+// metadata in the order of the images
+type BatchUploadRequest struct {
+	Target       string `form:"target" valid:"required,in(project-image|job-image|partners|carousel)"`
+	ProjectTitle string `form:"projectTitle"`
+	CompanyName  string `form:"companyName"`
+	Metadata     string `form:"metadata"`
+}
+
+type BatchUploadResult struct {
+	Index    int          `json:"index"`
+	FileName string       `json:"fileName"`
+	Uploaded bool         `json:"uploaded"`
+	Error    string       `json:"error,omitempty"`
+	Image    *ImageObject `json:"image,omitempty"`
+}
+
+type BatchUploadReport struct {
+	Results  []BatchUploadResult `json:"results"`
+	Uploaded int                 `json:"uploaded"`
+	Failed   int                 `json:"failed"`
+}
+
+// CVVersion is an uploaded CV of a variant (e.g. frontend, backend) and a language, one of the versions of every
+// variant and language is the current one
+type CVVersion struct {
+	ID        string    `db:"id" json:"id"`
+	Variant   string    `db:"variant" json:"variant"`
+	Language  string    `db:"language" json:"language"`
+	FileKey   string    `db:"file_key" json:"key"`
+	URL       string    `db:"-" json:"url"`
+	Size      int64     `db:"size" json:"size"`
+	Current   bool      `db:"is_current" json:"current"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+type CVVersionsQuery struct {
+	Variant  string `form:"variant"`
+	Language string `form:"language"`
+}
+
+type pendingUpload struct {
+	ID          string `db:"id"`
+	StagingKey  string `db:"staging_key"`
+	Target      string `db:"target"`
+	TargetName  string `db:"target_name"`
+	ContentType string `db:"content_type"`
+	MaxSize     int64  `db:"max_size"`
+	Title       string `db:"title"`
+	FileName    string `db:"file_name"`
+}
+
+// TusCreationRequest is the tus upload creation, the target fields are sent in the Upload-Metadata header
+type TusCreationRequest struct {
+	Length       int64 `valid:"required"`
+	Metadata     string
+	Target       string `valid:"required,in(cv|project-image|job-image|partners|carousel|project-attachment|job-attachment)"`
+	ProjectTitle string
+	CompanyName  string
+	Title        string `valid:"maxstringlength(300)"`
+	FileName     string `valid:"maxstringlength(255)"`
+}
+
+// TusUpload is the state of a resumable upload
+type TusUpload struct {
+	ID              string    `db:"id"`
+	StagingKey      string    `db:"staging_key"`
+	StorageUploadID string    `db:"storage_upload_id"`
+	Target          string    `db:"target"`
+	TargetName      string    `db:"target_name"`
+	Title           string    `db:"title"`
+	FileName        string    `db:"file_name"`
+	Metadata        string    `db:"metadata"`
+	Length          int64     `db:"length"`
+	StoredOffset    int64     `db:"stored_offset"`
+	Parts           int       `db:"parts"`
+	ExpiresAt       time.Time `db:"expires_at"`
+	// Offset is the number of the received bytes, the stored ones and the ones buffered in the local chunk file
+	Offset int64 `db:"-"`
+}
+
+type MediaLibraryQuery struct {
+	Page     int    `form:"page" valid:"range(0|1000000)"`
+	PageSize int    `form:"pageSize" valid:"range(0|200)"`
+	Type     string `form:"type" valid:"in(image|video|document|other)"`
+	Prefix   string `form:"prefix"`
+}
+
+type MediaLibraryPage struct {
+	Objects  []MediaObject `json:"objects"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"pageSize"`
+	Total    int           `json:"total"`
+}
+
+// MediaObject is a stored object with the places it is referenced from. The dimensions are known for images only.
+type MediaObject struct {
+	utils.ObjectInfo
+	URL        string           `json:"url"`
+	Type       string           `json:"type"`
+	Width      int              `json:"width,omitempty"`
+	Height     int              `json:"height,omitempty"`
+	References []MediaReference `json:"references"`
+}
+
+// MediaReference points to the place in the users collections the object is used in. The name is the project
+// title or the job company and the position is the index of the image in its array.
+type MediaReference struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name,omitempty"`
+	Position   int    `json:"position"`
+	Usage      string `json:"usage"`
+}
+
+type OrphanQuery struct {
+	GracePeriod string `form:"gracePeriod"`
+}
+
+// OrphanDeletionRequestBody confirms the deletion of the orphans, optionally limited to the keys of a dry-run report
+type OrphanDeletionRequestBody struct {
+	Confirm     bool     `json:"confirm" valid:"required"`
+	GracePeriod string   `json:"gracePeriod"`
+	Keys        []string `json:"keys"`
+}
+
+type OrphanReport struct {
+	Orphans     []utils.ObjectInfo `json:"orphans"`
+	TotalSize   int64              `json:"totalSize"`
+	GracePeriod string             `json:"gracePeriod"`
+	DryRun      bool               `json:"dryRun"`
+}
+
+// TrashedMedia is a deleted image kept in the trash until it is purged, with the places it was removed from
+type TrashedMedia struct {
+	ID         string             `json:"id"`
+	ImageURL   string             `json:"imageURL"`
+	References []trashedReference `json:"references"`
+	DeletedAt  time.Time          `json:"deletedAt"`
+	PurgeAt    time.Time          `json:"purgeAt"`
+	objects    []trashedObject
+}
+
+type trashedMediaRow struct {
+	ID         string          `db:"id"`
+	ImageURL   string          `db:"image_url"`
+	Objects    json.RawMessage `db:"objects"`
+	References json.RawMessage `db:"image_references"`
+	DeletedAt  time.Time       `db:"deleted_at"`
+	PurgeAt    time.Time       `db:"purge_at"`
+}
+
+// trashedObject is a stored object of the image moved to the trash
+type trashedObject struct {
+	Key      string `json:"key"`
+	TrashKey string `json:"trashKey"`
+}
+
+// trashedReference is the image object as it was in the collection, so it can be restored to the same position
+type trashedReference struct {
+	MediaReference
+	Image map[string]interface{} `json:"image"`
+}
@@ -0,0 +1,282 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	svgContentType = "image/svg+xml"
+	// svgSniffLength is the part of the upload inspected for the svg root element, after the XML declaration,
+	// doctype and comments
+	svgSniffLength = 1024
+	// the default size of the replaced elements, used by the browsers for the SVGs without any dimensions
+	defaultSVGWidth  = 300
+	defaultSVGHeight = 150
+	maxSVGDepth      = 256
+)
+
+var (
+	errVectorImage = errors.New("the vector images have no placeholders")
+
+	// svgRemovedElements are removed with their content, as they run scripts or embed other documents
+	svgRemovedElements = []string{"script", "foreignobject", "iframe", "embed", "object", "handler", "listener"}
+	// svgAnimationElements can set the links to scripts, they are removed when they animate a link attribute
+	svgAnimationElements = []string{"set", "animate", "animatecolor", "animatemotion", "animatetransform"}
+	svgLengthPattern     = regexp.MustCompile(`^\s*([0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)\s*(px)?\s*$`)
+	// cssURLPattern matches the url() references of the stylesheets, only the references to the fragments of the
+	// document are kept
+	cssURLPattern    = regexp.MustCompile(`(?i)url\(\s*(['"]?)\s*([^'")\s]*)\s*(['"]?)\s*\)`)
+	cssImportPattern = regexp.MustCompile(`(?i)@import[^;]*;?`)
+)
+
+// isSVG reports whether the content starts like an SVG document - the svg root element after an optional XML
+// declaration, doctype and comments. The content is only peeked, so it can be read from the start afterwards.
+func isSVG(content *bufio.Reader) bool {
+	head, _ := content.Peek(svgSniffLength)
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+
+	for {
+		head = bytes.TrimLeft(head, " \t\r\n")
+		switch {
+		case bytes.HasPrefix(head, []byte("<svg")):
+			return true
+		case bytes.HasPrefix(head, []byte("<?")):
+			head = skipPast(head, "?>")
+		case bytes.HasPrefix(head, []byte("<!--")):
+			head = skipPast(head, "-->")
+		case bytes.HasPrefix(head, []byte("<!")):
+			head = skipPast(head, ">")
+		default:
+			return false
+		}
+		if head == nil {
+			return false
+		}
+	}
+}
+
+func skipPast(head []byte, end string) []byte {
+	index := bytes.Index(head, []byte(end))
+	if index < 0 {
+		return nil
+	}
+	return head[index+len(end):]
+}
+
+// sanitizeSVG parses the SVG document and writes it back without the scripts, the embedded documents, the event
+// handler attributes and the references to anything outside the document (other than the embedded data: images).
+// The doctype, processing instructions and comments are dropped too. The dimensions are read from the width and
+// height of the root element, or its viewBox.
+func sanitizeSVG(content io.Reader) (sanitized []byte, width, height int, err error) {
+	decoder := xml.NewDecoder(content)
+	decoder.Strict = true
+
+	buffer := bytes.Buffer{}
+	var openElements []string
+	skippedDepth := 0
+
+	for {
+		token, tokenErr := decoder.RawToken()
+		if tokenErr == io.EOF {
+			break
+		}
+		if tokenErr != nil {
+			return nil, 0, 0, fmt.Errorf("%w - the SVG is not well-formed: %s", ErrUnsupportedMediaType, tokenErr.Error())
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			name := qualifiedName(element.Name)
+			if len(openElements) == 0 {
+				if buffer.Len() > 0 {
+					return nil, 0, 0, fmt.Errorf("%w - the SVG has more than one root element", ErrUnsupportedMediaType)
+				}
+				if !strings.EqualFold(element.Name.Local, "svg") {
+					return nil, 0, 0, fmt.Errorf("%w - the root element is not svg", ErrUnsupportedMediaType)
+				}
+				width, height = svgDimensions(element.Attr)
+			}
+			if len(openElements) >= maxSVGDepth {
+				return nil, 0, 0, fmt.Errorf("%w - the SVG is nested too deep", ErrUnsupportedMediaType)
+			}
+			openElements = append(openElements, name)
+
+			if skippedDepth > 0 || isRemovedSVGElement(element) {
+				skippedDepth++
+				continue
+			}
+			writeSVGElement(&buffer, name, element.Attr)
+		case xml.EndElement:
+			if len(openElements) == 0 || openElements[len(openElements)-1] != qualifiedName(element.Name) {
+				return nil, 0, 0, fmt.Errorf("%w - the SVG is not well-formed", ErrUnsupportedMediaType)
+			}
+			openElements = openElements[:len(openElements)-1]
+
+			if skippedDepth > 0 {
+				skippedDepth--
+				continue
+			}
+			buffer.WriteString("</" + qualifiedName(element.Name) + ">")
+		case xml.CharData:
+			if skippedDepth > 0 || len(openElements) == 0 {
+				continue
+			}
+			text := string(element)
+			if strings.EqualFold(localName(openElements[len(openElements)-1]), "style") {
+				text = sanitizeCSS(text)
+			}
+			_ = xml.EscapeText(&buffer, []byte(text))
+		}
+	}
+
+	if buffer.Len() == 0 || len(openElements) > 0 {
+		return nil, 0, 0, fmt.Errorf("%w - the SVG is not well-formed", ErrUnsupportedMediaType)
+	}
+	return buffer.Bytes(), width, height, nil
+}
+
+func writeSVGElement(buffer *bytes.Buffer, name string, attributes []xml.Attr) {
+	buffer.WriteString("<" + name)
+	for _, attribute := range attributes {
+		value, keep := sanitizeSVGAttribute(attribute)
+		if !keep {
+			continue
+		}
+
+		buffer.WriteString(" " + qualifiedName(attribute.Name) + `="`)
+		_ = xml.EscapeText(buffer, []byte(value))
+		buffer.WriteString(`"`)
+	}
+	buffer.WriteString(">")
+}
+
+// sanitizeSVGAttribute drops the event handlers and the links out of the document, and the external references
+// from the inline styles
+func sanitizeSVGAttribute(attribute xml.Attr) (value string, keep bool) {
+	name := strings.ToLower(attribute.Name.Local)
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return "", false
+	case name == "href" || name == "src":
+		return attribute.Value, isLocalReference(attribute.Value)
+	case name == "style":
+		return sanitizeCSS(attribute.Value), true
+	case strings.Contains(strings.ToLower(attribute.Value), "javascript:"):
+		return "", false
+	default:
+		// the presentation attributes such as fill can reference paint servers by url()
+		return sanitizeCSS(attribute.Value), true
+	}
+}
+
+// isRemovedSVGElement reports the elements removed with their content - the scripts and embedded documents, and the
+// animations of the links
+func isRemovedSVGElement(element xml.StartElement) bool {
+	name := strings.ToLower(element.Name.Local)
+	if slices.Contains(svgRemovedElements, name) {
+		return true
+	}
+	if !slices.Contains(svgAnimationElements, name) {
+		return false
+	}
+
+	for _, attribute := range element.Attr {
+		if strings.EqualFold(attribute.Name.Local, "attributeName") {
+			animated := strings.ToLower(attribute.Value)
+			return animated == "href" || strings.HasSuffix(animated, ":href") || strings.HasPrefix(animated, "on")
+		}
+	}
+	return false
+}
+
+// isLocalReference accepts the references to the fragments of the document and the embedded raster images
+func isLocalReference(reference string) bool {
+	reference = strings.ToLower(strings.TrimSpace(reference))
+	return strings.HasPrefix(reference, "#") ||
+		strings.HasPrefix(reference, "data:image/png") ||
+		strings.HasPrefix(reference, "data:image/jpeg") ||
+		strings.HasPrefix(reference, "data:image/gif") ||
+		strings.HasPrefix(reference, "data:image/webp")
+}
+
+// sanitizeCSS removes the imports and the url() references out of the document from a stylesheet
+func sanitizeCSS(css string) string {
+	css = cssImportPattern.ReplaceAllString(css, "")
+	css = strings.ReplaceAll(css, "expression(", "(")
+	return cssURLPattern.ReplaceAllStringFunc(css, func(reference string) string {
+		if isLocalReference(cssURLPattern.FindStringSubmatch(reference)[2]) {
+			return reference
+		}
+		return "none"
+	})
+}
+
+// svgDimensions reads the size from the width and height attributes in pixels, falling back to the viewBox (and
+// its aspect ratio if only one of them is set) and to the default size of the replaced elements
+func svgDimensions(attributes []xml.Attr) (width, height int) {
+	var attributeWidth, attributeHeight, viewBoxWidth, viewBoxHeight float64
+
+	for _, attribute := range attributes {
+		switch strings.ToLower(attribute.Name.Local) {
+		case "width":
+			attributeWidth = parseSVGLength(attribute.Value)
+		case "height":
+			attributeHeight = parseSVGLength(attribute.Value)
+		case "viewbox":
+			viewBox := strings.Fields(strings.ReplaceAll(attribute.Value, ",", " "))
+			if len(viewBox) == 4 {
+				viewBoxWidth = parseSVGLength(viewBox[2])
+				viewBoxHeight = parseSVGLength(viewBox[3])
+			}
+		}
+	}
+
+	hasViewBox := viewBoxWidth > 0 && viewBoxHeight > 0
+	switch {
+	case attributeWidth > 0 && attributeHeight > 0:
+	case attributeWidth > 0 && hasViewBox:
+		attributeHeight = attributeWidth * viewBoxHeight / viewBoxWidth
+	case attributeHeight > 0 && hasViewBox:
+		attributeWidth = attributeHeight * viewBoxWidth / viewBoxHeight
+	case hasViewBox:
+		attributeWidth, attributeHeight = viewBoxWidth, viewBoxHeight
+	default:
+		return defaultSVGWidth, defaultSVGHeight
+	}
+	return max(1, int(math.Round(attributeWidth))), max(1, int(math.Round(attributeHeight)))
+}
+
+// parseSVGLength parses the unitless and pixel lengths, the relative lengths (such as percentages) are ignored
+func parseSVGLength(value string) float64 {
+	match := svgLengthPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0
+	}
+	length, err := strconv.ParseFloat(match[1], 64)
+	if err != nil || math.IsInf(length, 0) {
+		return 0
+	}
+	return length
+}
+
+func qualifiedName(name xml.Name) string {
+	if len(name.Space) > 0 {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+func localName(qualified string) string {
+	return qualified[strings.LastIndex(qualified, ":")+1:]
+}
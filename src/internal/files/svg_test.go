@@ -0,0 +1,179 @@
+package files
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSVGDimensions(t *testing.T) {
+	testCases := []struct {
+		name          string
+		attributes    map[string]string
+		width, height int
+	}{
+		{name: "width and height", attributes: map[string]string{"width": "120", "height": "80"}, width: 120, height: 80},
+		{name: "pixel units", attributes: map[string]string{"width": " 120.4px", "height": "79.6 px "}, width: 120, height: 80},
+		{name: "width and height over the viewBox", attributes: map[string]string{"width": "10", "height": "20", "viewBox": "0 0 300 300"}, width: 10, height: 20},
+		{name: "viewBox only", attributes: map[string]string{"viewBox": "0 0 24 12"}, width: 24, height: 12},
+		{name: "viewBox with commas", attributes: map[string]string{"viewBox": "0,0,24,12"}, width: 24, height: 12},
+		{name: "width only", attributes: map[string]string{"width": "48", "viewBox": "0 0 24 12"}, width: 48, height: 24},
+		{name: "height only", attributes: map[string]string{"height": "48", "viewBox": "0 0 24 12"}, width: 96, height: 48},
+		{name: "width only without a viewBox", attributes: map[string]string{"width": "48"}, width: defaultSVGWidth, height: defaultSVGHeight},
+		{name: "percentages", attributes: map[string]string{"width": "100%", "height": "100%", "viewBox": "0 0 24 12"}, width: 24, height: 12},
+		{name: "other units", attributes: map[string]string{"width": "2em", "height": "1cm"}, width: defaultSVGWidth, height: defaultSVGHeight},
+		{name: "invalid viewBox", attributes: map[string]string{"viewBox": "0 0 24"}, width: defaultSVGWidth, height: defaultSVGHeight},
+		{name: "no dimensions", attributes: map[string]string{}, width: defaultSVGWidth, height: defaultSVGHeight},
+		{name: "below a pixel", attributes: map[string]string{"width": "0.2", "height": "0.4"}, width: 1, height: 1},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var attributes []xml.Attr
+			for name, value := range testCase.attributes {
+				attributes = append(attributes, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+			}
+
+			width, height := svgDimensions(attributes)
+			if width != testCase.width || height != testCase.height {
+				t.Fatalf("expected %dx%d, got %dx%d", testCase.width, testCase.height, width, height)
+			}
+		})
+	}
+}
+
+func TestSanitizeSVG(t *testing.T) {
+	testCases := []struct {
+		name     string
+		svg      string
+		removed  []string
+		kept     []string
+		rejected bool
+	}{
+		{
+			name:    "script",
+			svg:     `<svg><script>alert(1)</script><rect width="1"/></svg>`,
+			removed: []string{"script", "alert"},
+			kept:    []string{`<rect width="1">`},
+		},
+		{
+			name:    "prefixed script",
+			svg:     `<svg:svg xmlns:svg="http://www.w3.org/2000/svg"><svg:script>alert(1)</svg:script></svg:svg>`,
+			removed: []string{"script", "alert"},
+			kept:    []string{"<svg:svg", "</svg:svg>"},
+		},
+		{
+			name:    "foreignObject",
+			svg:     `<svg><foreignObject><iframe src="https://example.com"></iframe><p>text</p></foreignObject></svg>`,
+			removed: []string{"foreignObject", "iframe", "example.com", "text"},
+		},
+		{
+			name:    "event handlers",
+			svg:     `<svg onload="alert(1)"><rect ONCLICK="alert(2)" onmouseover="alert(3)" fill="red"/></svg>`,
+			removed: []string{"alert", "onload", "ONCLICK", "onmouseover"},
+			kept:    []string{`fill="red"`},
+		},
+		{
+			name:    "javascript links",
+			svg:     `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href="javascript:alert(1)"><use href=" JavaScript:alert(2)"/></a><a target="javascript:alert(3)"/></svg>`,
+			removed: []string{"javascript", "JavaScript", "alert"},
+		},
+		{
+			name:    "external links",
+			svg:     `<svg><image href="https://example.com/tracker.png"/><use href="#shape"/><image href="data:image/png;base64,AAAA"/></svg>`,
+			removed: []string{"example.com"},
+			kept:    []string{`href="#shape"`, `href="data:image/png;base64,AAAA"`},
+		},
+		{
+			name:    "animated links",
+			svg:     `<svg><a href="#"><set attributeName="href" to="javascript:alert(1)"/><animate attributeName="xlink:href" values="javascript:alert(2)"/><set attributeName="onclick" to="alert(3)"/><set attributeName="fill" to="blue"/></a></svg>`,
+			removed: []string{"alert", `attributeName="href"`, `attributeName="xlink:href"`, `attributeName="onclick"`},
+			kept:    []string{`<set attributeName="fill" to="blue">`},
+		},
+		{
+			name:    "stylesheet imports and references",
+			svg:     `<svg><style>@import url("https://example.com/a.css"); @IMPORT 'b.css'; rect { fill: url(#gradient); background: url( 'https://example.com/b.png' ) }</style></svg>`,
+			removed: []string{"import", "IMPORT", "example.com", "b.css"},
+			kept:    []string{"url(#gradient)", "background: none"},
+		},
+		{
+			name:    "inline style and presentation references",
+			svg:     `<svg><rect style="fill: url(https://example.com/a.svg#p)" fill="url(#gradient)" filter="url(//example.com/f.svg#f)"/></svg>`,
+			removed: []string{"example.com"},
+			kept:    []string{`style="fill: none"`, `fill="url(#gradient)"`, `filter="none"`},
+		},
+		{
+			name:    "doctype, comments and processing instructions",
+			svg:     `<?xml version="1.0"?><!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd"><!-- comment --><?xml-stylesheet href="https://example.com/a.css"?><svg><rect/></svg>`,
+			removed: []string{"DOCTYPE", "comment", "xml-stylesheet", "example.com", "<?xml"},
+			kept:    []string{"<svg><rect></rect></svg>"},
+		},
+		{
+			name:     "external entity",
+			svg:      `<!DOCTYPE svg [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><svg><text>&xxe;</text></svg>`,
+			rejected: true,
+		},
+		{
+			name:     "entity expansion",
+			svg:      `<!DOCTYPE svg [<!ENTITY a "aaaaaaaaaa"><!ENTITY b "&a;&a;&a;&a;&a;">]><svg><text>&b;</text></svg>`,
+			rejected: true,
+		},
+		{name: "not svg", svg: `<html><body></body></html>`, rejected: true},
+		{name: "two roots", svg: `<svg></svg><svg></svg>`, rejected: true},
+		{name: "unclosed", svg: `<svg><rect>`, rejected: true},
+		{name: "mismatched", svg: `<svg><g></rect></svg>`, rejected: true},
+		{name: "too deep", svg: "<svg>" + strings.Repeat("<g>", maxSVGDepth) + strings.Repeat("</g>", maxSVGDepth) + "</svg>", rejected: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sanitized, _, _, err := sanitizeSVG(strings.NewReader(testCase.svg))
+			if testCase.rejected {
+				if !errors.Is(err, ErrUnsupportedMediaType) {
+					t.Fatalf("expected the SVG to be rejected, got %q and %v", sanitized, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, removed := range testCase.removed {
+				if strings.Contains(string(sanitized), removed) {
+					t.Errorf("expected %q to be removed from %s", removed, sanitized)
+				}
+			}
+			for _, kept := range testCase.kept {
+				if !strings.Contains(string(sanitized), kept) {
+					t.Errorf("expected %q to be kept in %s", kept, sanitized)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGDimensions(t *testing.T) {
+	testCases := []struct {
+		name          string
+		svg           string
+		width, height int
+	}{
+		{name: "viewBox only", svg: `<svg viewBox="0 0 64 32"><rect/></svg>`, width: 64, height: 32},
+		{name: "width only", svg: `<svg width="128" viewBox="0 0 64 32"><rect/></svg>`, width: 128, height: 64},
+		{name: "no dimensions", svg: `<svg><rect/></svg>`, width: defaultSVGWidth, height: defaultSVGHeight},
+		{name: "nested svg", svg: `<svg width="10" height="20"><svg width="500" height="500"/></svg>`, width: 10, height: 20},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, width, height, err := sanitizeSVG(strings.NewReader(testCase.svg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if width != testCase.width || height != testCase.height {
+				t.Fatalf("expected %dx%d, got %dx%d", testCase.width, testCase.height, width, height)
+			}
+		})
+	}
+}
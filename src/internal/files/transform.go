@@ -0,0 +1,319 @@
+package files
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net/url"
+	"portfolio-cms-server/utils"
+	"strconv"
+	"strings"
+)
+
+const (
+	TransformFitCover   = "cover"
+	TransformFitContain = "contain"
+	TransformFitFill    = "fill"
+
+	// transformPrefix is the storage prefix of the cached transformations, followed by the key of the source image
+	transformPrefix     = "transforms/"
+	maxTransformSize    = 4096
+	defaultTransformFit = TransformFitCover
+	// defaultTransformCacheSize keeps the memory cache small next to the decode budget, the storage cache serves
+	// the transformations evicted from it
+	defaultTransformCacheSize = 16 << 20
+)
+
+// ImageTransformConfig configures the image transformations. The transformations are disabled without a signing
+// secret. The memory cache size is in bytes, the zero value keeps the default.
+type ImageTransformConfig struct {
+	Secret          string
+	MemoryCacheSize int64
+}
+
+var (
+	ErrTransformsDisabled   = errors.New("the image transformations are not configured")
+	ErrInvalidTransform     = errors.New("invalid image transformation")
+	ErrInvalidTransformSign = errors.New("invalid image transformation signature")
+
+	transformSecret []byte
+	transformCache  = utils.NewMemoryCache(defaultTransformCacheSize)
+)
+
+// ConfigureImageTransforms sets the signing secret of the transformation URLs and the memory cache size
+func ConfigureImageTransforms(transformConfig ImageTransformConfig) {
+	transformSecret = []byte(transformConfig.Secret)
+	if transformConfig.MemoryCacheSize > 0 {
+		transformCache = utils.NewMemoryCache(transformConfig.MemoryCacheSize)
+	}
+}
+
+// TransformImage gets the stored image resized, cropped and encoded as requested. The result is served from the
+// memory cache, or the storage cache, before the image is transformed - the transformations are cached in both.
+// The options have to be signed by SignImageTransform, so only the transformations handed out can be requested.
+func TransformImage(fileKey string, options ImageTransformOptions, signature string) (transformed utils.CachedContent, err error) {
+	if len(transformSecret) == 0 {
+		return transformed, ErrTransformsDisabled
+	}
+	if err = options.normalize(); err != nil {
+		return
+	}
+	if !isTransformable(fileKey) {
+		return transformed, fmt.Errorf("%w - %s", utils.ErrObjectNotFound, fileKey)
+	}
+
+	expectedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(expectedSignature, transformSignature(fileKey, options)) {
+		return transformed, ErrInvalidTransformSign
+	}
+
+	cacheKey := transformCacheKey(fileKey, options)
+	if cached, found := transformCache.Get(cacheKey); found {
+		return cached, nil
+	}
+
+	transformed, err = readCachedTransform(cacheKey)
+	if errors.Is(err, utils.ErrObjectNotFound) {
+		transformed, err = transformStoredImage(fileKey, options)
+
+		if err == nil {
+			err = utils.GetStorage().Put(cacheKey, bytes.NewReader(transformed.Content), transformed.ContentType)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	transformCache.Set(cacheKey, transformed)
+	return
+}
+
+// SignImageTransform builds the signed path of the transformation of the stored image, relative to the server
+func SignImageTransform(fileKey string, options ImageTransformOptions) (transformPath string, err error) {
+	if len(transformSecret) == 0 {
+		return "", ErrTransformsDisabled
+	}
+	if err = options.normalize(); err != nil {
+		return
+	}
+	if !isTransformable(fileKey) {
+		return "", fmt.Errorf("%w - %s can not be transformed", ErrInvalidTransform, fileKey)
+	}
+
+	query := url.Values{}
+	query.Set("w", strconv.Itoa(options.Width))
+	query.Set("h", strconv.Itoa(options.Height))
+	query.Set("fit", options.Fit)
+	query.Set("q", strconv.Itoa(options.Quality))
+	query.Set("fmt", options.Format)
+	query.Set("s", base64.RawURLEncoding.EncodeToString(transformSignature(fileKey, options)))
+
+	return (&url.URL{Path: "/img/" + fileKey, RawQuery: query.Encode()}).String(), nil
+}
+
+// purgeImageTransforms deletes the cached transformations of the image, so they are not served once it is deleted
+func purgeImageTransforms(fileKey string) (err error) {
+	prefix := transformPrefix + fileKey + "/"
+	transformCache.DeletePrefix(prefix)
+
+	objects, err := utils.GetStorage().List(prefix)
+	if err != nil {
+		return
+	}
+	for _, object := range objects {
+		if err = utils.GetStorage().Delete(object.Key); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// transformSource gets the key of the image the cached transformation was made of
+func transformSource(objectKey string) (fileKey string, isTransform bool) {
+	if !strings.HasPrefix(objectKey, transformPrefix) {
+		return "", false
+	}
+
+	fileKey = strings.TrimPrefix(objectKey, transformPrefix)
+	if separator := strings.LastIndex(fileKey, "/"); separator > 0 {
+		return fileKey[:separator], true
+	}
+	return "", false
+}
+
+// normalize validates the options and fills in the defaults, so the equivalent options share the signature and the
+// cached transformation
+func (options *ImageTransformOptions) normalize() error {
+	if options.Width < 0 || options.Width > maxTransformSize || options.Height < 0 || options.Height > maxTransformSize {
+		return fmt.Errorf("%w - the width and height have to be between 0 and %d", ErrInvalidTransform, maxTransformSize)
+	}
+	if options.Quality < 0 || options.Quality > 100 {
+		return fmt.Errorf("%w - the quality has to be between 1 and 100", ErrInvalidTransform)
+	}
+
+	switch options.Fit {
+	case "":
+		options.Fit = defaultTransformFit
+	case TransformFitCover, TransformFitContain, TransformFitFill:
+	default:
+		return fmt.Errorf("%w - unknown fit %s", ErrInvalidTransform, options.Fit)
+	}
+
+	switch options.Format {
+	case "", "jpeg", "png":
+	case "jpg":
+		options.Format = "jpeg"
+	default:
+		return fmt.Errorf("%w - unknown format %s", ErrInvalidTransform, options.Format)
+	}
+
+	if options.Quality == 0 {
+		options.Quality = variantJPEGQuality
+	}
+	// the quality only applies to the JPEG encoding
+	if options.Format == "png" {
+		options.Quality = 100
+	}
+	return nil
+}
+
+// isTransformable excludes the archived originals, which keep their metadata, and the trashed and cached objects
+func isTransformable(fileKey string) bool {
+	cleanKey := strings.TrimPrefix(strings.TrimPrefix(fileKey, "/"), "./")
+	return len(cleanKey) > 0 &&
+		!strings.Contains(fileKey, "..") &&
+		!strings.HasPrefix(cleanKey, "originals/") &&
+		!strings.HasPrefix(cleanKey, trashPrefix) &&
+		!strings.HasPrefix(cleanKey, transformPrefix)
+}
+
+func transformSignature(fileKey string, options ImageTransformOptions) []byte {
+	signature := hmac.New(sha256.New, transformSecret)
+	signature.Write([]byte(fileKey + "?" + options.canonical()))
+	return signature.Sum(nil)
+}
+
+func (options ImageTransformOptions) canonical() string {
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&q=%d&fmt=%s", options.Width, options.Height, options.Fit, options.Quality, options.Format)
+}
+
+// transformCacheKey keys the cached transformation under the source image, so the transformations of an image can
+// be listed and deleted with it. The format is left to the encoding of the source image when it is not requested.
+func transformCacheKey(fileKey string, options ImageTransformOptions) string {
+	digest := sha256.Sum256([]byte(options.canonical()))
+	return transformPrefix + fileKey + "/" + hex.EncodeToString(digest[:12])
+}
+
+func readCachedTransform(cacheKey string) (transformed utils.CachedContent, err error) {
+	content, info, err := utils.GetStorage().Get(cacheKey)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	if transformed.Content, err = io.ReadAll(content); err != nil {
+		return
+	}
+	transformed.ContentType = info.ContentType
+	transformed.ETag = contentETag(transformed.Content)
+	return
+}
+
+func transformStoredImage(fileKey string, options ImageTransformOptions) (transformed utils.CachedContent, err error) {
+	content, _, err := utils.GetStorage().Get(fileKey)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	source, err := io.ReadAll(content)
+	if err != nil {
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return transformed, fmt.Errorf("%w - %s is not an image", ErrInvalidTransform, fileKey)
+	}
+	if err = checkImageResolution(config); err != nil {
+		return
+	}
+
+	// the resized image is held along with the decoded one
+	pixels := config.Width * config.Height
+	if options.Width > 0 && options.Height > 0 {
+		pixels += options.Width * options.Height
+	} else {
+		pixels *= 2
+	}
+	budget := imageDecodes
+	weight := budget.acquire(pixels)
+	defer budget.release(weight)
+
+	decoded, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return
+	}
+
+	if len(options.Format) > 0 {
+		format = options.Format
+	}
+	transformed.Content, transformed.ContentType, err = encodeImage(resizeToFit(decoded, options), format, options.Quality)
+	transformed.ETag = contentETag(transformed.Content)
+	return
+}
+
+// resizeToFit resizes the image to the requested box. The cover fit crops the centre of the image to the box
+// aspect ratio and the fill fit stretches it, both fill the box exactly. The contain fit and a single requested
+// dimension keep the aspect ratio. Keeping the aspect ratio never upscales the image.
+func resizeToFit(img image.Image, options ImageTransformOptions) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if options.Width == 0 && options.Height == 0 {
+		return img
+	}
+	if options.Width == 0 || options.Height == 0 || options.Fit == TransformFitContain {
+		scale := 1.0
+		if options.Width > 0 {
+			scale = math.Min(scale, float64(options.Width)/float64(width))
+		}
+		if options.Height > 0 {
+			scale = math.Min(scale, float64(options.Height)/float64(height))
+		}
+		if scale == 1 {
+			return img
+		}
+		return utils.ResizeImage(
+			img,
+			max(1, int(math.Round(float64(width)*scale))),
+			max(1, int(math.Round(float64(height)*scale))),
+		)
+	}
+
+	if options.Fit == TransformFitCover {
+		rgba := utils.ToRGBA(img)
+		cropWidth, cropHeight := width, height
+		if width*options.Height > height*options.Width {
+			cropWidth = max(1, int(math.Round(float64(height)*float64(options.Width)/float64(options.Height))))
+		} else {
+			cropHeight = max(1, int(math.Round(float64(width)*float64(options.Height)/float64(options.Width))))
+		}
+
+		cropOrigin := image.Pt((width-cropWidth)/2, (height-cropHeight)/2)
+		img = rgba.SubImage(image.Rectangle{Min: cropOrigin, Max: cropOrigin.Add(image.Pt(cropWidth, cropHeight))})
+	}
+	return utils.ResizeImage(img, options.Width, options.Height)
+}
+
+func contentETag(content []byte) string {
+	digest := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(digest[:16]) + `"`
+}
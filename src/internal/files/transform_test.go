@@ -0,0 +1,61 @@
+package files
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeTransformOptions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		options    ImageTransformOptions
+		normalized ImageTransformOptions
+	}{
+		{
+			name:       "defaults",
+			options:    ImageTransformOptions{Width: 640},
+			normalized: ImageTransformOptions{Width: 640, Fit: TransformFitCover, Quality: variantJPEGQuality},
+		},
+		{
+			name:       "jpg alias",
+			options:    ImageTransformOptions{Height: 480, Fit: TransformFitContain, Quality: 60, Format: "jpg"},
+			normalized: ImageTransformOptions{Height: 480, Fit: TransformFitContain, Quality: 60, Format: "jpeg"},
+		},
+		{
+			name:       "PNG ignores the quality",
+			options:    ImageTransformOptions{Width: maxTransformSize, Height: maxTransformSize, Fit: TransformFitFill, Quality: 60, Format: "png"},
+			normalized: ImageTransformOptions{Width: maxTransformSize, Height: maxTransformSize, Fit: TransformFitFill, Quality: 100, Format: "png"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			options := testCase.options
+			if err := options.normalize(); err != nil {
+				t.Fatalf("expected the options to be valid, got %s", err.Error())
+			}
+			if options != testCase.normalized {
+				t.Fatalf("expected %+v, got %+v", testCase.normalized, options)
+			}
+		})
+	}
+}
+
+func TestNormalizeTransformOptionsRejectsInvalidOptions(t *testing.T) {
+	testCases := map[string]ImageTransformOptions{
+		"negative width":    {Width: -1},
+		"height too big":    {Height: maxTransformSize + 1},
+		"negative quality":  {Quality: -1},
+		"quality above 100": {Quality: 101},
+		"unknown fit":       {Fit: "stretch"},
+		"unknown format":    {Format: "webp"},
+	}
+
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := options.normalize(); !errors.Is(err, ErrInvalidTransform) {
+				t.Fatalf("expected ErrInvalidTransform, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package files
+
+import (
+	"errors"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"sort"
+	"time"
+)
+
+const (
+	trashPrefix = "trash/"
+	// mediaTransactionTimeout bounds the transactions which update the media collections
+	mediaTransactionTimeout = 30 * time.Second
+	trashPurgeInterval      = 1 * time.Hour
+)
+
+var (
+	ErrTrashedMediaNotFound  = errors.New("unknown or already purged media")
+	ErrRestoreTargetMissing  = errors.New("the project or job the media was removed from does not exist any more")
+	ErrTrashedObjectsMissing = errors.New("the media is not stored any more")
+
+	trashRetention = 30 * 24 * time.Hour
+)
+
+// ConfigureTrashRetention sets the time the deleted media is kept in the trash, the zero value keeps the default
+func ConfigureTrashRetention(retention time.Duration) {
+	if retention > 0 {
+		trashRetention = retention
+	}
+}
+
+// ListTrash lists the trashed media, most recently deleted first
+func ListTrash() (trash []TrashedMedia, err error) {
+	var rows []trashedMediaRow
+	err = database.GetMultipleRecords(
+		&rows,
+		`SELECT id, image_url, objects, image_references, deleted_at, purge_at
+				FROM trashed_media
+				ORDER BY deleted_at DESC;`,
+	)
+	if err != nil {
+		return
+	}
+
+	trash = make([]TrashedMedia, 0, len(rows))
+	for _, row := range rows {
+		trashedMedia, decodeErr := row.decode()
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		trash = append(trash, trashedMedia)
+	}
+	return
+}
+
+// RestoreTrashedMedia moves the objects of the trashed media back and inserts the image to the collections and
+// positions it was removed from. The positions past the end of a collection which shrank in the meantime append
+// the image. The references of a shared image were trashed without the objects - if the other references were
+// trashed since, the objects are restored from the trashed media holding them, which keeps only the references.
+// The objects are copied back before the collections are locked and the trashed copies are deleted after the
+// commit, so the row locks are not held during the storage requests. The result holds the updated images of the
+// collections, as the deletion does.
+func RestoreTrashedMedia(trashID string) (updatedCollections []UpdatedCollection, err error) {
+	trashedMedia, err := getTrashedMedia(trashID)
+	if err != nil {
+		return
+	}
+
+	objects, ownerID := trashedMedia.objects, ""
+	if len(objects) == 0 {
+		owner, ownerErr := getTrashedObjectsOwner(trashedMedia.ImageURL)
+		if ownerErr != nil && !errors.Is(ownerErr, ErrTrashedMediaNotFound) {
+			return nil, ownerErr
+		}
+		objects, ownerID = owner.objects, owner.ID
+	}
+
+	restoredObjects, err := copyTrashedObjects(objects)
+	if err != nil {
+		return
+	}
+
+	err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+		deletion, err := transaction.ExecuteNamedQuery(`DELETE FROM trashed_media WHERE id = :id;`, map[string]interface{}{"id": trashID})
+		if err != nil {
+			return
+		}
+		// restored or purged since the objects were copied
+		if deletedRows, _ := deletion.RowsAffected(); deletedRows == 0 {
+			return ErrTrashedMediaNotFound
+		}
+
+		if len(ownerID) > 0 {
+			_, err = transaction.ExecuteNamedQuery(
+				`UPDATE trashed_media SET objects = '[]' WHERE id = :id;`,
+				map[string]interface{}{"id": ownerID},
+			)
+			if err != nil {
+				return
+			}
+		}
+
+		collections, err := lockImageCollections(transaction)
+		if err != nil {
+			return
+		}
+		decoded, err := decodeCollections(collections)
+		if err != nil {
+			return
+		}
+
+		// inserting in the order of the positions restores the relative order of the images in a collection
+		references := append([]trashedReference{}, trashedMedia.References...)
+		sort.SliceStable(references, func(i, j int) bool {
+			return references[i].Position < references[j].Position
+		})
+
+		mediaReferences := make([]MediaReference, 0, len(references))
+		for _, reference := range references {
+			if err = decoded.insertImage(reference); err != nil {
+				return
+			}
+			mediaReferences = append(mediaReferences, reference.MediaReference)
+		}
+		if updatedCollections, err = decoded.updatedCollections(mediaReferences); err != nil {
+			return
+		}
+
+		if len(references) > 0 {
+			if collections, err = decoded.encode(); err != nil {
+				return
+			}
+			if err = updateImageCollections(transaction, collections); err != nil {
+				return
+			}
+		}
+
+		// the references of a shared image were trashed without the objects, which may be gone since
+		if fileKey, found := utils.GetStorage().KeyFromURL(trashedMedia.ImageURL); found {
+			if _, err = utils.GetStorage().Stat(fileKey); errors.Is(err, utils.ErrObjectNotFound) {
+				err = ErrTrashedObjectsMissing
+			}
+		}
+		return
+	})
+
+	if err != nil {
+		deleteObjects(restoredObjects, false)
+		return
+	}
+	deleteObjects(objects, true)
+	return
+}
+
+// PurgeTrashedMedia permanently deletes the objects of the trashed media. The objects of a shared image are handed
+// over to the trashed media of its other references instead, which is purged later. The objects are deleted
+// before the row, so the objects which fail to be deleted are deleted by the next purge.
+func PurgeTrashedMedia(trashID string) (err error) {
+	trashedMedia, err := getTrashedMedia(trashID)
+	if err != nil {
+		return
+	}
+
+	if len(trashedMedia.objects) > 0 {
+		handedOver := false
+		err = database.WithTransaction(mediaTransactionTimeout, func(transaction *database.Transaction) (err error) {
+			handedOver, err = handOverTrashedObjects(transaction, trashedMedia)
+			return
+		})
+		if err != nil || handedOver {
+			return
+		}
+	}
+
+	for _, object := range trashedMedia.objects {
+		if err = utils.GetStorage().Delete(object.TrashKey); err != nil {
+			return
+		}
+	}
+
+	deletion, err := database.ExecuteNamedQuery(`DELETE FROM trashed_media WHERE id = :id;`, map[string]interface{}{"id": trashID})
+	if err != nil {
+		return
+	}
+	if deletedRows, _ := deletion.RowsAffected(); deletedRows == 0 {
+		return ErrTrashedMediaNotFound
+	}
+	return
+}
+
+// getTrashedObjectsOwner gets the most recently trashed media of the image which holds its objects
+func getTrashedObjectsOwner(imageURL string) (owner TrashedMedia, err error) {
+	row := trashedMediaRow{}
+	err = database.GetSingleRecordNamedQuery(
+		&row,
+		`SELECT id, image_url, objects, image_references, deleted_at, purge_at
+				FROM trashed_media
+				WHERE image_url = :image_url
+				  AND JSONB_ARRAY_LENGTH(objects) > 0
+				ORDER BY deleted_at DESC
+				LIMIT 1;`,
+		map[string]interface{}{"image_url": imageURL},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = ErrTrashedMediaNotFound
+		}
+		return
+	}
+	return row.decode()
+}
+
+// handOverTrashedObjects moves the objects of the trashed media to the trashed references of the same image which
+// are purged last, and deletes the trashed media. Reports false if there are no other trashed references.
+func handOverTrashedObjects(transaction *database.Transaction, trashedMedia TrashedMedia) (handedOver bool, err error) {
+	var heirID string
+	err = transaction.GetSingleRecordNamedQuery(
+		&heirID,
+		`SELECT id
+				FROM trashed_media
+				WHERE image_url = :image_url
+				  AND id <> :id
+				  AND JSONB_ARRAY_LENGTH(objects) = 0
+				ORDER BY purge_at DESC
+				LIMIT 1
+				FOR UPDATE;`,
+		map[string]interface{}{"image_url": trashedMedia.ImageURL, "id": trashedMedia.ID},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = nil
+		}
+		return
+	}
+
+	objectsJSON, err := json.Marshal(trashedMedia.objects)
+	if err != nil {
+		return
+	}
+	_, err = transaction.ExecuteNamedQuery(
+		`UPDATE trashed_media SET objects = CAST(:objects AS JSONB) WHERE id = :id;`,
+		map[string]interface{}{"objects": string(objectsJSON), "id": heirID},
+	)
+	if err != nil {
+		return
+	}
+
+	deletion, err := transaction.ExecuteNamedQuery(`DELETE FROM trashed_media WHERE id = :id;`, map[string]interface{}{"id": trashedMedia.ID})
+	if err != nil {
+		return
+	}
+	if deletedRows, _ := deletion.RowsAffected(); deletedRows == 0 {
+		return false, ErrTrashedMediaNotFound
+	}
+	return true, nil
+}
+
+func getTrashedMedia(trashID string) (trashedMedia TrashedMedia, err error) {
+	row := trashedMediaRow{}
+	err = database.GetSingleRecordNamedQuery(
+		&row,
+		`SELECT id, image_url, objects, image_references, deleted_at, purge_at
+				FROM trashed_media
+				WHERE id = :id;`,
+		map[string]interface{}{"id": trashID},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = ErrTrashedMediaNotFound
+		}
+		return
+	}
+	return row.decode()
+}
+
+// StartTrashPurger purges the trashed media past its retention in the background
+func StartTrashPurger() {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purgeExpiredTrash()
+		}
+	}()
+}
+
+func purgeExpiredTrash() {
+	var expiredIDs []string
+	err := database.GetMultipleRecords(&expiredIDs, `SELECT id FROM trashed_media WHERE purge_at < NOW();`)
+	if err != nil {
+		utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Error on getting the expired trashed media")
+		return
+	}
+
+	for _, trashID := range expiredIDs {
+		if err = PurgeTrashedMedia(trashID); err != nil && !errors.Is(err, ErrTrashedMediaNotFound) {
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": err.Error()}).
+				Errorf("Error on purging the trashed media %s", trashID)
+		}
+	}
+}
+
+// trashImage records the stored objects of the image and the references it was detached from in the
+// transaction, the objects are moved to the trash by the caller after the commit. The objects of a shared image
+// stay in place, only the references are recorded. Nothing is recorded if the image is neither referenced nor
+// stored any more.
+func trashImage(transaction *database.Transaction, imageURL, fileKey string, detached []detachedImage, isShared bool) (trashID string, trashedObjects []trashedObject, err error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return
+	}
+
+	imageObjects := make([]map[string]interface{}, 0, len(detached))
+	references := make([]trashedReference, 0, len(detached))
+	for _, image := range detached {
+		imageObjects = append(imageObjects, image.Image)
+		references = append(references, trashedReference{MediaReference: image.Reference, Image: image.Image})
+	}
+
+	trashedObjects = []trashedObject{}
+	if !isShared {
+		for _, objectKey := range imageObjectKeys(fileKey, imageObjects...) {
+			trashedObjects = append(trashedObjects, trashedObject{Key: objectKey, TrashKey: trashPrefix + id.String() + "/" + objectKey})
+		}
+	}
+
+	if len(references) == 0 && isShared {
+		return "", nil, nil
+	}
+	if len(references) == 0 {
+		if _, statErr := utils.GetStorage().Stat(fileKey); errors.Is(statErr, utils.ErrObjectNotFound) {
+			return "", nil, nil
+		}
+	}
+
+	objectsJSON, err := json.Marshal(trashedObjects)
+	if err != nil {
+		return
+	}
+	referencesJSON, err := json.Marshal(references)
+	if err != nil {
+		return
+	}
+
+	_, err = transaction.ExecuteNamedQuery(
+		`INSERT INTO trashed_media (id, image_url, objects, image_references, purge_at)
+				VALUES (:id, :image_url, CAST(:objects AS JSONB), CAST(:image_references AS JSONB), :purge_at);`,
+		map[string]interface{}{
+			"id":               id.String(),
+			"image_url":        imageURL,
+			"objects":          string(objectsJSON),
+			"image_references": string(referencesJSON),
+			"purge_at":         time.Now().Add(trashRetention).UTC(),
+		},
+	)
+	return id.String(), trashedObjects, err
+}
+
+// moveToTrash copies the objects to the trash and deletes them once all of them are copied. The objects which do
+// not exist are skipped. On a failure the copies are deleted and the objects stay in place.
+func moveToTrash(objects []trashedObject) (err error) {
+	var copiedObjects []trashedObject
+	for _, object := range objects {
+		copyErr := utils.GetStorage().CopyPrivate(object.Key, object.TrashKey)
+		if errors.Is(copyErr, utils.ErrObjectNotFound) {
+			continue
+		}
+		if copyErr != nil {
+			deleteObjects(copiedObjects, true)
+			return copyErr
+		}
+		copiedObjects = append(copiedObjects, object)
+	}
+
+	deleteObjects(copiedObjects, false)
+	return
+}
+
+// copyTrashedObjects copies the trashed objects back, the uploaded media with the public access and the archived
+// originals without it. The objects which are already in place or are not in the trash are skipped. Returns the
+// copied objects, on a failure the copies are deleted.
+func copyTrashedObjects(objects []trashedObject) (copiedObjects []trashedObject, err error) {
+	for _, object := range objects {
+		if _, statErr := utils.GetStorage().Stat(object.Key); statErr == nil {
+			continue
+		}
+
+		copyObject := utils.GetStorage().CopyPrivate
+		if IsPublicMediaKey(object.Key) {
+			copyObject = utils.GetStorage().Copy
+		}
+
+		copyErr := copyObject(object.TrashKey, object.Key)
+		if errors.Is(copyErr, utils.ErrObjectNotFound) {
+			continue
+		}
+		if copyErr != nil {
+			deleteObjects(copiedObjects, false)
+			return nil, copyErr
+		}
+		copiedObjects = append(copiedObjects, object)
+	}
+	return
+}
+
+// deleteObjects deletes the objects (or their trashed copies), the failures are only logged as the objects are
+// left to the orphan collection
+func deleteObjects(objects []trashedObject, trashed bool) {
+	for _, object := range objects {
+		objectKey := object.Key
+		if trashed {
+			objectKey = object.TrashKey
+		}
+
+		if err := utils.GetStorage().Delete(objectKey); err != nil {
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": err.Error()}).
+				Warnf("Failed to delete the object %s", objectKey)
+		}
+	}
+}
+
+// insertImage inserts the trashed image back to its position in the collection
+func (decoded *decodedCollections) insertImage(reference trashedReference) error {
+	switch reference.Collection {
+	case MediaCollectionCarousel:
+		decoded.Carousel = insertAt(decoded.Carousel, reference.Position, reference.Image)
+		return nil
+	case MediaCollectionPartners:
+		decoded.Partners = insertAt(decoded.Partners, reference.Position, reference.Image)
+		return nil
+	}
+
+	items, nameField := decoded.Projects, "title"
+	if reference.Collection == MediaCollectionJobs {
+		items, nameField = decoded.Jobs, "company"
+	}
+
+	for _, item := range items {
+		if name, _ := item[nameField].(string); name == reference.Name {
+			item["images"] = insertAt(asList(item["images"]), reference.Position, reference.Image)
+			return nil
+		}
+	}
+	return ErrRestoreTargetMissing
+}
+
+func insertAt(images []interface{}, position int, image map[string]interface{}) []interface{} {
+	position = max(0, min(position, len(images)))
+	images = append(images, nil)
+	copy(images[position+1:], images[position:])
+	images[position] = image
+	return images
+}
+
+func (row trashedMediaRow) decode() (trashedMedia TrashedMedia, err error) {
+	trashedMedia = TrashedMedia{ID: row.ID, ImageURL: row.ImageURL, DeletedAt: row.DeletedAt, PurgeAt: row.PurgeAt}
+	if err = json.Unmarshal(row.Objects, &trashedMedia.objects); err != nil {
+		return
+	}
+	err = json.Unmarshal(row.References, &trashedMedia.References)
+	return
+}
@@ -0,0 +1,106 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"portfolio-cms-server/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInsertAt(t *testing.T) {
+	restored := map[string]interface{}{"imgURL": "restored"}
+
+	testCases := []struct {
+		name     string
+		images   []interface{}
+		position int
+		expected []interface{}
+	}{
+		{name: "empty list", images: nil, position: 3, expected: []interface{}{restored}},
+		{name: "first", images: []interface{}{"a", "b"}, position: 0, expected: []interface{}{restored, "a", "b"}},
+		{name: "middle", images: []interface{}{"a", "b"}, position: 1, expected: []interface{}{"a", restored, "b"}},
+		{name: "last", images: []interface{}{"a", "b"}, position: 2, expected: []interface{}{"a", "b", restored}},
+		{name: "beyond the end", images: []interface{}{"a", "b"}, position: 5, expected: []interface{}{"a", "b", restored}},
+		{name: "negative position", images: []interface{}{"a", "b"}, position: -1, expected: []interface{}{restored, "a", "b"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if images := insertAt(testCase.images, testCase.position, restored); !reflect.DeepEqual(images, testCase.expected) {
+				t.Fatalf("expected %v, got %v", testCase.expected, images)
+			}
+		})
+	}
+}
+
+func TestMoveToTrash(t *testing.T) {
+	useTestStorage(t)
+	putTestObject(t, "project-a", "image")
+	putTestObject(t, "originals/project-a", "original")
+
+	objects := []trashedObject{
+		{Key: "project-a", TrashKey: "trash/1/project-a"},
+		{Key: "originals/project-a", TrashKey: "trash/1/originals/project-a"},
+		{Key: "project-a-640w", TrashKey: "trash/1/project-a-640w"},
+	}
+	if err := moveToTrash(objects); err != nil {
+		t.Fatalf("expected the objects to be moved, got %s", err.Error())
+	}
+
+	for _, key := range []string{"project-a", "originals/project-a", "trash/1/project-a-640w"} {
+		if _, err := utils.GetStorage().Stat(key); !errors.Is(err, utils.ErrObjectNotFound) {
+			t.Fatalf("expected %s not to be stored, got %v", key, err)
+		}
+	}
+	expectTestObject(t, "trash/1/project-a", "image")
+	expectTestObject(t, "trash/1/originals/project-a", "original")
+}
+
+func TestCopyTrashedObjects(t *testing.T) {
+	useTestStorage(t)
+	putTestObject(t, "trash/1/project-a", "image")
+	putTestObject(t, "trash/1/originals/project-a", "original")
+	// left in place when the move to the trash failed
+	putTestObject(t, "project-a-640w", "variant")
+	putTestObject(t, "trash/1/project-a-640w", "stale variant")
+
+	objects := []trashedObject{
+		{Key: "project-a", TrashKey: "trash/1/project-a"},
+		{Key: "originals/project-a", TrashKey: "trash/1/originals/project-a"},
+		{Key: "project-a-640w", TrashKey: "trash/1/project-a-640w"},
+		{Key: "project-a-1280w", TrashKey: "trash/1/project-a-1280w"},
+	}
+	copied, err := copyTrashedObjects(objects)
+	if err != nil {
+		t.Fatalf("expected the objects to be copied, got %s", err.Error())
+	}
+	if !reflect.DeepEqual(copied, objects[:2]) {
+		t.Fatalf("expected only the objects which were not in place to be copied, got %+v", copied)
+	}
+
+	expectTestObject(t, "project-a", "image")
+	expectTestObject(t, "originals/project-a", "original")
+	expectTestObject(t, "project-a-640w", "variant")
+	// the trashed copies are deleted by the caller once the restore is committed
+	expectTestObject(t, "trash/1/project-a", "image")
+}
+
+func putTestObject(t *testing.T, key, content string) {
+	if err := utils.GetStorage().Put(key, strings.NewReader(content), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectTestObject(t *testing.T, key, expected string) {
+	content, _, err := utils.GetStorage().Get(key)
+	if err != nil {
+		t.Fatalf("expected %s to be stored, got %s", key, err.Error())
+	}
+	defer content.Close()
+
+	if stored, _ := io.ReadAll(content); string(stored) != expected {
+		t.Fatalf("expected %s to hold %q, got %q", key, expected, stored)
+	}
+}
@@ -0,0 +1,374 @@
+package files
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"portfolio-cms-server/database"
+	"portfolio-cms-server/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination,expiration"
+
+	// tusUploadLifetime is the time a resumable upload can take before it is discarded
+	tusUploadLifetime = 24 * time.Hour
+)
+
+var (
+	ErrUploadOffsetMismatch = errors.New("the upload offset does not match the received bytes")
+	ErrUploadLengthExceeded = errors.New("the chunk exceeds the upload length")
+	ErrUploadLocked         = errors.New("the upload is being written by another request")
+
+	// tusLocks serialises the writes to the same upload
+	tusLocks = sync.Map{}
+)
+
+// TusMaxSize is the largest upload accepted by any upload target
+func TusMaxSize() int64 {
+	return max(MaxUploadSize(UploadTypeImage), MaxUploadSize(UploadTypeCV), MaxUploadSize(UploadTypeAttachment))
+}
+
+// ParseTusMetadata decodes the Upload-Metadata header - comma separated keys with optional base64 encoded values
+func ParseTusMetadata(header string) (metadata map[string]string, err error) {
+	metadata = map[string]string{}
+	if len(strings.TrimSpace(header)) == 0 {
+		return
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encodedValue, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if len(key) == 0 {
+			return nil, errors.New("empty upload metadata key")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encodedValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload metadata value of %s - %s", key, err.Error())
+		}
+		metadata[key] = string(value)
+	}
+	return
+}
+
+// CreateTusUpload validates the announced length against the limits of the upload target and starts a multipart
+// upload to a private staging key. The title and file name of an attachment are kept for the completion, and its
+// project or job has to exist. The expired resumable uploads are discarded on the way.
+func CreateTusUpload(request TusCreationRequest) (upload TusUpload, err error) {
+	uploader, ok := utils.GetMultipartUploader()
+	if !ok {
+		return upload, ErrDirectUploadNotSupported
+	}
+
+	targetName, err := uploadTargetName(request.Target, request.ProjectTitle, request.CompanyName)
+	if err != nil {
+		return
+	}
+
+	uploadType := targetUploadType(request.Target)
+	if request.Length > MaxUploadSize(uploadType) {
+		return upload, fmt.Errorf("%w - the file exceeds the maximum size of %d bytes", ErrFileTooLarge, MaxUploadSize(uploadType))
+	}
+	if collection, isAttachment := attachmentTargetCollections[request.Target]; isAttachment {
+		if err = checkUploadTarget(collection, targetName); err != nil {
+			return
+		}
+	}
+
+	discardExpiredTusUploads()
+
+	uploadID, err := uuid.NewRandom()
+	if err != nil {
+		return
+	}
+
+	upload = TusUpload{
+		ID:         uploadID.String(),
+		StagingKey: "uploads/" + uploadID.String(),
+		Target:     request.Target,
+		TargetName: targetName,
+		Title:      request.Title,
+		FileName:   request.FileName,
+		Metadata:   request.Metadata,
+		Length:     request.Length,
+		ExpiresAt:  time.Now().Add(tusUploadLifetime).UTC(),
+	}
+
+	upload.StorageUploadID, err = uploader.CreateMultipartUpload(upload.StagingKey, "application/octet-stream")
+	if err != nil {
+		return
+	}
+
+	_, err = database.ExecuteNamedQuery(
+		`INSERT INTO tus_uploads (id, staging_key, storage_upload_id, target, target_name, title, file_name, metadata, length, expires_at)
+				VALUES (:id, :staging_key, :storage_upload_id, :target, :target_name, :title, :file_name, :metadata, :length, :expires_at);`,
+		map[string]interface{}{
+			"id":                upload.ID,
+			"staging_key":       upload.StagingKey,
+			"storage_upload_id": upload.StorageUploadID,
+			"target":            upload.Target,
+			"target_name":       upload.TargetName,
+			"title":             upload.Title,
+			"file_name":         upload.FileName,
+			"metadata":          upload.Metadata,
+			"length":            upload.Length,
+			"expires_at":        upload.ExpiresAt,
+		},
+	)
+	if err != nil {
+		_ = uploader.AbortMultipartUpload(upload.StagingKey, upload.StorageUploadID)
+	}
+	return
+}
+
+// GetTusUpload gets the resumable upload with its current offset, ErrUploadNotFound if it is unknown or expired
+func GetTusUpload(uploadID string) (upload TusUpload, err error) {
+	err = database.GetSingleRecordNamedQuery(
+		&upload,
+		`SELECT id, staging_key, storage_upload_id, target, target_name, title, file_name, metadata, length, stored_offset, parts, expires_at
+				FROM tus_uploads
+				WHERE id = :id
+				  AND expires_at > NOW();`,
+		map[string]interface{}{"id": uploadID},
+	)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			err = ErrUploadNotFound
+		}
+		return
+	}
+
+	chunkSize, err := bufferedChunkSize(upload.ID)
+	upload.Offset = upload.StoredOffset + chunkSize
+	return
+}
+
+// WriteTusChunk appends the chunk to the upload at the given offset. The received bytes are buffered in a local
+// chunk file and uploaded to the storage as a part once there is enough of them, so the chunk size is up to the
+// client. The bytes written before a connection drops are kept, the client resumes from the returned offset.
+// When the last byte is received the upload is completed and the file is saved to the upload target - the result
+// is the updated collection of the target (the attachments of the project or job, or the CV link).
+func WriteTusChunk(uploadID string, offset int64, content io.Reader) (upload TusUpload, completed bool, result interface{}, err error) {
+	lock, _ := tusLocks.LoadOrStore(uploadID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return upload, false, nil, ErrUploadLocked
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	upload, err = GetTusUpload(uploadID)
+	if err != nil {
+		return
+	}
+	if offset != upload.Offset {
+		return upload, false, nil, fmt.Errorf("%w - expected the offset %d", ErrUploadOffsetMismatch, upload.Offset)
+	}
+
+	chunkPath, err := bufferedChunkPath(upload.ID)
+	if err != nil {
+		return
+	}
+
+	writtenBytes, err := appendToFile(chunkPath, io.LimitReader(content, upload.Length-upload.Offset))
+	upload.Offset += writtenBytes
+	if err != nil {
+		return
+	}
+	if extraBytes, _ := io.ReadFull(content, make([]byte, 1)); extraBytes > 0 {
+		return upload, false, nil, fmt.Errorf("%w of %d bytes", ErrUploadLengthExceeded, upload.Length)
+	}
+
+	isLastChunk := upload.Offset == upload.Length
+	bufferedBytes := upload.Offset - upload.StoredOffset
+	if bufferedBytes >= utils.MinMultipartPartSize || (isLastChunk && bufferedBytes > 0) {
+		if err = storeBufferedChunk(&upload, chunkPath); err != nil {
+			return
+		}
+	}
+	if !isLastChunk {
+		return
+	}
+
+	result, err = completeTusUpload(upload)
+	return upload, err == nil, result, err
+}
+
+// TerminateTusUpload discards the resumable upload and its received bytes
+func TerminateTusUpload(uploadID string) (err error) {
+	lock, _ := tusLocks.LoadOrStore(uploadID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return ErrUploadLocked
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	upload, err := GetTusUpload(uploadID)
+	if err != nil {
+		return
+	}
+	return discardTusUpload(upload)
+}
+
+// storeBufferedChunk uploads the chunk file as the next part. The chunk file is removed before the stored offset
+// is updated, so after a failure in between the part is uploaded again (replacing it) rather than counted twice.
+func storeBufferedChunk(upload *TusUpload, chunkPath string) (err error) {
+	uploader, ok := utils.GetMultipartUploader()
+	if !ok {
+		return ErrDirectUploadNotSupported
+	}
+
+	chunk, err := os.Open(chunkPath)
+	if err != nil {
+		return
+	}
+	err = uploader.UploadPart(upload.StagingKey, upload.StorageUploadID, upload.Parts+1, chunk)
+	chunk.Close()
+	if err != nil {
+		return
+	}
+
+	if err = os.Remove(chunkPath); err != nil {
+		return
+	}
+
+	upload.Parts++
+	upload.StoredOffset = upload.Offset
+	_, err = database.ExecuteNamedQuery(
+		`UPDATE tus_uploads SET stored_offset = :stored_offset, parts = :parts WHERE id = :id;`,
+		map[string]interface{}{"id": upload.ID, "stored_offset": upload.StoredOffset, "parts": upload.Parts},
+	)
+	return
+}
+
+// completeTusUpload assembles the parts into the staging object and processes it as the presigned uploads are.
+// The upload is consumed first, so it is completed only once.
+func completeTusUpload(upload TusUpload) (result interface{}, err error) {
+	uploader, ok := utils.GetMultipartUploader()
+	if !ok {
+		return nil, ErrDirectUploadNotSupported
+	}
+
+	deletion, err := database.ExecuteNamedQuery(`DELETE FROM tus_uploads WHERE id = :id;`, map[string]interface{}{"id": upload.ID})
+	if err != nil {
+		return
+	}
+	if deletedRows, _ := deletion.RowsAffected(); deletedRows == 0 {
+		return nil, ErrUploadNotFound
+	}
+	tusLocks.Delete(upload.ID)
+
+	if err = uploader.CompleteMultipartUpload(upload.StagingKey, upload.StorageUploadID); err != nil {
+		_ = uploader.AbortMultipartUpload(upload.StagingKey, upload.StorageUploadID)
+		return nil, err
+	}
+	defer func() {
+		_ = utils.GetStorage().Delete(upload.StagingKey)
+	}()
+
+	return processStagedUpload(pendingUpload{
+		StagingKey: upload.StagingKey,
+		Target:     upload.Target,
+		TargetName: upload.TargetName,
+		Title:      upload.Title,
+		FileName:   upload.FileName,
+	})
+}
+
+func discardTusUpload(upload TusUpload) (err error) {
+	uploader, ok := utils.GetMultipartUploader()
+	if !ok {
+		return ErrDirectUploadNotSupported
+	}
+
+	if err = uploader.AbortMultipartUpload(upload.StagingKey, upload.StorageUploadID); err != nil {
+		return
+	}
+
+	chunkPath, err := bufferedChunkPath(upload.ID)
+	if err != nil {
+		return
+	}
+	if err = os.Remove(chunkPath); err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	_, err = database.ExecuteNamedQuery(`DELETE FROM tus_uploads WHERE id = :id;`, map[string]interface{}{"id": upload.ID})
+	tusLocks.Delete(upload.ID)
+	return
+}
+
+// discardExpiredTusUploads aborts the resumable uploads which were not finished in time, the failures are only
+// logged as the uploads are retried on the next creation
+func discardExpiredTusUploads() {
+	var expiredUploads []TusUpload
+	err := database.GetMultipleRecords(
+		&expiredUploads,
+		`SELECT id, staging_key, storage_upload_id, target, target_name, metadata, length, stored_offset, parts, expires_at
+				FROM tus_uploads
+				WHERE expires_at < NOW();`,
+	)
+	if err != nil {
+		utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Failed to get the expired resumable uploads")
+		return
+	}
+
+	for _, upload := range expiredUploads {
+		if err = discardTusUpload(upload); err != nil {
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": err.Error()}).
+				Warnf("Failed to discard the expired resumable upload - %s", upload.ID)
+		}
+	}
+}
+
+// bufferedChunkPath gets the local file the received bytes are buffered in until they are uploaded as a part
+func bufferedChunkPath(uploadID string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", ErrUploadNotFound
+	}
+
+	directory := filepath.Join(os.TempDir(), "tus-uploads")
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return "", err
+	}
+	return filepath.Join(directory, uploadID), nil
+}
+
+// bufferedChunkSize gets the number of the buffered bytes, the chunk file is missing after a restart of the server
+// on another machine, in which case the client resumes from the stored offset
+func bufferedChunkSize(uploadID string) (int64, error) {
+	chunkPath, err := bufferedChunkPath(uploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(chunkPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func appendToFile(path string, content io.Reader) (writtenBytes int64, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+
+	writtenBytes, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package files
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		metadata map[string]string
+	}{
+		{name: "empty header", header: " ", metadata: map[string]string{}},
+		{
+			name:     "keys with and without values",
+			header:   "filename d29ybGQucG5n, target cHJvamVjdHM=,is_confidential",
+			metadata: map[string]string{"filename": "world.png", "target": "projects", "is_confidential": ""},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			metadata, err := ParseTusMetadata(testCase.header)
+			if err != nil {
+				t.Fatalf("expected the metadata to be parsed, got %s", err.Error())
+			}
+			if !reflect.DeepEqual(metadata, testCase.metadata) {
+				t.Fatalf("expected %v, got %v", testCase.metadata, metadata)
+			}
+		})
+	}
+}
+
+func TestParseTusMetadataRejectsInvalidHeaders(t *testing.T) {
+	for _, header := range []string{"filename d29ybGQucG5n,", ",filename", "filename not-base64!"} {
+		if metadata, err := ParseTusMetadata(header); err == nil {
+			t.Fatalf("expected %q to be rejected, got %v", header, metadata)
+		}
+	}
+}
+
+func TestTusMaxSizeCoversTheAttachments(t *testing.T) {
+	limits := uploadLimits
+	t.Cleanup(func() { uploadLimits = limits })
+	uploadLimits = UploadLimits{MaxImageSize: 10 << 20, MaxCVSize: 20 << 20, MaxAttachmentSize: 100 << 20}
+
+	if maxSize := TusMaxSize(); maxSize != 100<<20 {
+		t.Fatalf("expected the attachment limit of %d bytes, got %d", 100<<20, maxSize)
+	}
+}
@@ -0,0 +1,167 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"sort"
+	"strings"
+)
+
+// maxImagePixels bounds the decoded image to about 100MB
+const maxImagePixels = 25_000_000
+
+const (
+	UploadTypeImage      = "image"
+	UploadTypeCV         = "cv"
+	UploadTypeAttachment = "attachment"
+
+	// attachmentSniffLength covers the MP4 ftyp box and the WebM EBML header
+	attachmentSniffLength = 64
+)
+
+type UploadLimits struct {
+	MaxImageSize      int64
+	MaxCVSize         int64
+	MaxAttachmentSize int64
+}
+
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrFileTooLarge         = errors.New("file too large")
+
+	uploadLimits = UploadLimits{MaxImageSize: 10 << 20, MaxCVSize: 20 << 20, MaxAttachmentSize: 100 << 20}
+
+	// allowedImageFormats maps the image decoder format names to the content types accepted for the upload
+	allowedImageFormats = map[string]string{"jpeg": "image/jpeg", "png": "image/png", "svg": svgContentType}
+)
+
+// ConfigureUploadLimits sets the maximum sizes (in bytes) of the uploads, the zero values keep the defaults
+func ConfigureUploadLimits(limits UploadLimits) {
+	if limits.MaxImageSize > 0 {
+		uploadLimits.MaxImageSize = limits.MaxImageSize
+	}
+	if limits.MaxCVSize > 0 {
+		uploadLimits.MaxCVSize = limits.MaxCVSize
+	}
+	if limits.MaxAttachmentSize > 0 {
+		uploadLimits.MaxAttachmentSize = limits.MaxAttachmentSize
+	}
+}
+
+// MaxUploadSize gets the maximum size (in bytes) of the given upload type
+func MaxUploadSize(uploadType string) int64 {
+	switch uploadType {
+	case UploadTypeCV:
+		return uploadLimits.MaxCVSize
+	case UploadTypeAttachment:
+		return uploadLimits.MaxAttachmentSize
+	default:
+		return uploadLimits.MaxImageSize
+	}
+}
+
+// AllowedContentTypes lists the content types accepted for the given upload type
+func AllowedContentTypes(uploadType string) (contentTypes []string) {
+	switch uploadType {
+	case UploadTypeCV:
+		return []string{"application/pdf"}
+	case UploadTypeAttachment:
+		return []string{"application/pdf", "video/mp4", "video/webm"}
+	}
+
+	for _, contentType := range allowedImageFormats {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	return
+}
+
+// ValidateUpload checks the size of the form data file and detects its content type from the actual bytes,
+// ignoring the content type sent by the client. It returns ErrFileTooLarge or ErrUnsupportedMediaType (wrapped
+// with the details) if the file is not accepted for the upload type.
+func ValidateUpload(file *multipart.FileHeader, uploadType string) (contentType string, err error) {
+	if file.Size > MaxUploadSize(uploadType) {
+		return "", fmt.Errorf("%w - the file exceeds the maximum size of %d bytes", ErrFileTooLarge, MaxUploadSize(uploadType))
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	return DetectContentType(content, uploadType)
+}
+
+// DetectContentType sniffs the content type of the file content - images are recognised by the image decoders
+// (SVGs by parsing them), PDFs by their signature and the videos by their container headers
+func DetectContentType(content io.Reader, uploadType string) (contentType string, err error) {
+	if uploadType == UploadTypeAttachment {
+		head := make([]byte, attachmentSniffLength)
+		readBytes, _ := io.ReadFull(content, head)
+		head = head[:readBytes]
+
+		switch {
+		case bytes.HasPrefix(head, []byte("%PDF-")):
+			return "application/pdf", nil
+		case isMP4(head):
+			return "video/mp4", nil
+		case isWebM(head):
+			return "video/webm", nil
+		default:
+			return "", unsupportedMediaTypeError(uploadType)
+		}
+	}
+
+	if uploadType == UploadTypeCV {
+		signature := make([]byte, 5)
+		if _, err = io.ReadFull(content, signature); err != nil || !bytes.Equal(signature, []byte("%PDF-")) {
+			return "", unsupportedMediaTypeError(uploadType)
+		}
+		return "application/pdf", nil
+	}
+
+	buffered := bufio.NewReader(content)
+	if isSVG(buffered) {
+		if _, _, _, err = sanitizeSVG(buffered); err != nil {
+			return
+		}
+		return svgContentType, nil
+	}
+
+	config, format, err := image.DecodeConfig(buffered)
+	if err != nil {
+		return "", unsupportedMediaTypeError(uploadType)
+	}
+	if err = checkImageResolution(config); err != nil {
+		return
+	}
+
+	contentType, found := allowedImageFormats[format]
+	if !found {
+		return "", unsupportedMediaTypeError(uploadType)
+	}
+	return contentType, nil
+}
+
+// checkImageResolution guards the memory of the image decoding, which depends on the resolution rather than the
+// (compressed) file size
+func checkImageResolution(config image.Config) error {
+	if config.Width*config.Height > maxImagePixels {
+		return fmt.Errorf("%w - the image exceeds the maximum resolution of %d pixels", ErrFileTooLarge, maxImagePixels)
+	}
+	return nil
+}
+
+func unsupportedMediaTypeError(uploadType string) error {
+	return fmt.Errorf(
+		"%w - the file content is not one of %s",
+		ErrUnsupportedMediaType,
+		strings.Join(AllowedContentTypes(uploadType), ", "),
+	)
+}
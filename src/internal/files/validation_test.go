@@ -0,0 +1,96 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	webm := []byte{0x1A, 0x45, 0xDF, 0xA3, 0x87, 0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'}
+	mp4 := append(binary.BigEndian.AppendUint32(nil, 16), []byte("ftypisom\x00\x00\x02\x00")...)
+	heic := append(binary.BigEndian.AppendUint32(nil, 16), []byte("ftypheic\x00\x00\x00\x00")...)
+	pdf := []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	svg := []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"/>`)
+
+	// a PNG header announcing a resolution above the limit, the pixels are never decoded
+	ihdr := binary.BigEndian.AppendUint32(nil, 10_000)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 10_000)
+	oversizedPNG := append(append([]byte{}, pngSignature...), pngChunk("IHDR", append(ihdr, 8, 2, 0, 0, 0))...)
+
+	testCases := []struct {
+		name        string
+		content     []byte
+		uploadType  string
+		contentType string
+		err         error
+	}{
+		{name: "JPEG image", content: encodeTestJPEG(t, 4, 2), uploadType: UploadTypeImage, contentType: "image/jpeg"},
+		{name: "PNG image", content: encodeTestPNG(t, 4, 2), uploadType: UploadTypeImage, contentType: "image/png"},
+		{name: "SVG image", content: svg, uploadType: UploadTypeImage, contentType: svgContentType},
+		{name: "PDF as an image", content: pdf, uploadType: UploadTypeImage, err: ErrUnsupportedMediaType},
+		{name: "text as an image", content: []byte("just text"), uploadType: UploadTypeImage, err: ErrUnsupportedMediaType},
+		{name: "resolution above the limit", content: oversizedPNG, uploadType: UploadTypeImage, err: ErrFileTooLarge},
+		{name: "PDF CV", content: pdf, uploadType: UploadTypeCV, contentType: "application/pdf"},
+		{name: "image as a CV", content: encodeTestPNG(t, 4, 2), uploadType: UploadTypeCV, err: ErrUnsupportedMediaType},
+		{name: "empty CV", content: []byte{}, uploadType: UploadTypeCV, err: ErrUnsupportedMediaType},
+		{name: "PDF attachment", content: pdf, uploadType: UploadTypeAttachment, contentType: "application/pdf"},
+		{name: "MP4 attachment", content: mp4, uploadType: UploadTypeAttachment, contentType: "video/mp4"},
+		{name: "WebM attachment", content: webm, uploadType: UploadTypeAttachment, contentType: "video/webm"},
+		{name: "HEIC attachment", content: heic, uploadType: UploadTypeAttachment, err: ErrUnsupportedMediaType},
+		{name: "image attachment", content: encodeTestJPEG(t, 4, 2), uploadType: UploadTypeAttachment, err: ErrUnsupportedMediaType},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			contentType, err := DetectContentType(bytes.NewReader(testCase.content), testCase.uploadType)
+			if testCase.err != nil {
+				if !errors.Is(err, testCase.err) {
+					t.Fatalf("expected %v, got %v", testCase.err, err)
+				}
+				return
+			}
+			if err != nil || contentType != testCase.contentType {
+				t.Fatalf("expected %s, got %s and %v", testCase.contentType, contentType, err)
+			}
+		})
+	}
+}
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 60), G: uint8(y * 60), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeTestJPEG(t *testing.T, width, height int) []byte {
+	encoded := &bytes.Buffer{}
+	if err := jpeg.Encode(encoded, testImage(width, height), nil); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+func encodeTestPNG(t *testing.T, width, height int) []byte {
+	encoded := &bytes.Buffer{}
+	if err := png.Encode(encoded, testImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(append(chunk, chunkType...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"os"
 	"portfolio-cms-server/config"
 	"portfolio-cms-server/database"
+	"portfolio-cms-server/internal/auth"
+	"portfolio-cms-server/internal/files"
 	"portfolio-cms-server/server"
 	"portfolio-cms-server/utils"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// migrationTimeout bounds each migration, the index creations of the larger tables take a while
+const migrationTimeout = 5 * time.Minute
+
 func init() {
 	app, err := config.Init()
 	if err != nil {
@@ -26,20 +36,160 @@ func init() {
 		app.DBName,
 	)
 
-	utils.CreateS3Session(
-		app.S3BucketName,
-		app.S3BucketKey,
-		app.S3BucketURL,
-		app.S3BucketRegion,
-		app.AWSAccessKey,
-		app.AWSSecretKey,
-		app.S3ACL,
-	)
+	if app.StorageBackend == "local" {
+		err = utils.CreateLocalStorage(app.LocalStoragePath, app.LocalStorageURL)
+		if err != nil {
+			utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Fatal("Error on local storage initialization")
+		}
+	} else {
+		err = utils.CreateS3Session(
+			app.S3BucketName,
+			app.S3BucketKey,
+			app.S3BucketURL,
+			app.S3BucketRegion,
+			app.AWSAccessKey,
+			app.AWSSecretKey,
+			app.S3ACL,
+			utils.S3EndpointOptions{
+				Endpoint:           app.S3Endpoint,
+				ForcePathStyle:     app.S3ForcePathStyle,
+				InsecureSkipVerify: app.S3InsecureSkipVerify,
+				CABundleFile:       app.S3CABundleFile,
+			},
+		)
+		if err != nil {
+			utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Fatal("Error on s3 session initialization")
+		}
+	}
 
 	utils.GetJWTKey(app.JWTSecret)
+	err = utils.LoadJWTSigningKeys(app.JWTPrivateKey, splitList(app.JWTPrivateKeyFiles), splitList(app.JWTPublicKeyFiles))
+	if err != nil {
+		utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Error on loading the JWT signing keys")
+	}
+
+	auth.ConfigureOIDC(auth.OIDCConfig{
+		Issuer:          app.OIDCIssuer,
+		ClientID:        app.OIDCClientID,
+		ClientSecret:    app.OIDCClientSecret,
+		RedirectURL:     app.OIDCRedirectURL,
+		Scopes:          strings.Fields(app.OIDCScopes),
+		AllowedSubjects: splitList(app.OIDCAllowedSubjects),
+		AllowedEmails:   splitList(app.OIDCAllowedEmails),
+	})
+
+	auth.ConfigureWebAuthn(auth.WebAuthnConfig{
+		RelyingPartyID:   app.WebAuthnRelyingPartyID,
+		RelyingPartyName: app.WebAuthnRelyingPartyName,
+		Origins:          splitList(app.WebAuthnOrigins),
+	})
+
+	variantWidths, err := parseIntList(app.ImageVariantWidths)
+	if err != nil {
+		utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Error on parsing the image variant widths")
+	}
+	files.ConfigureImageVariants(variantWidths)
+	files.ConfigureUploadLimits(files.UploadLimits{
+		MaxImageSize:      app.MaxImageUploadSize,
+		MaxCVSize:         app.MaxCVUploadSize,
+		MaxAttachmentSize: app.MaxAttachmentUploadSize,
+	})
+	files.ConfigureOriginalArchival(app.KeepOriginalImages)
+	files.ConfigureImageTransforms(files.ImageTransformConfig{
+		Secret:          app.ImageTransformSecret,
+		MemoryCacheSize: app.ImageTransformCacheSize,
+	})
+	files.ConfigureDecodeBudget(app.ImageDecodeBudget)
+
+	orphanCollection, err := parseOrphanCollectionConfig(app.OrphanGCInterval, app.OrphanGCGracePeriod)
+	if err != nil {
+		utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Error on parsing the orphan collection durations")
+	}
+	orphanCollection.Delete = app.OrphanGCDelete
+	orphanCollection.ProtectedKeys = []string{app.GeoFileKey}
+	files.ConfigureOrphanCollection(orphanCollection)
+
+	if len(app.TrashRetention) > 0 {
+		trashRetention, err := time.ParseDuration(app.TrashRetention)
+		if err != nil {
+			utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Error on parsing the trash retention")
+		}
+		files.ConfigureTrashRetention(trashRetention)
+	}
+
 	server.SetGeoFileKey(app.GeoFileKey)
+	server.SetServeLocalMedia(app.StorageBackend == "local")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1])
+		return
+	}
+	migrateDatabase()
+	files.StartOrphanCollector()
+	files.StartTrashPurger()
 	server.Run()
 }
+
+// runCommand runs one-off maintenance commands instead of the server, e.g. `./server backfill-placeholders`
+func runCommand(command string) {
+	switch command {
+	case "migrate":
+		migrateDatabase()
+	case "backfill-placeholders":
+		updatedImages, err := files.BackfillImagePlaceholders()
+		if err != nil {
+			utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Fatal("Error on backfilling the image placeholders")
+		}
+		utils.GetLogger().Infof("Backfilled the placeholders of %d images", updatedImages)
+	default:
+		utils.GetLogger().Fatalf("Unknown command %s, expected migrate or backfill-placeholders", command)
+	}
+}
+
+// migrateDatabase applies the pending database migrations, the server does not start without its tables
+func migrateDatabase() {
+	applied, err := database.Migrate(migrationTimeout)
+	if err != nil {
+		utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Fatal("Error on applying the database migrations")
+	}
+	for _, version := range applied {
+		utils.GetLogger().Infof("Applied the database migration %s", version)
+	}
+}
+
+// splitList splits comma separated config values, ignoring the empty entries
+func splitList(value string) (list []string) {
+	for _, entry := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(entry); len(trimmed) > 0 {
+			list = append(list, trimmed)
+		}
+	}
+	return
+}
+
+// parseIntList parses comma separated positive integer config values
+func parseIntList(value string) (list []int, err error) {
+	for _, entry := range splitList(value) {
+		number, parseErr := strconv.Atoi(entry)
+		if parseErr != nil || number <= 0 {
+			return nil, fmt.Errorf("%s is not a positive integer", entry)
+		}
+		list = append(list, number)
+	}
+	return
+}
+
+// parseOrphanCollectionConfig parses the optional durations of the orphan collection, e.g. 24h
+func parseOrphanCollectionConfig(interval, gracePeriod string) (collectionConfig files.OrphanCollectionConfig, err error) {
+	if len(interval) > 0 {
+		if collectionConfig.Interval, err = time.ParseDuration(interval); err != nil {
+			return
+		}
+	}
+	if len(gracePeriod) > 0 {
+		collectionConfig.GracePeriod, err = time.ParseDuration(gracePeriod)
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	validator "github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"portfolio-cms-server/internal/auth"
+	"portfolio-cms-server/utils"
+	"strconv"
+	"strings"
+)
+
+func CreateAPIKey(ginCtx *gin.Context) {
+	requestBody := auth.APIKeyRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	createdKey, err := auth.CreateAPIKey(requestBody)
+	if err != nil {
+		if strings.Contains(err.Error(), "param") {
+			ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to create an API key")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusCreated, createdKey)
+}
+
+func GetAPIKeys(ginCtx *gin.Context) {
+	apiKeys, err := auth.GetAPIKeys()
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on getting API keys from the database")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"apiKeys": apiKeys})
+}
+
+func RevokeAPIKey(ginCtx *gin.Context) {
+	id, err := strconv.Atoi(ginCtx.Param("id"))
+	if err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters, expected numeric id"})
+		return
+	}
+
+	apiKey, err := auth.RevokeAPIKey(id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": "API key not found"})
+			return
+		}
+
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on attempting to revoke API key %d", id)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, apiKey)
+}
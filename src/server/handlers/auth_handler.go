@@ -3,6 +3,7 @@ package handlers
 import (
 	validator "github.com/asaskevich/govalidator"
 	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"portfolio-cms-server/internal/auth"
@@ -10,7 +11,7 @@ import (
 	"strings"
 )
 
-func Login(ginCtx *gin.Context) {
+func Login(ginCtx *gin.Context, db *geoip2.Reader) {
 	request := auth.UserAuthData{}
 
 	if err := ginCtx.ShouldBind(&request); err != nil {
@@ -27,7 +28,7 @@ func Login(ginCtx *gin.Context) {
 		return
 	}
 
-	authToken, err := auth.Login(request)
+	authToken, err := auth.Login(db, ginCtx, request)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" || strings.Contains(err.Error(), "crypto/bcrypt") {
 			ginCtx.JSON(http.StatusUnauthorized, map[string]interface{}{"error": "invalid username or password"})
@@ -44,3 +45,36 @@ func Login(ginCtx *gin.Context) {
 	}
 	ginCtx.JSON(http.StatusOK, map[string]interface{}{"token": authToken})
 }
+
+func GetSessions(ginCtx *gin.Context) {
+	sessions, err := auth.GetSessions(ginCtx.GetString("sessionID"))
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on getting sessions from the database")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+func RevokeSession(ginCtx *gin.Context) {
+	err := auth.RevokeSession(ginCtx.Param("id"))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": "session not found"})
+			return
+		}
+
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on attempting to revoke session %s", ginCtx.Param("id"))
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{})
+}
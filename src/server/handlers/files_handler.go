@@ -1,43 +1,124 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	validator "github.com/asaskevich/govalidator"
 	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"portfolio-cms-server/internal/files"
 	"portfolio-cms-server/utils"
+	"time"
 )
 
+// batchMultipartOverhead is the allowance for the multipart boundaries and the form fields of a batch upload
+const batchMultipartOverhead = 4 << 20
+
+// collectionResponseKeys are the keys the image uploads to the collections respond with
+var collectionResponseKeys = map[string]string{
+	files.MediaCollectionProjects: "project_images",
+	files.MediaCollectionJobs:     "job_images",
+	files.MediaCollectionPartners: "partners",
+	files.MediaCollectionCarousel: "carousel_images",
+}
+
 func UploadCV(ginCtx *gin.Context) {
-	file, err := ginCtx.FormFile("file")
+	file, _ := ginCtx.FormFile("file")
+	variant := ginCtx.DefaultPostForm("variant", files.DefaultCVVariant)
+	language := ginCtx.PostForm("language")
+
+	version, err := files.UploadCV(file, variant, language)
+	if errors.Is(err, files.ErrInvalidCVVariant) {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+		return
+	}
 	if err != nil {
-		ginCtx.JSON(
-			http.StatusBadRequest,
-			map[string]interface{}{"message": "the provided file should be with the name 'file'."},
-		)
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to upload a CV")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
 		return
 	}
+	ginCtx.JSON(http.StatusCreated, map[string]interface{}{"cvLink": version.URL, "version": version})
+}
 
-	if file.Header.Get("Content-Type") != "application/pdf" {
-		ginCtx.JSON(
-			http.StatusBadRequest,
-			map[string]interface{}{"message": "provided file can only be of type pdf"},
-		)
+func UploadAttachment(ginCtx *gin.Context) {
+	file, _ := ginCtx.FormFile("file")
+	requestBody := files.AttachmentRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
 		return
 	}
 
-	cvLink, err := files.UploadCV(file)
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	attachments, err := files.UploadAttachment(file, requestBody)
+	switch {
+	case errors.Is(err, files.ErrInvalidUploadTarget):
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+		return
+	case errors.Is(err, files.ErrUploadTargetMissing):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	case err != nil:
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to upload an attachment")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusCreated, map[string]interface{}{"attachments": attachments})
+}
+
+func GetCVVersions(ginCtx *gin.Context) {
+	query := files.CVVersionsQuery{}
+
+	if err := ginCtx.ShouldBindQuery(&query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	versions, err := files.ListCVVersions(query.Variant, query.Language)
 	if err != nil {
 		utils.
 			GetLogger().
 			WithFields(log.Fields{"error": err.Error()}).
-			Error("Error on attempting to upload a CV")
+			Error("Error on getting the CV versions")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"versions": versions})
+}
+
+func SetCurrentCVVersion(ginCtx *gin.Context) {
+	versionID := ginCtx.Param("id")
+
+	version, err := files.SetCurrentCVVersion(versionID)
+	if errors.Is(err, files.ErrCVNotFound) {
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on setting the current CV version %s", versionID)
 
 		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
 		return
 	}
-	ginCtx.JSON(http.StatusCreated, map[string]interface{}{"cvLink": cvLink})
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"version": version})
 }
 
 func UploadProjectImage(ginCtx *gin.Context) {
@@ -47,12 +128,16 @@ func UploadProjectImage(ginCtx *gin.Context) {
 	if !found {
 		ginCtx.JSON(
 			http.StatusBadRequest,
-			map[string]interface{}{"error": "invalid parameters, expected projectTitle"},
+			map[string]interface{}{"message": "invalid parameters, expected projectTitle"},
 		)
 		return
 	}
 
 	projectImages, err := files.UploadProjectImage(image, projectTitle)
+	if errors.Is(err, files.ErrUploadTargetMissing) {
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	}
 	if err != nil {
 		utils.
 			GetLogger().
@@ -72,12 +157,16 @@ func UploadJobImage(ginCtx *gin.Context) {
 	if !found {
 		ginCtx.JSON(
 			http.StatusBadRequest,
-			map[string]interface{}{"error": "invalid parameters, expected companyName"},
+			map[string]interface{}{"message": "invalid parameters, expected companyName"},
 		)
 		return
 	}
 
 	jobImages, err := files.UploadJobImage(image, company)
+	if errors.Is(err, files.ErrUploadTargetMissing) {
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	}
 	if err != nil {
 		utils.
 			GetLogger().
@@ -122,6 +211,67 @@ func UploadCarouselImage(ginCtx *gin.Context) {
 	ginCtx.JSON(http.StatusCreated, map[string]interface{}{"carousel_images": carouselImages})
 }
 
+func UploadImageBatch(ginCtx *gin.Context) {
+	maxRequestSize := int64(files.MaxBatchUploadFiles)*files.MaxUploadSize(files.UploadTypeImage) + batchMultipartOverhead
+	ginCtx.Request.Body = http.MaxBytesReader(ginCtx.Writer, ginCtx.Request.Body, maxRequestSize)
+
+	form, err := ginCtx.MultipartForm()
+	if err != nil {
+		maxBytesError := &http.MaxBytesError{}
+		if errors.As(err, &maxBytesError) {
+			ginCtx.JSON(
+				http.StatusRequestEntityTooLarge,
+				map[string]interface{}{"message": fmt.Sprintf("the batch exceeds the maximum size of %d bytes", maxRequestSize)},
+			)
+			return
+		}
+
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters, expected a multipart form"})
+		return
+	}
+
+	requestBody := files.BatchUploadRequest{}
+	if err = ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	if _, err = validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	report, updatedCollections, err := files.UploadImageBatch(requestBody, form.File["images"])
+	switch {
+	case errors.Is(err, files.ErrInvalidBatchUpload), errors.Is(err, files.ErrInvalidUploadTarget):
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+		return
+	case errors.Is(err, files.ErrUploadTargetMissing):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+		return
+	case err != nil:
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to upload a batch of images")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+
+	response := collectionsResponse(updatedCollections)
+	response["report"] = report
+
+	switch {
+	case report.Failed == 0:
+		ginCtx.JSON(http.StatusCreated, response)
+	case report.Uploaded > 0:
+		ginCtx.JSON(http.StatusMultiStatus, response)
+	default:
+		ginCtx.JSON(http.StatusUnprocessableEntity, response)
+	}
+}
+
 func DeleteImage(ginCtx *gin.Context) {
 	requestBody := files.ImageDeleteRequestBody{}
 
@@ -135,7 +285,11 @@ func DeleteImage(ginCtx *gin.Context) {
 		return
 	}
 
-	err := files.DeleteImage(requestBody.ImageURL)
+	trashID, updatedCollections, err := files.DeleteImage(requestBody.ImageURL, requestBody.Collection, requestBody.Name)
+	if errors.Is(err, files.ErrNotStorageURL) {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		utils.
 			GetLogger().
@@ -145,5 +299,341 @@ func DeleteImage(ginCtx *gin.Context) {
 		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
 		return
 	}
+
+	response, err := uploadResponse(updatedCollections)
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on attempting to delete image - %s", requestBody.ImageURL)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	if len(trashID) > 0 {
+		response["trashID"] = trashID
+	}
+	ginCtx.JSON(http.StatusOK, response)
+}
+
+func UpdateImageMetadata(ginCtx *gin.Context) {
+	requestBody := files.ImageMetadataRequestBody{}
+
+	if err := ginCtx.ShouldBindJSON(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	updatedCollections, err := files.UpdateImageMetadata(requestBody)
+	if errors.Is(err, files.ErrInvalidImageMetadata) || errors.Is(err, files.ErrNotStorageURL) {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, files.ErrImageNotFound) {
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on attempting to update the image metadata - %s%s", requestBody.ImageURL, requestBody.ImageID)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, collectionsResponse(updatedCollections))
+}
+
+func SignImageTransform(ginCtx *gin.Context) {
+	query := files.ImageTransformSignQuery{}
+
+	if err := ginCtx.ShouldBindQuery(&query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	transformPath, err := files.SignImageTransform(query.Key, query.ImageTransformOptions)
+	if errors.Is(err, files.ErrInvalidTransform) {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, files.ErrTransformsDisabled) {
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on signing the transformation of the image %s", query.Key)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"url": transformPath})
+}
+
+// collectionsResponse lists the updated images of each collection and project or job the request changed
+func collectionsResponse(updatedCollections []files.UpdatedCollection) map[string]interface{} {
+	return map[string]interface{}{"collections": updatedCollections}
+}
+
+// uploadResponse holds the updated images under the same keys as the upload endpoints of the collections respond
+// with - the images of a project or job are listed only if the request changed a single one of them. The images of
+// every changed collection and project or job are listed under collections too.
+func uploadResponse(updatedCollections []files.UpdatedCollection) (response map[string]interface{}, err error) {
+	response = collectionsResponse(updatedCollections)
+
+	updatedItems := map[string]int{}
+	for _, collection := range updatedCollections {
+		updatedItems[collection.Collection]++
+	}
+
+	for _, collection := range updatedCollections {
+		switch collection.Collection {
+		case files.MediaCollectionProjects, files.MediaCollectionJobs:
+			if updatedItems[collection.Collection] == 1 {
+				response[collectionResponseKeys[collection.Collection]] = collection.Images
+			}
+		case files.MediaCollectionCarousel:
+			// only the URL and the dimensions, as the carousel upload responds with
+			carouselImages := []files.CarouselImages{}
+			if err = json.Unmarshal(collection.Images, &carouselImages); err != nil {
+				return
+			}
+			response[collectionResponseKeys[collection.Collection]] = carouselImages
+		default:
+			response[collectionResponseKeys[collection.Collection]] = collection.Images
+		}
+	}
+	return
+}
+
+func PresignUpload(ginCtx *gin.Context) {
+	requestBody := files.PresignRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	presignedUpload, err := files.PresignUpload(requestBody)
+	if err != nil {
+		respondWithUploadError(ginCtx, err, "Error on attempting to presign an upload")
+		return
+	}
+	ginCtx.JSON(http.StatusCreated, presignedUpload)
+}
+
+func CompleteUpload(ginCtx *gin.Context) {
+	requestBody := files.CompleteUploadRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	target, result, err := files.CompleteUpload(requestBody.UploadID)
+	if err != nil {
+		respondWithUploadError(ginCtx, err, "Error on attempting to complete an upload")
+		return
+	}
+
+	// the same response as the form data upload of the target
+	responseKeys := map[string]string{
+		files.UploadTargetCV:           "cvLink",
+		files.UploadTargetProjectImage: "project_images",
+		files.UploadTargetJobImage:     "job_images",
+		files.UploadTargetPartners:     "partners",
+		files.UploadTargetCarousel:     "carousel_images",
+		// the same response as the form data attachment upload
+		files.UploadTargetProjectAttachment: "attachments",
+		files.UploadTargetJobAttachment:     "attachments",
+	}
+	ginCtx.JSON(http.StatusCreated, map[string]interface{}{responseKeys[target]: result})
+}
+
+func respondWithUploadError(ginCtx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, files.ErrFileTooLarge):
+		ginCtx.JSON(http.StatusRequestEntityTooLarge, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrUnsupportedMediaType):
+		ginCtx.JSON(http.StatusUnsupportedMediaType, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrInvalidUploadTarget):
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrUploadTargetMissing):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrUploadNotFound):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrUploadIncomplete), errors.Is(err, files.ErrUploadOffsetMismatch):
+		ginCtx.JSON(http.StatusConflict, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrUploadLengthExceeded):
+		ginCtx.JSON(http.StatusRequestEntityTooLarge, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrUploadLocked):
+		ginCtx.JSON(http.StatusLocked, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrDirectUploadNotSupported):
+		ginCtx.JSON(http.StatusNotImplemented, map[string]interface{}{"message": err.Error()})
+	default:
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error(message)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+	}
+}
+
+func GetMediaLibrary(ginCtx *gin.Context) {
+	query := files.MediaLibraryQuery{}
+
+	if err := ginCtx.ShouldBindQuery(&query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	page, err := files.ListMedia(query)
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to list the media library")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, page)
+}
+
+func GetOrphanedMedia(ginCtx *gin.Context) {
+	query := files.OrphanQuery{}
+
+	if err := ginCtx.ShouldBindQuery(&query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	gracePeriod, err := parseGracePeriod(query.GracePeriod)
+	if err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	report, err := files.CollectOrphans(gracePeriod, true, nil)
+	respondWithOrphanReport(ginCtx, report, err)
+}
+
+func DeleteOrphanedMedia(ginCtx *gin.Context) {
+	requestBody := files.OrphanDeletionRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "the deletion has to be confirmed"})
+		return
+	}
+
+	gracePeriod, err := parseGracePeriod(requestBody.GracePeriod)
+	if err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	report, err := files.CollectOrphans(gracePeriod, false, requestBody.Keys)
+	respondWithOrphanReport(ginCtx, report, err)
+}
+
+// parseGracePeriod parses the grace period duration (e.g. 72h), the configured one is used if it is empty
+func parseGracePeriod(value string) (time.Duration, error) {
+	if len(value) == 0 {
+		return files.DefaultOrphanGracePeriod(), nil
+	}
+	return time.ParseDuration(value)
+}
+
+func respondWithOrphanReport(ginCtx *gin.Context, report files.OrphanReport, err error) {
+	if errors.Is(err, files.ErrInvalidGracePeriod) {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to collect the orphaned media")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, report)
+}
+
+func GetTrash(ginCtx *gin.Context) {
+	trash, err := files.ListTrash()
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to list the trashed media")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"trash": trash})
+}
+
+func RestoreTrashedMedia(ginCtx *gin.Context) {
+	updatedCollections, err := files.RestoreTrashedMedia(ginCtx.Param("id"))
+	if err != nil {
+		respondWithTrashError(ginCtx, err, "Error on attempting to restore the trashed media")
+		return
+	}
+	ginCtx.JSON(http.StatusOK, collectionsResponse(updatedCollections))
+}
+
+func PurgeTrashedMedia(ginCtx *gin.Context) {
+	if err := files.PurgeTrashedMedia(ginCtx.Param("id")); err != nil {
+		respondWithTrashError(ginCtx, err, "Error on attempting to purge the trashed media")
+		return
+	}
 	ginCtx.JSON(http.StatusOK, map[string]interface{}{})
 }
+
+func respondWithTrashError(ginCtx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, files.ErrTrashedMediaNotFound):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"message": err.Error()})
+	case errors.Is(err, files.ErrRestoreTargetMissing), errors.Is(err, files.ErrTrashedObjectsMissing):
+		ginCtx.JSON(http.StatusConflict, map[string]interface{}{"message": err.Error()})
+	default:
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error(message)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+	}
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"portfolio-cms-server/utils"
+)
+
+// GetJWKS exposes the public token verification keys so other services can verify the issued tokens
+func GetJWKS(ginCtx *gin.Context) {
+	ginCtx.Header("Cache-Control", "public, max-age=300")
+	ginCtx.JSON(http.StatusOK, utils.GetJWKS())
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	validator "github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"portfolio-cms-server/internal/files"
+	"portfolio-cms-server/utils"
+	"strings"
+)
+
+// ServeMedia streams a stored file, used when the storage backend (the local filesystem) does not serve the files
+// publicly by itself. Only the uploaded media is served - the archived originals, trashed media, staged uploads,
+// cached transformations and other objects of the storage are not.
+func ServeMedia(ginCtx *gin.Context) {
+	key := strings.TrimPrefix(ginCtx.Param("key"), "/")
+	if !files.IsPublicMediaKey(key) {
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{})
+		return
+	}
+
+	content, info, err := utils.GetStorage().Get(key)
+	if err != nil {
+		if errors.Is(err, utils.ErrObjectNotFound) {
+			ginCtx.JSON(http.StatusNotFound, map[string]interface{}{})
+			return
+		}
+
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on reading media file %s", key)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	defer content.Close()
+
+	ginCtx.Header("Cache-Control", "public, max-age=86400")
+	ginCtx.Header("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	// the SVGs are sanitized on upload, the policy also keeps the ones opened directly from running scripts
+	if info.ContentType == "image/svg+xml" {
+		ginCtx.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:")
+	}
+	ginCtx.DataFromReader(http.StatusOK, info.Size, info.ContentType, content, nil)
+}
+
+// TransformImage serves a signed transformation of a stored image. The transformations are immutable - the same
+// signed URL always yields the same content - so they are cached publicly for a year.
+func TransformImage(ginCtx *gin.Context) {
+	key := strings.TrimPrefix(ginCtx.Param("key"), "/")
+	query := files.ImageTransformQuery{}
+
+	if err := ginCtx.ShouldBindQuery(&query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(query); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	transformed, err := files.TransformImage(key, query.ImageTransformOptions, query.Signature)
+	switch {
+	case errors.Is(err, files.ErrInvalidTransform):
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	case errors.Is(err, files.ErrInvalidTransformSign):
+		ginCtx.JSON(http.StatusForbidden, map[string]interface{}{"error": err.Error()})
+		return
+	case errors.Is(err, files.ErrTransformsDisabled), errors.Is(err, utils.ErrObjectNotFound):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{})
+		return
+	case err != nil:
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on transforming the image %s", key)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+
+	ginCtx.Header("Cache-Control", "public, max-age=31536000, immutable")
+	ginCtx.Header("ETag", transformed.ETag)
+	if ginCtx.GetHeader("If-None-Match") == transformed.ETag {
+		ginCtx.Status(http.StatusNotModified)
+		return
+	}
+	ginCtx.Data(http.StatusOK, transformed.ContentType, transformed.Content)
+}
+
+// RedirectToCV redirects to the current version of the CV variant (the default one without a variant), in the
+// language of the lang query parameter if there is one. The redirect is not cached, so it follows the rollbacks.
+func RedirectToCV(ginCtx *gin.Context) {
+	variant := ginCtx.Param("variant")
+	if len(variant) == 0 {
+		variant = files.DefaultCVVariant
+	}
+
+	cvURL, err := files.CurrentCVURL(variant, ginCtx.Query("lang"))
+	switch {
+	case errors.Is(err, files.ErrInvalidCVVariant):
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	case errors.Is(err, files.ErrCVNotFound):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{})
+		return
+	case err != nil:
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Errorf("Error on getting the current CV of the variant %s", variant)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+
+	ginCtx.Header("Cache-Control", "no-cache")
+	ginCtx.Redirect(http.StatusFound, cvURL)
+}
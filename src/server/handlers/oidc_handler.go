@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"portfolio-cms-server/internal/auth"
+	"portfolio-cms-server/utils"
+)
+
+// oidcLoginCookie binds the pending OpenID Connect login to the browser which started it, so a callback carrying
+// the state of a login started elsewhere is rejected
+const oidcLoginCookie = "oidc_login"
+
+func OIDCLogin(ginCtx *gin.Context) {
+	authorizationURL, loginID, err := auth.BeginOIDCLogin()
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCNotConfigured) {
+			ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": err.Error()})
+			return
+		}
+
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on starting an OpenID Connect login")
+
+		ginCtx.JSON(http.StatusBadGateway, map[string]interface{}{})
+		return
+	}
+
+	// Lax, as the provider redirects back to the callback from its own site
+	ginCtx.SetSameSite(http.SameSiteLaxMode)
+	ginCtx.SetCookie(oidcLoginCookie, loginID, int(auth.OIDCLoginLifetime.Seconds()), "/auth/oidc", "", true, true)
+	ginCtx.Redirect(http.StatusFound, authorizationURL)
+}
+
+func OIDCCallback(ginCtx *gin.Context, db *geoip2.Reader) {
+	if providerError := ginCtx.Query("error"); len(providerError) > 0 {
+		ginCtx.JSON(http.StatusUnauthorized, map[string]interface{}{"error": providerError})
+		return
+	}
+
+	state, code := ginCtx.Query("state"), ginCtx.Query("code")
+	if len(state) == 0 || len(code) == 0 {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters, expected state and code"})
+		return
+	}
+
+	loginID, err := ginCtx.Cookie(oidcLoginCookie)
+	if err != nil {
+		ginCtx.JSON(http.StatusUnauthorized, map[string]interface{}{"error": auth.ErrOIDCInvalidState.Error()})
+		return
+	}
+	ginCtx.SetSameSite(http.SameSiteLaxMode)
+	ginCtx.SetCookie(oidcLoginCookie, "", -1, "/auth/oidc", "", true, true)
+
+	authToken, err := auth.CompleteOIDCLogin(db, ginCtx, loginID, state, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrOIDCNotConfigured):
+			ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": err.Error()})
+		case errors.Is(err, auth.ErrOIDCInvalidState), errors.Is(err, auth.ErrOIDCIdentityNotAllowed):
+			ginCtx.JSON(http.StatusUnauthorized, map[string]interface{}{"error": err.Error()})
+		default:
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": err.Error()}).
+				Error("Error on completing an OpenID Connect login")
+
+			ginCtx.JSON(http.StatusUnauthorized, map[string]interface{}{"error": "OpenID Connect login failed"})
+		}
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"token": authToken})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	validator "github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"portfolio-cms-server/internal/files"
+	"strconv"
+	"strings"
+)
+
+func CreateTusUpload(ginCtx *gin.Context) {
+	length, err := strconv.ParseInt(ginCtx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	metadata, err := files.ParseTusMetadata(ginCtx.GetHeader("Upload-Metadata"))
+	if err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	request := files.TusCreationRequest{
+		Length:       length,
+		Metadata:     ginCtx.GetHeader("Upload-Metadata"),
+		Target:       metadata["target"],
+		ProjectTitle: metadata["projectTitle"],
+		CompanyName:  metadata["companyName"],
+		Title:        metadata["title"],
+		FileName:     metadata["filename"],
+	}
+	if _, err = validator.ValidateStruct(request); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	upload, err := files.CreateTusUpload(request)
+	if err != nil {
+		respondWithUploadError(ginCtx, err, "Error on attempting to create a resumable upload")
+		return
+	}
+
+	ginCtx.Header("Location", strings.TrimSuffix(ginCtx.Request.URL.Path, "/")+"/"+upload.ID)
+	setTusUploadHeaders(ginCtx, upload)
+	ginCtx.Status(http.StatusCreated)
+}
+
+func GetTusUploadOffset(ginCtx *gin.Context) {
+	upload, err := files.GetTusUpload(ginCtx.Param("id"))
+	if err != nil {
+		respondWithUploadError(ginCtx, err, "Error on attempting to get a resumable upload")
+		return
+	}
+
+	setTusUploadHeaders(ginCtx, upload)
+	ginCtx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) > 0 {
+		ginCtx.Header("Upload-Metadata", upload.Metadata)
+	}
+	ginCtx.Header("Cache-Control", "no-store")
+	ginCtx.Status(http.StatusOK)
+}
+
+func PatchTusUpload(ginCtx *gin.Context) {
+	if ginCtx.ContentType() != "application/offset+octet-stream" {
+		ginCtx.JSON(http.StatusUnsupportedMediaType, map[string]interface{}{"message": "expected application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(ginCtx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid parameters"})
+		return
+	}
+
+	upload, _, _, err := files.WriteTusChunk(ginCtx.Param("id"), offset, ginCtx.Request.Body)
+	if len(upload.ID) > 0 {
+		setTusUploadHeaders(ginCtx, upload)
+	}
+	if err != nil {
+		respondWithUploadError(ginCtx, err, "Error on attempting to write a resumable upload")
+		return
+	}
+	ginCtx.Status(http.StatusNoContent)
+}
+
+func TerminateTusUpload(ginCtx *gin.Context) {
+	if err := files.TerminateTusUpload(ginCtx.Param("id")); err != nil {
+		respondWithUploadError(ginCtx, err, "Error on attempting to terminate a resumable upload")
+		return
+	}
+	ginCtx.Status(http.StatusNoContent)
+}
+
+func setTusUploadHeaders(ginCtx *gin.Context, upload files.TusUpload) {
+	ginCtx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ginCtx.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	validator "github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"portfolio-cms-server/internal/auth"
+	"portfolio-cms-server/utils"
+)
+
+func BeginWebAuthnRegistration(ginCtx *gin.Context) {
+	options, err := auth.BeginWebAuthnRegistration()
+	if err != nil {
+		respondWithWebAuthnError(ginCtx, err, http.StatusBadRequest, "Error on starting a passkey registration")
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"publicKey": options})
+}
+
+func FinishWebAuthnRegistration(ginCtx *gin.Context) {
+	requestBody := auth.WebAuthnRegistrationRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	credential, err := auth.FinishWebAuthnRegistration(requestBody)
+	if err != nil {
+		respondWithWebAuthnError(ginCtx, err, http.StatusBadRequest, "Error on finishing a passkey registration")
+		return
+	}
+	ginCtx.JSON(http.StatusCreated, credential)
+}
+
+func BeginWebAuthnLogin(ginCtx *gin.Context) {
+	options, err := auth.BeginWebAuthnLogin()
+	if err != nil {
+		respondWithWebAuthnError(ginCtx, err, http.StatusUnauthorized, "Error on starting a passkey login")
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"publicKey": options})
+}
+
+func FinishWebAuthnLogin(ginCtx *gin.Context, db *geoip2.Reader) {
+	requestBody := auth.WebAuthnAssertionRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	authToken, err := auth.FinishWebAuthnLogin(db, ginCtx, requestBody)
+	if err != nil {
+		respondWithWebAuthnError(ginCtx, err, http.StatusUnauthorized, "Error on finishing a passkey login")
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"token": authToken})
+}
+
+func GetWebAuthnCredentials(ginCtx *gin.Context) {
+	credentials, err := auth.GetWebAuthnCredentials()
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on getting passkeys from the database")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{"credentials": credentials})
+}
+
+func RenameWebAuthnCredential(ginCtx *gin.Context) {
+	requestBody := auth.WebAuthnRenameRequestBody{}
+
+	if err := ginCtx.ShouldBind(&requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	if _, err := validator.ValidateStruct(requestBody); err != nil {
+		ginCtx.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid parameters"})
+		return
+	}
+
+	credential, err := auth.RenameWebAuthnCredential(ginCtx.Param("id"), requestBody)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": "passkey not found"})
+			return
+		}
+
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to rename a passkey")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, credential)
+}
+
+func DeleteWebAuthnCredential(ginCtx *gin.Context) {
+	err := auth.DeleteWebAuthnCredential(ginCtx.Param("id"))
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": "passkey not found"})
+			return
+		}
+
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on attempting to delete a passkey")
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+	ginCtx.JSON(http.StatusOK, map[string]interface{}{})
+}
+
+func respondWithWebAuthnError(ginCtx *gin.Context, err error, verificationStatus int, logMessage string) {
+	switch {
+	case errors.Is(err, auth.ErrWebAuthnNotConfigured):
+		ginCtx.JSON(http.StatusNotFound, map[string]interface{}{"error": err.Error()})
+	case errors.Is(err, auth.ErrWebAuthnVerificationFailed):
+		ginCtx.JSON(verificationStatus, map[string]interface{}{"error": err.Error()})
+	default:
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error(logMessage)
+
+		ginCtx.JSON(http.StatusInternalServerError, map[string]interface{}{})
+	}
+}
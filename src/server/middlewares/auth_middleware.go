@@ -1,14 +1,24 @@
 package middlewares
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 	"net/http"
+	"portfolio-cms-server/internal/auth"
 	"portfolio-cms-server/utils"
 )
 
-// AuthMiddleware checks for valid x-authorization access token in the request headers
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware checks for valid x-authorization access token in the request headers and that the session the
+// token was issued for is still active. Machine clients can authenticate with an x-api-key header instead, but
+// only on endpoints that declare the scopes they require and only if the API key is granted all of them.
+func AuthMiddleware(requiredScopes ...string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if apiKey := ctx.GetHeader("X-API-Key"); len(apiKey) > 0 {
+			authenticateAPIKey(ctx, apiKey, requiredScopes)
+			return
+		}
+
 		if len(ctx.Request.Header["X-Authorization"]) == 0 || len(ctx.Request.Header["X-Authorization"][0]) == 0 {
 			ctx.AbortWithStatus(http.StatusUnauthorized)
 			return
@@ -27,6 +37,59 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if len(claims.ID) == 0 {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{"message": "Session expired or revoked"})
+			return
+		}
+		err = auth.TouchSession(claims.ID)
+		if errors.Is(err, auth.ErrSessionInactive) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{"message": "Session expired or revoked"})
+			return
+		}
+		if err != nil {
+			utils.
+				GetLogger().
+				WithFields(log.Fields{"error": err.Error()}).
+				Error("Error on checking the session")
+
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, map[string]interface{}{})
+			return
+		}
+
+		ctx.Set("sessionID", claims.ID)
 		ctx.Next()
 	}
 }
+
+func authenticateAPIKey(ctx *gin.Context, apiKey string, requiredScopes []string) {
+	if len(requiredScopes) == 0 {
+		ctx.AbortWithStatusJSON(
+			http.StatusForbidden,
+			map[string]interface{}{"message": "API keys are not accepted on this endpoint"},
+		)
+		return
+	}
+
+	key, err := auth.AuthenticateAPIKey(apiKey)
+	if errors.Is(err, auth.ErrAPIKeyInvalid) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{"message": "Invalid API key"})
+		return
+	}
+	if err != nil {
+		utils.
+			GetLogger().
+			WithFields(log.Fields{"error": err.Error()}).
+			Error("Error on authenticating the API key")
+
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, map[string]interface{}{})
+		return
+	}
+
+	if !key.HasScopes(requiredScopes...) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{"message": "Invalid permissions"})
+		return
+	}
+
+	ctx.Set("apiKeyID", key.ID)
+	ctx.Next()
+}
@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"portfolio-cms-server/internal/files"
+	"strconv"
+	"strings"
+)
+
+const tusPathPrefix = "/files/tus"
+
+// TusResumableMiddleware rejects the tus requests of other protocol versions with 412 and adds the protocol
+// version to the responses
+func TusResumableMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Writer.Header().Set("Tus-Resumable", files.TusVersion)
+
+		if ctx.GetHeader("Tus-Resumable") != files.TusVersion {
+			ctx.Writer.Header().Set("Tus-Version", files.TusVersion)
+			ctx.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// setTusDiscoveryHeaders describes the tus server in the responses to the OPTIONS requests
+func setTusDiscoveryHeaders(ctx *gin.Context) {
+	if !strings.HasPrefix(ctx.Request.URL.Path, tusPathPrefix) {
+		return
+	}
+
+	ctx.Writer.Header().Set("Tus-Resumable", files.TusVersion)
+	ctx.Writer.Header().Set("Tus-Version", files.TusVersion)
+	ctx.Writer.Header().Set("Tus-Extension", files.TusExtensions)
+	ctx.Writer.Header().Set("Tus-Max-Size", strconv.FormatInt(files.TusMaxSize(), 10))
+}
@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"portfolio-cms-server/internal/files"
+)
+
+// multipartOverhead is the allowance for the multipart boundaries and the other form fields on top of the file
+const multipartOverhead = 1 << 20
+
+// UploadValidationMiddleware checks the form data file with the given name against the size limit and the allowed
+// formats of the upload type. The content type is sniffed from the file bytes and replaces the one sent by the
+// client, so the handlers can rely on it. Oversized files are rejected with 413 and other formats with 415.
+func UploadValidationMiddleware(fieldName, uploadType string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, files.MaxUploadSize(uploadType)+multipartOverhead)
+
+		file, err := ctx.FormFile(fieldName)
+		if err != nil {
+			maxBytesError := &http.MaxBytesError{}
+			if errors.As(err, &maxBytesError) {
+				ctx.AbortWithStatusJSON(
+					http.StatusRequestEntityTooLarge,
+					map[string]interface{}{
+						"message": fmt.Sprintf("the file exceeds the maximum size of %d bytes", files.MaxUploadSize(uploadType)),
+					},
+				)
+				return
+			}
+
+			ctx.AbortWithStatusJSON(
+				http.StatusBadRequest,
+				map[string]interface{}{"message": fmt.Sprintf("the provided file should be with the name '%s'.", fieldName)},
+			)
+			return
+		}
+
+		contentType, err := files.ValidateUpload(file, uploadType)
+		switch {
+		case errors.Is(err, files.ErrFileTooLarge):
+			ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, map[string]interface{}{"message": err.Error()})
+			return
+		case errors.Is(err, files.ErrUnsupportedMediaType):
+			ctx.AbortWithStatusJSON(http.StatusUnsupportedMediaType, map[string]interface{}{"message": err.Error()})
+			return
+		case err != nil:
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]interface{}{"message": "the provided file could not be read"})
+			return
+		}
+
+		file.Header.Set("Content-Type", contentType)
+		ctx.Next()
+	}
+}
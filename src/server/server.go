@@ -5,43 +5,93 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/oschwald/geoip2-golang"
 	log "github.com/sirupsen/logrus"
+	"portfolio-cms-server/internal/auth"
+	"portfolio-cms-server/internal/files"
 	"portfolio-cms-server/server/handlers"
 	"portfolio-cms-server/server/middlewares"
 	"portfolio-cms-server/utils"
 )
 
-var geoKey string
+const maxMultipartMemory = 8 << 20
+
+var (
+	geoKey string
+	// serveLocalMedia serves the media of the local storage, the S3 buckets serve their media themselves
+	serveLocalMedia bool
+)
 
 func setupRouter(db *geoip2.Reader) (router *gin.Engine) {
 	gin.SetMode(gin.ReleaseMode)
 	router = gin.New()
+	// the uploaded files above the limit are buffered in temporary files instead of the memory
+	router.MaxMultipartMemory = maxMultipartMemory
 
 	router.Use(middlewares.Logger(utils.GetLogger()), gin.Recovery())
 	router.Use(middlewares.CORS())
 
 	router.GET("/healths", handlers.HealthCheck)
 	router.GET("/metrics", handlers.Metrics)
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
+	if serveLocalMedia {
+		router.GET("/media/*key", handlers.ServeMedia)
+	}
+	router.GET("/img/*key", handlers.TransformImage)
+	router.GET("/cv", handlers.RedirectToCV)
+	router.GET("/cv/:variant", handlers.RedirectToCV)
 	router.GET("/users/basic-info", handlers.GetBasicInfo)
-	router.PUT("/users/basic-info", middlewares.AuthMiddleware(), handlers.UpdateBasicInfo)
+	router.PUT("/users/basic-info", middlewares.AuthMiddleware(auth.ScopeContentWrite), handlers.UpdateBasicInfo)
 	router.GET("/users/skills", handlers.GetSkills)
-	router.PUT("/users/skills", middlewares.AuthMiddleware(), handlers.UpdateSkills)
+	router.PUT("/users/skills", middlewares.AuthMiddleware(auth.ScopeContentWrite), handlers.UpdateSkills)
 	router.GET("/users/jobs-and-projects", handlers.GetJobsAndProjects)
-	router.PUT("/users/jobs-and-projects", middlewares.AuthMiddleware(), handlers.UpdateJobsAndProjects)
+	router.PUT("/users/jobs-and-projects", middlewares.AuthMiddleware(auth.ScopeContentWrite), handlers.UpdateJobsAndProjects)
 	router.GET("/users/socials", handlers.GetSocials)
-	router.PUT("/users/socials", middlewares.AuthMiddleware(), handlers.UpdateSocials)
-	router.POST("/auth/login", handlers.Login)
+	router.PUT("/users/socials", middlewares.AuthMiddleware(auth.ScopeContentWrite), handlers.UpdateSocials)
+	router.POST("/auth/login", func(ginCtx *gin.Context) {
+		handlers.Login(ginCtx, db)
+	})
+	router.GET("/auth/oidc/login", handlers.OIDCLogin)
+	router.GET("/auth/oidc/callback", func(ginCtx *gin.Context) {
+		handlers.OIDCCallback(ginCtx, db)
+	})
+	router.POST("/auth/webauthn/login/begin", handlers.BeginWebAuthnLogin)
+	router.POST("/auth/webauthn/login/finish", func(ginCtx *gin.Context) {
+		handlers.FinishWebAuthnLogin(ginCtx, db)
+	})
 	router.POST("/analytics/track", func(ginCtx *gin.Context) {
 		handlers.Track(ginCtx, db)
 	})
 
 	fileAuthGroup := router.Group("/files")
-	fileAuthGroup.Use(middlewares.AuthMiddleware())
+	fileAuthGroup.Use(middlewares.AuthMiddleware(auth.ScopeFilesWrite))
 	{
-		fileAuthGroup.POST("/cv", handlers.UploadCV)
+		fileAuthGroup.GET("", handlers.GetMediaLibrary)
+		fileAuthGroup.GET("/orphans", handlers.GetOrphanedMedia)
+		fileAuthGroup.DELETE("/orphans", handlers.DeleteOrphanedMedia)
+		fileAuthGroup.GET("/trash", handlers.GetTrash)
+		fileAuthGroup.POST("/trash/:id/restore", handlers.RestoreTrashedMedia)
+		fileAuthGroup.DELETE("/trash/:id", handlers.PurgeTrashedMedia)
+		fileAuthGroup.POST("/cv", middlewares.UploadValidationMiddleware("file", files.UploadTypeCV), handlers.UploadCV)
+		fileAuthGroup.GET("/cv", handlers.GetCVVersions)
+		fileAuthGroup.POST("/cv/:id/current", handlers.SetCurrentCVVersion)
 		fileAuthGroup.DELETE("/image", handlers.DeleteImage)
+		fileAuthGroup.PUT("/image/metadata", handlers.UpdateImageMetadata)
+		fileAuthGroup.GET("/image/transform", handlers.SignImageTransform)
+		fileAuthGroup.POST("/batch", handlers.UploadImageBatch)
+		fileAuthGroup.POST("/attachment", middlewares.UploadValidationMiddleware("file", files.UploadTypeAttachment), handlers.UploadAttachment)
+		fileAuthGroup.POST("/presign", handlers.PresignUpload)
+		fileAuthGroup.POST("/complete", handlers.CompleteUpload)
+
+		tusGroup := fileAuthGroup.Group("/tus")
+		tusGroup.Use(middlewares.TusResumableMiddleware())
+		{
+			tusGroup.POST("", handlers.CreateTusUpload)
+			tusGroup.HEAD("/:id", handlers.GetTusUploadOffset)
+			tusGroup.PATCH("/:id", handlers.PatchTusUpload)
+			tusGroup.DELETE("/:id", handlers.TerminateTusUpload)
+		}
 
 		imageGroup := fileAuthGroup.Group("")
-		imageGroup.Use(middlewares.ImageContentTypeMiddleware())
+		imageGroup.Use(middlewares.UploadValidationMiddleware("image", files.UploadTypeImage))
 		{
 			imageGroup.POST("/project-image", handlers.UploadProjectImage)
 			imageGroup.POST("/job-image", handlers.UploadJobImage)
@@ -51,7 +101,7 @@ func setupRouter(db *geoip2.Reader) (router *gin.Engine) {
 	}
 
 	analyticsAuthGroup := router.Group("/analytics")
-	analyticsAuthGroup.Use(middlewares.AuthMiddleware())
+	analyticsAuthGroup.Use(middlewares.AuthMiddleware(auth.ScopeAnalyticsRead))
 	{
 		analyticsAuthGroup.GET("", handlers.GetAnalytics)
 		analyticsAuthGroup.GET("/count", handlers.CountAnalytics)
@@ -59,6 +109,31 @@ func setupRouter(db *geoip2.Reader) (router *gin.Engine) {
 		analyticsAuthGroup.GET("/device", handlers.GetAnalyticsByDevice)
 		analyticsAuthGroup.GET("/browser", handlers.GetAnalyticsByBrowser)
 	}
+
+	sessionsAuthGroup := router.Group("/auth/sessions")
+	sessionsAuthGroup.Use(middlewares.AuthMiddleware())
+	{
+		sessionsAuthGroup.GET("", handlers.GetSessions)
+		sessionsAuthGroup.DELETE("/:id", handlers.RevokeSession)
+	}
+
+	apiKeysAuthGroup := router.Group("/auth/api-keys")
+	apiKeysAuthGroup.Use(middlewares.AuthMiddleware())
+	{
+		apiKeysAuthGroup.GET("", handlers.GetAPIKeys)
+		apiKeysAuthGroup.POST("", handlers.CreateAPIKey)
+		apiKeysAuthGroup.DELETE("/:id", handlers.RevokeAPIKey)
+	}
+
+	webAuthnAuthGroup := router.Group("/auth/webauthn")
+	webAuthnAuthGroup.Use(middlewares.AuthMiddleware())
+	{
+		webAuthnAuthGroup.POST("/register/begin", handlers.BeginWebAuthnRegistration)
+		webAuthnAuthGroup.POST("/register/finish", handlers.FinishWebAuthnRegistration)
+		webAuthnAuthGroup.GET("/credentials", handlers.GetWebAuthnCredentials)
+		webAuthnAuthGroup.PATCH("/credentials/:id", handlers.RenameWebAuthnCredential)
+		webAuthnAuthGroup.DELETE("/credentials/:id", handlers.DeleteWebAuthnCredential)
+	}
 	return
 }
 
@@ -68,11 +143,11 @@ func Run() {
 
 	db, err := geoip2.Open(geoFilePath)
 	if err != nil {
-		utils.GetLogger().WithFields(log.Fields{"warn": err.Error()}).Warn("geoip2 service file was not found locally, downloading from s3")
+		utils.GetLogger().WithFields(log.Fields{"warn": err.Error()}).Warn("geoip2 service file was not found locally, downloading from the storage")
 
-		err = utils.DownloadFromS3(geoKey, geoFilePath)
+		err = utils.DownloadFromStorage(geoKey, geoFilePath)
 		if err != nil {
-			utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Failed to download geoip2 service file from s3")
+			utils.GetLogger().WithFields(log.Fields{"error": err.Error()}).Error("Failed to download geoip2 service file from the storage")
 		}
 
 		db, err = geoip2.Open(geoFilePath)
@@ -96,3 +171,8 @@ func Run() {
 func SetGeoFileKey(key string) {
 	geoKey = key
 }
+
+// SetServeLocalMedia enables serving the media of the local storage backend
+func SetServeLocalMedia(enabled bool) {
+	serveLocalMedia = enabled
+}
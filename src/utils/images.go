@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// ResizeImage scales the image to the given dimensions. Every destination pixel is the area weighted average of
+// the source pixels it covers, which keeps downscaled photos free of aliasing without any native dependencies.
+// The colours are averaged premultiplied so transparent edges do not darken.
+func ResizeImage(source image.Image, width, height int) *image.RGBA {
+	bounds := source.Bounds()
+	sourceImage := ToRGBA(source)
+
+	horizontal := resampleRows(sourceImage.Pix, sourceImage.Stride, bounds.Dx(), bounds.Dy(), width)
+	vertical := resampleColumns(horizontal, width, bounds.Dy(), height)
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	for index, value := range vertical {
+		resized.Pix[index] = uint8(math.Min(255, math.Max(0, math.Round(float64(value)))))
+	}
+	return resized
+}
+
+// ToRGBA converts the image to RGBA with the origin at zero, the conversion is skipped if the image already is.
+// Converting the decoded image once saves the repeated conversions when it is resized multiple times.
+func ToRGBA(source image.Image) *image.RGBA {
+	bounds := source.Bounds()
+	if rgba, ok := source.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), source, bounds.Min, draw.Src)
+	return rgba
+}
+
+// ScaledHeight keeps the aspect ratio of the image when it is resized to the given width
+func ScaledHeight(width, height, targetWidth int) int {
+	return int(math.Max(1, math.Round(float64(height)*float64(targetWidth)/float64(width))))
+}
+
+type resampleWeight struct {
+	index  int
+	weight float32
+}
+
+// resampleWeights calculates for every destination position the source positions it covers and their share
+func resampleWeights(sourceSize, destinationSize int) [][]resampleWeight {
+	scale := float64(sourceSize) / float64(destinationSize)
+	weights := make([][]resampleWeight, destinationSize)
+
+	for position := range weights {
+		start, end := float64(position)*scale, float64(position+1)*scale
+		if scale < 1 {
+			// upscaling - interpolate between the two nearest source pixels instead of averaging an area
+			center := (float64(position)+0.5)*scale - 0.5
+			left := int(math.Floor(center))
+			fraction := float32(center - float64(left))
+			weights[position] = []resampleWeight{
+				{index: clamp(left, sourceSize), weight: 1 - fraction},
+				{index: clamp(left+1, sourceSize), weight: fraction},
+			}
+			continue
+		}
+
+		for index := int(start); index < int(math.Ceil(end)) && index < sourceSize; index++ {
+			coverage := math.Min(end, float64(index+1)) - math.Max(start, float64(index))
+			if coverage > 0 {
+				weights[position] = append(weights[position], resampleWeight{index: index, weight: float32(coverage / scale)})
+			}
+		}
+	}
+	return weights
+}
+
+func resampleRows(pixels []uint8, stride, sourceWidth, sourceHeight, width int) []float32 {
+	weights := resampleWeights(sourceWidth, width)
+	resampled := make([]float32, width*sourceHeight*4)
+
+	for y := 0; y < sourceHeight; y++ {
+		row := pixels[y*stride:]
+		for x, pixelWeights := range weights {
+			offset := (y*width + x) * 4
+			for _, weight := range pixelWeights {
+				for channel := 0; channel < 4; channel++ {
+					resampled[offset+channel] += float32(row[weight.index*4+channel]) * weight.weight
+				}
+			}
+		}
+	}
+	return resampled
+}
+
+func resampleColumns(pixels []float32, width, sourceHeight, height int) []float32 {
+	weights := resampleWeights(sourceHeight, height)
+	resampled := make([]float32, width*height*4)
+
+	for y, rowWeights := range weights {
+		for x := 0; x < width; x++ {
+			offset := (y*width + x) * 4
+			for _, weight := range rowWeights {
+				sourceOffset := (weight.index*width + x) * 4
+				for channel := 0; channel < 4; channel++ {
+					resampled[offset+channel] += pixels[sourceOffset+channel] * weight.weight
+				}
+			}
+		}
+	}
+	return resampled
+}
+
+func clamp(index, size int) int {
+	if index < 0 {
+		return 0
+	}
+	if index >= size {
+		return size - 1
+	}
+	return index
+}
+
+// OrientImage applies the EXIF orientation (1-8) to the image, so it is displayed upright without the metadata.
+// The result is always converted to RGBA.
+func OrientImage(source image.Image, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return ToRGBA(source)
+	}
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// orientations 5-8 swap the axes
+	destinationWidth, destinationHeight := width, height
+	if orientation >= 5 {
+		destinationWidth, destinationHeight = height, width
+	}
+	oriented := image.NewRGBA(image.Rect(0, 0, destinationWidth, destinationHeight))
+
+	for y := 0; y < destinationHeight; y++ {
+		for x := 0; x < destinationWidth; x++ {
+			var sourceX, sourceY int
+			switch orientation {
+			case 2:
+				sourceX, sourceY = width-1-x, y
+			case 3:
+				sourceX, sourceY = width-1-x, height-1-y
+			case 4:
+				sourceX, sourceY = x, height-1-y
+			case 5:
+				sourceX, sourceY = y, x
+			case 6:
+				sourceX, sourceY = y, height-1-x
+			case 7:
+				sourceX, sourceY = width-1-y, height-1-x
+			case 8:
+				sourceX, sourceY = width-1-y, x
+			}
+			oriented.Set(x, y, source.At(bounds.Min.X+sourceX, bounds.Min.Y+sourceY))
+		}
+	}
+	return oriented
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrientImage(t *testing.T) {
+	// a 2x3 image with the pixel values encoding their coordinates
+	source := image.NewGray(image.Rect(0, 0, 2, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			source.SetGray(x, y, color.Gray{Y: uint8(10*y + x)})
+		}
+	}
+
+	testCases := []struct {
+		orientation int
+		width       int
+		// topRow holds the source coordinates (10*y + x) of the top row of the oriented image
+		topRow []uint8
+	}{
+		{orientation: 0, width: 2, topRow: []uint8{0, 1}},
+		{orientation: 1, width: 2, topRow: []uint8{0, 1}},
+		{orientation: 2, width: 2, topRow: []uint8{1, 0}},
+		{orientation: 3, width: 2, topRow: []uint8{21, 20}},
+		{orientation: 4, width: 2, topRow: []uint8{20, 21}},
+		{orientation: 5, width: 3, topRow: []uint8{0, 10, 20}},
+		{orientation: 6, width: 3, topRow: []uint8{20, 10, 0}},
+		{orientation: 7, width: 3, topRow: []uint8{21, 11, 1}},
+		{orientation: 8, width: 3, topRow: []uint8{1, 11, 21}},
+		{orientation: 9, width: 2, topRow: []uint8{0, 1}},
+	}
+
+	for _, testCase := range testCases {
+		oriented := OrientImage(source, testCase.orientation)
+		if bounds := oriented.Bounds(); bounds.Dx() != testCase.width || bounds.Dx()*bounds.Dy() != 6 {
+			t.Fatalf("orientation %d: expected the width %d, got %v", testCase.orientation, testCase.width, bounds)
+		}
+
+		for x, expected := range testCase.topRow {
+			if value := oriented.RGBAAt(x, 0).R; value != expected {
+				t.Fatalf("orientation %d: expected the pixel %d at %d, got %d", testCase.orientation, expected, x, value)
+			}
+		}
+	}
+}
@@ -11,18 +11,30 @@ type TokenClaims struct {
 	jwt.RegisteredClaims
 }
 
+// TokenLifetime is the validity period of the issued access tokens
+const TokenLifetime = 1 * time.Hour
+
 var tokenKey string
 
-// GenerateJWT generates a new JWT access token
-func GenerateJWT() (string, error) {
+// GenerateJWT generates a new JWT access token bound to the given session id (stored as the jti claim). The token
+// is signed with the active asymmetric key (and carries its kid in the header) if such is loaded, otherwise with
+// the HS256 secret.
+func GenerateJWT(sessionID string) (string, error) {
 	claims := TokenClaims{
 		"administrator",
 		jwt.RegisteredClaims{
+			ID:        sessionID,
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenLifetime)),
 		},
 	}
 
+	if activeSigningKey != nil {
+		accessToken := jwt.NewWithClaims(activeSigningKey.method, claims)
+		accessToken.Header["kid"] = activeSigningKey.id
+		return accessToken.SignedString(activeSigningKey.privateKey)
+	}
+
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return accessToken.SignedString([]byte(tokenKey))
 }
@@ -30,12 +42,7 @@ func GenerateJWT() (string, error) {
 // ParseJWT parses the JWT token, checking for correct signing method.
 // Returns isValid - boolean for the validation state of the token and claims data
 func ParseJWT(accessToken string) (claims *TokenClaims, isValid bool, err error) {
-	token, err := jwt.ParseWithClaims(accessToken, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(tokenKey), nil
-	})
+	token, err := jwt.ParseWithClaims(accessToken, &TokenClaims{}, resolveVerificationKey)
 
 	if err != nil {
 		return
@@ -49,3 +56,25 @@ func ParseJWT(accessToken string) (claims *TokenClaims, isValid bool, err error)
 func GetJWTKey(key string) {
 	tokenKey = key
 }
+
+// resolveVerificationKey picks the key by the kid header when asymmetric keys are loaded (HMAC tokens are no
+// longer accepted then) and falls back to the HS256 secret otherwise
+func resolveVerificationKey(token *jwt.Token) (interface{}, error) {
+	if len(verificationKeys) == 0 {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(tokenKey), nil
+	}
+
+	keyID, _ := token.Header["kid"].(string)
+	key, found := verificationKeys[keyID]
+	if !found {
+		return nil, fmt.Errorf("unknown signing key: %v", token.Header["kid"])
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.publicKey, nil
+}
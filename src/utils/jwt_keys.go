@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"strings"
+)
+
+type signingKey struct {
+	id         string
+	method     jwt.SigningMethod
+	privateKey crypto.PrivateKey
+	publicKey  crypto.PublicKey
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	Modulus   string `json:"n,omitempty"`
+	Exponent  string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	activeSigningKey *signingKey
+	verificationKeys = map[string]*signingKey{}
+)
+
+// LoadJWTSigningKeys loads the RSA and Ed25519 keys used for asymmetric token signing. The inline private key (if
+// set) or else the first private key file becomes the active signing key, the rest of the private keys and all
+// public keys are kept for verification only so tokens signed with a rotated key stay valid until they expire.
+// When no keys are provided the tokens keep being signed with the HS256 secret. The loaded keys replace the previous
+// ones only when all of them are loaded, an error keeps the previous keys.
+func LoadJWTSigningKeys(inlinePrivateKey string, privateKeyFiles, publicKeyFiles []string) error {
+	var privatePEMs []string
+
+	if len(strings.TrimSpace(inlinePrivateKey)) > 0 {
+		privatePEMs = append(privatePEMs, strings.ReplaceAll(inlinePrivateKey, `\n`, "\n"))
+	}
+
+	for _, path := range privateKeyFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read JWT private key %s - %s", path, err.Error())
+		}
+		privatePEMs = append(privatePEMs, string(content))
+	}
+
+	var loadedSigningKey *signingKey
+	loadedKeys := map[string]*signingKey{}
+
+	for _, privatePEM := range privatePEMs {
+		key, err := parsePrivateKey([]byte(privatePEM))
+		if err != nil {
+			return err
+		}
+		if loadedSigningKey == nil {
+			loadedSigningKey = key
+		}
+		loadedKeys[key.id] = key
+	}
+
+	for _, path := range publicKeyFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read JWT public key %s - %s", path, err.Error())
+		}
+		key, err := parsePublicKey(content)
+		if err != nil {
+			return err
+		}
+		loadedKeys[key.id] = key
+	}
+
+	activeSigningKey, verificationKeys = loadedSigningKey, loadedKeys
+	return nil
+}
+
+// GetJWKS returns the public parts of all verification keys in the JSON Web Key Set format
+func GetJWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range verificationKeys {
+		jwks.Keys = append(jwks.Keys, key.toJWK())
+	}
+	return jwks
+}
+
+func parsePrivateKey(content []byte) (*signingKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("failed to decode JWT private key, expected PEM format")
+	}
+
+	var (
+		privateKey interface{}
+		err        error
+	)
+	if block.Type == "RSA PRIVATE KEY" {
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT private key - %s", err.Error())
+	}
+
+	switch typedKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return newSigningKey(jwt.SigningMethodRS256, typedKey, &typedKey.PublicKey), nil
+	case ed25519.PrivateKey:
+		return newSigningKey(jwt.SigningMethodEdDSA, typedKey, typedKey.Public()), nil
+	}
+	return nil, errors.New("unsupported JWT private key type, expected RSA or Ed25519")
+}
+
+func parsePublicKey(content []byte) (*signingKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("failed to decode JWT public key, expected PEM format")
+	}
+
+	var (
+		publicKey interface{}
+		err       error
+	)
+	if block.Type == "RSA PUBLIC KEY" {
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key - %s", err.Error())
+	}
+
+	switch typedKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return newSigningKey(jwt.SigningMethodRS256, nil, typedKey), nil
+	case ed25519.PublicKey:
+		return newSigningKey(jwt.SigningMethodEdDSA, nil, typedKey), nil
+	}
+	return nil, errors.New("unsupported JWT public key type, expected RSA or Ed25519")
+}
+
+func newSigningKey(method jwt.SigningMethod, privateKey crypto.PrivateKey, publicKey crypto.PublicKey) *signingKey {
+	key := &signingKey{method: method, privateKey: privateKey, publicKey: publicKey}
+	key.id = key.thumbprint()
+	return key
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint which is used as a stable key id (kid)
+func (key *signingKey) thumbprint() string {
+	var canonical string
+
+	switch publicKey := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		canonical = fmt.Sprintf(
+			`{"e":"%s","kty":"RSA","n":"%s"}`,
+			encodeBigInt(big.NewInt(int64(publicKey.E))),
+			encodeBigInt(publicKey.N),
+		)
+	case ed25519.PublicKey:
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(publicKey))
+	}
+
+	hash := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (key *signingKey) toJWK() JWK {
+	jwk := JWK{KeyID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+
+	switch publicKey := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.Modulus = encodeBigInt(publicKey.N)
+		jwk.Exponent = encodeBigInt(big.NewInt(int64(publicKey.E)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+	return jwk
+}
+
+func encodeBigInt(number *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(number.Bytes())
+}
+
+// PublicKey converts a JSON Web Key (such as one published by an external identity provider) to a public key
+// that can be used for token signature verification
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		modulus, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		if err != nil {
+			return nil, err
+		}
+		exponent, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, found := curves[jwk.Curve]
+		if !found {
+			return nil, fmt.Errorf("unsupported JWK curve %s", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if jwk.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported JWK curve %s", jwk.Curve)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported JWK key type %s", jwk.KeyType)
+}
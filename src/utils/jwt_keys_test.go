@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	testCases := []struct {
+		name       string
+		jwk        JWK
+		thumbprint string
+	}{
+		{
+			// RFC 7638 section 3.1
+			name: "RSA",
+			jwk: JWK{
+				KeyType: "RSA",
+				Modulus: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiF" +
+					"V4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0" +
+					"zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csF" +
+					"Cur-kEgU8awapJzKnqDKgw",
+				Exponent: "AQAB",
+			},
+			thumbprint: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			// RFC 8037 appendix A.3
+			name:       "Ed25519",
+			jwk:        JWK{KeyType: "OKP", Curve: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+			thumbprint: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			publicKey, err := testCase.jwk.PublicKey()
+			if err != nil {
+				t.Fatalf("expected the JWK to be converted, got %s", err.Error())
+			}
+
+			key, err := parsePublicKey(encodePublicKeyPEM(t, publicKey))
+			if err != nil {
+				t.Fatalf("expected the public key to be parsed, got %s", err.Error())
+			}
+			if key.id != testCase.thumbprint {
+				t.Fatalf("expected the thumbprint %s, got %s", testCase.thumbprint, key.id)
+			}
+		})
+	}
+}
+
+func TestLoadJWTSigningKeysJWKS(t *testing.T) {
+	t.Cleanup(func() { activeSigningKey, verificationKeys = nil, map[string]*signingKey{} })
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	rsaFile := filepath.Join(directory, "rsa.pem")
+	edFile := filepath.Join(directory, "ed25519.pub.pem")
+	writeFile(t, rsaFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	writeFile(t, edFile, encodePublicKeyPEM(t, edPublicKey))
+
+	if err = LoadJWTSigningKeys("", []string{rsaFile}, []string{edFile}); err != nil {
+		t.Fatalf("expected the keys to be loaded, got %s", err.Error())
+	}
+	if activeSigningKey == nil || activeSigningKey.method.Alg() != "RS256" {
+		t.Fatal("expected the RSA private key to be the active signing key")
+	}
+
+	jwks := GetJWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected 2 keys in the JWKS, got %d", len(jwks.Keys))
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "sig" || len(jwk.KeyID) == 0 {
+			t.Fatalf("expected a signing key with a key id, got %+v", jwk)
+		}
+
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("expected the published key to be converted back, got %s", err.Error())
+		}
+		key, _ := parsePublicKey(encodePublicKeyPEM(t, publicKey))
+		if key.id != jwk.KeyID {
+			t.Fatalf("expected the key id %s to be the thumbprint %s", jwk.KeyID, key.id)
+		}
+
+		switch jwk.KeyType {
+		case "RSA":
+			if jwk.Algorithm != "RS256" || jwk.Exponent != "AQAB" || len(jwk.X) > 0 {
+				t.Fatalf("unexpected RSA JWK %+v", jwk)
+			}
+		case "OKP":
+			if jwk.Algorithm != "EdDSA" || jwk.Curve != "Ed25519" || len(jwk.Modulus) > 0 {
+				t.Fatalf("unexpected Ed25519 JWK %+v", jwk)
+			}
+		default:
+			t.Fatalf("unexpected key type %s", jwk.KeyType)
+		}
+	}
+}
+
+func TestLoadJWTSigningKeysKeepsPreviousKeysOnError(t *testing.T) {
+	t.Cleanup(func() { activeSigningKey, verificationKeys = nil, map[string]*signingKey{} })
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := t.TempDir()
+	validFile := filepath.Join(directory, "valid.pem")
+	invalidFile := filepath.Join(directory, "invalid.pem")
+	writeFile(t, validFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	writeFile(t, invalidFile, []byte("not a key"))
+
+	if err = LoadJWTSigningKeys("", []string{validFile}, nil); err != nil {
+		t.Fatal(err)
+	}
+	previousKey := activeSigningKey
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFile := filepath.Join(directory, "other.pem")
+	writeFile(t, otherFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(otherKey)}))
+
+	if err = LoadJWTSigningKeys("", []string{otherFile, invalidFile}, nil); err == nil {
+		t.Fatal("expected the invalid key file to fail the loading")
+	}
+	if activeSigningKey != previousKey || len(verificationKeys) != 1 {
+		t.Fatal("expected the previous keys to be kept after a failed loading")
+	}
+}
+
+func encodePublicKeyPEM(t *testing.T, publicKey interface{}) []byte {
+	encoded, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: encoded})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localPartPrefix names the part files in the storage root, the List skips them as the other temporary files
+const localPartPrefix = ".upload-part-"
+
+// CreateMultipartUpload generates the upload ID, the parts are stored as separate files until the upload is
+// completed
+func (local *localStorage) CreateMultipartUpload(key, _ string) (string, error) {
+	if _, err := local.path(key); err != nil {
+		return "", err
+	}
+
+	uploadID, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return uploadID.String(), nil
+}
+
+// UploadPart writes the part to its own file, replacing the previous upload of the part number
+func (local *localStorage) UploadPart(_, uploadID string, partNumber int, content io.ReadSeeker) error {
+	path, err := local.partPath(uploadID, partNumber)
+	if err != nil {
+		return err
+	}
+
+	temporaryFile, err := os.CreateTemp(local.rootPath, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	if _, err = io.Copy(temporaryFile, content); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+	if err = temporaryFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporaryFile.Name(), path)
+}
+
+// CompleteMultipartUpload concatenates the part files in order into the file of the key
+func (local *localStorage) CompleteMultipartUpload(key, uploadID string) (err error) {
+	partPaths, err := local.partPaths(uploadID)
+	if err != nil {
+		return
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(concatenateFiles(writer, partPaths))
+	}()
+
+	if err = local.Put(key, reader, ""); err != nil {
+		reader.CloseWithError(err)
+		return
+	}
+	return local.AbortMultipartUpload(key, uploadID)
+}
+
+// AbortMultipartUpload removes the part files
+func (local *localStorage) AbortMultipartUpload(_, uploadID string) error {
+	partPaths, err := local.partPaths(uploadID)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range partPaths {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (local *localStorage) partPath(uploadID string, partNumber int) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil || partNumber < 1 {
+		return "", fmt.Errorf("invalid multipart upload %q part %d", uploadID, partNumber)
+	}
+	return filepath.Join(local.rootPath, fmt.Sprintf("%s%s-%d", localPartPrefix, uploadID, partNumber)), nil
+}
+
+// partPaths lists the part files of the upload ordered by the part number
+func (local *localStorage) partPaths(uploadID string) ([]string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return nil, fmt.Errorf("invalid multipart upload %q", uploadID)
+	}
+
+	prefix := filepath.Join(local.rootPath, localPartPrefix+uploadID+"-")
+	paths, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	partNumber := func(path string) int {
+		number, _ := strconv.Atoi(strings.TrimPrefix(path, prefix))
+		return number
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return partNumber(paths[i]) < partNumber(paths[j])
+	})
+	return paths, nil
+}
+
+func concatenateFiles(destination io.Writer, paths []string) error {
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(destination, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localStorage struct {
+	rootPath string
+	baseURL  string
+}
+
+// CreateLocalStorage uses a directory on the local filesystem as the storage backend. The files are served by
+// the application itself under the given base URL, which makes it possible to run the server without AWS.
+func CreateLocalStorage(rootPath, baseURL string) error {
+	if err := os.MkdirAll(rootPath, 0o755); err != nil {
+		return fmt.Errorf("failed to create the local storage directory - %s", err.Error())
+	}
+
+	storage = &localStorage{rootPath: rootPath, baseURL: strings.TrimSuffix(baseURL, "/")}
+	return nil
+}
+
+// Put writes the content to a file, creating the parent directories if needed. The content type is not stored,
+// it is detected from the content when the file is read.
+func (local *localStorage) Put(key string, content io.Reader, _ string) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	temporaryFile, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	if _, err = io.Copy(temporaryFile, content); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+	if err = temporaryFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporaryFile.Name(), path)
+}
+
+// PutPrivate writes the content like Put, the media route serves only the uploaded media keys so the other keys
+// stay private
+func (local *localStorage) PutPrivate(key string, content io.Reader, contentType string) error {
+	return local.Put(key, content, contentType)
+}
+
+// Copy copies the file to the new key
+func (local *localStorage) Copy(from, to string) error {
+	content, info, err := local.Get(from)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return local.Put(to, content, info.ContentType)
+}
+
+// CopyPrivate copies the file like Copy
+func (local *localStorage) CopyPrivate(from, to string) error {
+	return local.Copy(from, to)
+}
+
+// Get opens the file for reading
+func (local *localStorage) Get(key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := local.Stat(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	path, _ := local.path(key)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ObjectInfo{}, mapFileError(err)
+	}
+	return file, info, nil
+}
+
+// Delete removes the file
+func (local *localStorage) Delete(key string) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the storage directory and returns the files which keys start with the prefix
+func (local *localStorage) List(prefix string) (objects []ObjectInfo, err error) {
+	err = filepath.WalkDir(local.rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(local.rootPath, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, ObjectInfo{Key: key, Size: fileInfo.Size(), LastModified: fileInfo.ModTime()})
+		return nil
+	})
+	return
+}
+
+// Stat gets the file size and modification time and detects the content type from the first bytes of the file
+func (local *localStorage) Stat(key string) (ObjectInfo, error) {
+	path, err := local.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return ObjectInfo{}, mapFileError(err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if fileInfo.IsDir() {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+
+	header := make([]byte, 512)
+	readBytes, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         fileInfo.Size(),
+		ContentType:  detectContentType(header[:readBytes]),
+		LastModified: fileInfo.ModTime(),
+	}, nil
+}
+
+// detectContentType sniffs the content type, the sniffing does not know the SVGs which start with the root element
+// (as the sanitized uploads do)
+func detectContentType(header []byte) string {
+	if bytes.HasPrefix(bytes.TrimLeft(header, " \t\r\n"), []byte("<svg")) {
+		return "image/svg+xml"
+	}
+	return http.DetectContentType(header)
+}
+
+// PublicURL builds the URL the application serves the file from
+func (local *localStorage) PublicURL(key string) string {
+	return local.baseURL + "/" + key
+}
+
+// KeyFromURL extracts the file key from a public URL. The spaces in the keys are stored as + in the URLs.
+func (local *localStorage) KeyFromURL(url string) (string, bool) {
+	if !strings.HasPrefix(url, local.baseURL+"/") {
+		return "", false
+	}
+	return strings.ReplaceAll(strings.TrimPrefix(url, local.baseURL+"/"), "+", " "), true
+}
+
+// path resolves the key to a path inside the storage directory, rejecting keys that escape it
+func (local *localStorage) path(key string) (string, error) {
+	cleanKey := filepath.Clean("/" + key)
+	if cleanKey == "/" {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(local.rootPath, filepath.FromSlash(cleanKey)), nil
+}
+
+func mapFileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// MemoryCache is a least recently used cache of byte contents, bounded by the total size of the contents
+type MemoryCache struct {
+	mutex    sync.Mutex
+	maxSize  int64
+	size     int64
+	recency  *list.List
+	elements map[string]*list.Element
+}
+
+type CachedContent struct {
+	Content     []byte
+	ContentType string
+	ETag        string
+}
+
+type memoryCacheEntry struct {
+	key     string
+	content CachedContent
+}
+
+// NewMemoryCache creates a cache holding at most maxSize bytes of contents
+func NewMemoryCache(maxSize int64) *MemoryCache {
+	return &MemoryCache{maxSize: maxSize, recency: list.New(), elements: map[string]*list.Element{}}
+}
+
+// Get gets the cached content and marks it as the most recently used
+func (cache *MemoryCache) Get(key string) (CachedContent, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, found := cache.elements[key]
+	if !found {
+		return CachedContent{}, false
+	}
+	cache.recency.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).content, true
+}
+
+// Set caches the content, evicting the least recently used contents until it fits. The contents larger than an
+// eighth of the cache are not cached, so a single large content does not flush the cache.
+func (cache *MemoryCache) Set(key string, content CachedContent) {
+	size := int64(len(content.Content))
+	if size > cache.maxSize/8 {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, found := cache.elements[key]; found {
+		cache.remove(element)
+	}
+	cache.elements[key] = cache.recency.PushFront(&memoryCacheEntry{key: key, content: content})
+	cache.size += size
+
+	for cache.size > cache.maxSize {
+		cache.remove(cache.recency.Back())
+	}
+}
+
+// DeletePrefix removes the contents which keys start with the given prefix
+func (cache *MemoryCache) DeletePrefix(prefix string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for key, element := range cache.elements {
+		if strings.HasPrefix(key, prefix) {
+			cache.remove(element)
+		}
+	}
+}
+
+func (cache *MemoryCache) remove(element *list.Element) {
+	entry := cache.recency.Remove(element).(*memoryCacheEntry)
+	delete(cache.elements, entry.key)
+	cache.size -= int64(len(entry.content.Content))
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryCache(80)
+	for _, key := range []string{"first", "second", "third", "fourth"} {
+		cache.Set(key, CachedContent{Content: bytes.Repeat([]byte("x"), 10)})
+	}
+	cache.Get("first")
+
+	cache.Set("fifth", CachedContent{Content: bytes.Repeat([]byte("x"), 10)})
+	cache.Set("sixth", CachedContent{Content: bytes.Repeat([]byte("x"), 10)})
+	cache.Set("seventh", CachedContent{Content: bytes.Repeat([]byte("x"), 10)})
+	cache.Set("eighth", CachedContent{Content: bytes.Repeat([]byte("x"), 10)})
+	cache.Set("ninth", CachedContent{Content: bytes.Repeat([]byte("x"), 10)})
+
+	if _, found := cache.Get("first"); !found {
+		t.Fatal("expected the recently used content to be kept")
+	}
+	if _, found := cache.Get("second"); found {
+		t.Fatal("expected the least recently used content to be evicted")
+	}
+	if cache.size > cache.maxSize {
+		t.Fatalf("expected at most %d cached bytes, got %d", cache.maxSize, cache.size)
+	}
+}
+
+func TestMemoryCacheSkipsLargeContents(t *testing.T) {
+	cache := NewMemoryCache(80)
+	cache.Set("large", CachedContent{Content: bytes.Repeat([]byte("x"), 11)})
+
+	if _, found := cache.Get("large"); found {
+		t.Fatal("expected the content larger than an eighth of the cache not to be cached")
+	}
+}
+
+func TestMemoryCacheDeletePrefix(t *testing.T) {
+	cache := NewMemoryCache(1 << 10)
+	cache.Set("transforms/a.png/1", CachedContent{Content: []byte("a")})
+	cache.Set("transforms/a.png/2", CachedContent{Content: []byte("a")})
+	cache.Set("transforms/b.png/1", CachedContent{Content: []byte("b")})
+
+	cache.DeletePrefix("transforms/a.png/")
+
+	if _, found := cache.Get("transforms/a.png/1"); found {
+		t.Fatal("expected the contents with the prefix to be deleted")
+	}
+	if _, found := cache.Get("transforms/b.png/1"); !found || cache.size != 1 {
+		t.Fatalf("expected only the other contents to be kept, %d bytes cached", cache.size)
+	}
+}
@@ -3,13 +3,14 @@ package utils
 import (
 	"bytes"
 	"context"
-	"errors"
+	"crypto/tls"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 type s3Instance struct {
@@ -29,16 +31,72 @@ type s3Instance struct {
 	secretKey    string
 	ACL          string
 	client       *s3.S3
+	uploader     *s3manager.Uploader
 }
 
+const (
+	s3UploadTimeout     = 15 * time.Minute
+	s3UploadPartSize    = 5 << 20
+	s3UploadConcurrency = 2
+)
+
 var awsS3 *s3Instance
 
-// CreateS3Session creates a single s3 session once that can be reused across the application
-func CreateS3Session(bucketName, bucketKey, bucketURL, s3Region, accessKey, secretKey, ACL string) {
+// S3EndpointOptions configures a custom S3 compatible endpoint. The TLS settings are meant for self-hosted
+// stand-ins with self-signed certificates.
+type S3EndpointOptions struct {
+	Endpoint           string
+	ForcePathStyle     bool
+	InsecureSkipVerify bool
+	CABundleFile       string
+}
+
+// CreateS3Session creates a single s3 session once that can be reused across the application and uses it as
+// the storage backend. The endpoint options allow pointing it to S3 compatible services such as MinIO or R2.
+// When no static access keys are provided the credentials are resolved through the standard AWS chain
+// (environment, shared config, container and instance roles).
+func CreateS3Session(bucketName, bucketKey, bucketURL, s3Region, accessKey, secretKey, ACL string, options S3EndpointOptions) (err error) {
 	var once sync.Once
 	if awsS3 == nil {
 		once.Do(
 			func() {
+				awsConfig := &aws.Config{
+					Region:           aws.String(s3Region),
+					S3ForcePathStyle: aws.Bool(options.ForcePathStyle),
+				}
+
+				if len(accessKey) > 0 && len(secretKey) > 0 {
+					awsConfig.Credentials = credentials.NewStaticCredentials(accessKey, secretKey, "")
+				}
+
+				if len(options.Endpoint) > 0 {
+					awsConfig.Endpoint = aws.String(options.Endpoint)
+				}
+
+				if options.InsecureSkipVerify {
+					transport := http.DefaultTransport.(*http.Transport).Clone()
+					transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // opt-in for local stand-ins
+					awsConfig.HTTPClient = &http.Client{Transport: transport}
+				}
+
+				sessionOptions := session.Options{Config: *awsConfig}
+
+				// the configured CA bundle takes precedence over the AWS_CA_BUNDLE environment variable
+				if len(options.CABundleFile) > 0 {
+					var caBundle *os.File
+					if caBundle, err = os.Open(options.CABundleFile); err != nil {
+						err = fmt.Errorf("failed to open the s3 CA bundle - %s", err.Error())
+						return
+					}
+					defer caBundle.Close()
+					sessionOptions.CustomCABundle = caBundle
+				}
+
+				var s3Session *session.Session
+				if s3Session, err = session.NewSessionWithOptions(sessionOptions); err != nil {
+					return
+				}
+
 				awsS3 = &s3Instance{
 					s3BucketName: bucketName,
 					s3BucketKey:  bucketKey,
@@ -47,110 +105,218 @@ func CreateS3Session(bucketName, bucketKey, bucketURL, s3Region, accessKey, secr
 					accessKeyId:  accessKey,
 					secretKey:    secretKey,
 					ACL:          ACL,
+					client:       s3.New(s3Session),
 				}
-				s3Session := session.Must(session.NewSession(&aws.Config{
-					Credentials: credentials.NewStaticCredentials(
-						accessKey,
-						secretKey,
-						"",
-					),
-					Region: aws.String(s3Region),
-				}))
-				awsS3.client = s3.New(s3Session)
+				awsS3.uploader = s3manager.NewUploaderWithClient(awsS3.client, func(uploader *s3manager.Uploader) {
+					uploader.PartSize = s3UploadPartSize
+					uploader.Concurrency = s3UploadConcurrency
+				})
+				storage = awsS3
 			},
 		)
 	}
+	return
 }
 
 // UploadToS3 uploads a file to the s3 bucket with the passed file name (file key) and content type
 func UploadToS3(file *bytes.Reader, fileKey, contentType string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-	defer cancel()
+	return awsS3.Put(fileKey, file, contentType)
+}
 
-	bucketKey := awsS3.s3BucketKey + "/" + fileKey
+// DownloadFromS3 downloads a file from the s3 bucket with the passed file name (file key)
+func DownloadFromS3(fileKey, localPath string) (err error) {
+	return downloadToFile(awsS3, fileKey, localPath)
+}
+
+// DeleteFromS3 deletes a file from the s3 bucket with the passed file name (as a full URL from the DB)
+func DeleteFromS3(fileName string) error {
+	key, found := awsS3.KeyFromURL(fileName)
+	if !found {
+		return fmt.Errorf("failed to delete the object from s3 - %s is not a bucket URL", fileName)
+	}
+	return awsS3.Delete(key)
+}
+
+// GetTheFullS3BucketURL retrieves the base full s3 bucket URL
+func GetTheFullS3BucketURL() string {
+	return awsS3.s3BucketURL
+}
 
-	_, err := awsS3.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(awsS3.s3BucketName),
-		Key:         aws.String(bucketKey),
-		ACL:         aws.String(awsS3.ACL),
-		Body:        file,
+// Put streams the content to the s3 bucket under the bucket key. The content smaller than a part is uploaded
+// with a single request, the larger content is uploaded in parts so at most two parts are held in memory.
+func (instance *s3Instance) Put(key string, content io.Reader, contentType string) error {
+	return instance.upload(key, content, contentType, aws.String(instance.ACL))
+}
+
+// PutPrivate streams the content to the s3 bucket like Put, but without the configured ACL, so the object gets
+// the private default of the bucket
+func (instance *s3Instance) PutPrivate(key string, content io.Reader, contentType string) error {
+	return instance.upload(key, content, contentType, nil)
+}
+
+func (instance *s3Instance) upload(key string, content io.Reader, contentType string, ACL *string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+
+	_, err := instance.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(instance.s3BucketName),
+		Key:         aws.String(instance.bucketKey(key)),
+		ACL:         ACL,
+		Body:        content,
 		ContentType: aws.String(contentType),
 	})
+	return mapS3Error(err, "upload the object to")
+}
 
-	if err != nil {
-		if uploadError, ok := err.(awserr.Error); ok && uploadError.Code() == request.CanceledErrorCode {
-			return errors.New("upload canceled due to a timeout")
-		}
-		return fmt.Errorf("failed to upload the object to s3 - %s", err.Error())
-	}
+// Copy copies the object within the s3 bucket with the configured ACL, keeping its content type. The objects up
+// to 5GB are copied with a single request.
+func (instance *s3Instance) Copy(from, to string) error {
+	return instance.copy(from, to, aws.String(instance.ACL))
+}
 
-	return nil
+// CopyPrivate copies the object like Copy, but without the configured ACL
+func (instance *s3Instance) CopyPrivate(from, to string) error {
+	return instance.copy(from, to, nil)
 }
 
-// DownloadFromS3 downloads a file from the s3 bucket with the passed file name (file key)
-func DownloadFromS3(fileKey, localPath string) (err error) {
+func (instance *s3Instance) copy(from, to string, ACL *string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
-	bucketKey := awsS3.s3BucketKey + "/" + fileKey
+	copySource := (&url.URL{Path: instance.s3BucketName + "/" + instance.bucketKey(from)}).EscapedPath()
+	_, err := instance.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(instance.s3BucketName),
+		Key:        aws.String(instance.bucketKey(to)),
+		CopySource: aws.String(copySource),
+		ACL:        ACL,
+	})
+	return mapS3Error(err, "copy the object in")
+}
+
+// Get downloads the object from the s3 bucket. The download is bound to a one minute timeout.
+func (instance *s3Instance) Get(key string) (io.ReadCloser, ObjectInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 
-	response, err := awsS3.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(awsS3.s3BucketName),
-		Key:    aws.String(bucketKey),
+	response, err := instance.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(instance.s3BucketName),
+		Key:    aws.String(instance.bucketKey(key)),
 	})
 	if err != nil {
-		return
+		cancel()
+		return nil, ObjectInfo{}, mapS3Error(err, "download the object from")
 	}
-	defer response.Body.Close()
 
-	if err != nil {
-		if downloadError, ok := err.(awserr.Error); ok && downloadError.Code() == request.CanceledErrorCode {
-			return errors.New("upload canceled due to a timeout")
-		}
-		return fmt.Errorf("failed to download object from s3 - %s", err.Error())
+	info := ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(response.ContentLength),
+		ContentType:  aws.StringValue(response.ContentType),
+		LastModified: aws.TimeValue(response.LastModified),
 	}
+	return &cancelOnCloseReader{ReadCloser: response.Body, cancel: cancel}, info, nil
+}
 
-	currentDir, err := os.Getwd()
-	if err != nil {
-		return
-	}
+// Delete deletes the object from the s3 bucket
+func (instance *s3Instance) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
 
-	fullLocalPath := filepath.Join(currentDir, localPath)
+	_, err := instance.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(instance.s3BucketName),
+		Key:    aws.String(instance.bucketKey(key)),
+	})
+	return mapS3Error(err, "delete the object from")
+}
 
-	file, err := os.Create(fullLocalPath)
-	if err != nil {
-		return
-	}
-	defer file.Close()
+// List lists all objects under the bucket key which keys start with the prefix
+func (instance *s3Instance) List(prefix string) (objects []ObjectInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
 
-	_, err = io.Copy(file, response.Body)
-	return
+	err = instance.client.ListObjectsV2PagesWithContext(
+		ctx,
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(instance.s3BucketName),
+			Prefix: aws.String(instance.bucketKey(prefix)),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				objects = append(objects, ObjectInfo{
+					Key:          strings.TrimPrefix(aws.StringValue(object.Key), instance.bucketKey("")),
+					Size:         aws.Int64Value(object.Size),
+					LastModified: aws.TimeValue(object.LastModified),
+				})
+			}
+			return true
+		},
+	)
+	return objects, mapS3Error(err, "list the objects in")
 }
 
-// DeleteFromS3 deletes a file from the s3 bucket with the passed file name (as a full URL from the DB)
-func DeleteFromS3(fileName string) error {
+// Stat gets the object metadata without downloading it
+func (instance *s3Instance) Stat(key string) (ObjectInfo, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
-	file := strings.Split(fileName, awsS3.s3BucketURL)[1]
-	key := awsS3.s3BucketKey + file
-
-	_, err := awsS3.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(awsS3.s3BucketName),
-		Key:    aws.String(key),
+	response, err := instance.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(instance.s3BucketName),
+		Key:    aws.String(instance.bucketKey(key)),
 	})
-
 	if err != nil {
-		if deleteError, ok := err.(awserr.Error); ok && deleteError.Code() == request.CanceledErrorCode {
-			return errors.New("upload canceled due to a timeout")
+		return ObjectInfo{}, mapS3Error(err, "get the object metadata from")
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(response.ContentLength),
+		ContentType:  aws.StringValue(response.ContentType),
+		LastModified: aws.TimeValue(response.LastModified),
+	}, nil
+}
+
+// PublicURL builds the public object URL from the bucket URL
+func (instance *s3Instance) PublicURL(key string) string {
+	return instance.s3BucketURL + "/" + key
+}
+
+// KeyFromURL extracts the object key from a public bucket URL. The spaces in the keys are stored as + in the URLs.
+func (instance *s3Instance) KeyFromURL(url string) (string, bool) {
+	if !strings.HasPrefix(url, instance.s3BucketURL+"/") {
+		return "", false
+	}
+	return strings.ReplaceAll(strings.TrimPrefix(url, instance.s3BucketURL+"/"), "+", " "), true
+}
+
+func (instance *s3Instance) bucketKey(key string) string {
+	return instance.s3BucketKey + "/" + key
+}
+
+func mapS3Error(err error, action string) error {
+	if err == nil {
+		return nil
+	}
+
+	if s3Error, ok := err.(awserr.Error); ok {
+		if s3Error.Code() == request.CanceledErrorCode {
+			return fmt.Errorf("failed to %s s3 - canceled due to a timeout", action)
+		}
+		if s3Error.Code() == s3.ErrCodeNoSuchKey || s3Error.Code() == "NotFound" {
+			return ErrObjectNotFound
 		}
-		return fmt.Errorf("failed to delete the object from s3 - %s", err.Error())
 	}
 
-	return nil
+	if requestError, ok := err.(awserr.RequestFailure); ok && requestError.StatusCode() == http.StatusNotFound {
+		return ErrObjectNotFound
+	}
+	return fmt.Errorf("failed to %s s3 - %s", action, err.Error())
 }
 
-// GetTheFullS3BucketURL retrieves the base full s3 bucket URL
-func GetTheFullS3BucketURL() string {
-	return awsS3.s3BucketURL
+// cancelOnCloseReader releases the request context once the caller is done reading the object
+type cancelOnCloseReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (reader *cancelOnCloseReader) Close() error {
+	defer reader.cancel()
+	return reader.ReadCloser.Close()
 }
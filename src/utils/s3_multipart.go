@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// CreateMultipartUpload starts a multipart upload to the key. The object is private (without the configured
+// ACL) as the multipart uploads are used for the staging objects.
+func (instance *s3Instance) CreateMultipartUpload(key, contentType string) (uploadID string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	response, err := instance.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(instance.s3BucketName),
+		Key:         aws.String(instance.bucketKey(key)),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", mapS3Error(err, "start the multipart upload to")
+	}
+	return aws.StringValue(response.UploadId), nil
+}
+
+// UploadPart uploads the part of the multipart upload
+func (instance *s3Instance) UploadPart(key, uploadID string, partNumber int, content io.ReadSeeker) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+
+	_, err := instance.client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(instance.s3BucketName),
+		Key:        aws.String(instance.bucketKey(key)),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(int64(partNumber)),
+		Body:       content,
+	})
+	return mapS3Error(err, "upload the part to")
+}
+
+// CompleteMultipartUpload lists the uploaded parts and assembles them into the object, so the part ETags do not
+// have to be kept by the caller between the requests
+func (instance *s3Instance) CompleteMultipartUpload(key, uploadID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+
+	var parts []*s3.CompletedPart
+	err := instance.client.ListPartsPagesWithContext(
+		ctx,
+		&s3.ListPartsInput{
+			Bucket:   aws.String(instance.s3BucketName),
+			Key:      aws.String(instance.bucketKey(key)),
+			UploadId: aws.String(uploadID),
+		},
+		func(page *s3.ListPartsOutput, lastPage bool) bool {
+			for _, part := range page.Parts {
+				parts = append(parts, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return mapS3Error(err, "list the uploaded parts in")
+	}
+
+	_, err = instance.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(instance.s3BucketName),
+		Key:             aws.String(instance.bucketKey(key)),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return mapS3Error(err, "complete the multipart upload to")
+}
+
+// AbortMultipartUpload aborts the multipart upload and frees the storage of its parts
+func (instance *s3Instance) AbortMultipartUpload(key, uploadID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	_, err := instance.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(instance.s3BucketName),
+		Key:      aws.String(instance.bucketKey(key)),
+		UploadId: aws.String(uploadID),
+	})
+	return mapS3Error(err, "abort the multipart upload to")
+}
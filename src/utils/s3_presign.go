@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/goccy/go-json"
+	"sort"
+	"time"
+)
+
+const presignAlgorithm = "AWS4-HMAC-SHA256"
+
+// PresignUpload signs a PUT request with the content type and length and a POST policy with the content type and
+// the length range. The object is uploaded without the public ACL, so it stays private until it is processed.
+func (instance *s3Instance) PresignUpload(
+	key, contentType string,
+	size, maxSize int64,
+	lifetime time.Duration,
+) (upload PresignedUpload, err error) {
+	request, _ := instance.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        aws.String(instance.s3BucketName),
+		Key:           aws.String(instance.bucketKey(key)),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	})
+
+	upload.URL, err = request.Presign(lifetime)
+	if err != nil {
+		return upload, mapS3Error(err, "presign the upload to")
+	}
+	upload.Headers = map[string]string{"Content-Type": contentType, "Content-Length": fmt.Sprint(size)}
+	upload.ExpiresAt = time.Now().Add(lifetime).UTC()
+
+	upload.Form, err = instance.presignPostPolicy(instance.bucketKey(key), contentType, maxSize, upload.ExpiresAt)
+	return
+}
+
+// presignPostPolicy builds a signature version 4 POST policy
+// (https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-post-example.html)
+func (instance *s3Instance) presignPostPolicy(
+	bucketKey, contentType string,
+	maxSize int64,
+	expiresAt time.Time,
+) (form PresignedForm, err error) {
+	credentials, err := instance.client.Config.Credentials.Get()
+	if err != nil {
+		return form, fmt.Errorf("failed to get the s3 credentials - %s", err.Error())
+	}
+
+	// the bucket URL respects the custom endpoint and the path-style addressing
+	bucketRequest, _ := instance.client.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(instance.s3BucketName)})
+	if err = bucketRequest.Build(); err != nil {
+		return form, mapS3Error(err, "presign the upload to")
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", credentials.AccessKeyID, date, instance.s3Region)
+
+	form.URL = bucketRequest.HTTPRequest.URL.String()
+	form.Fields = map[string]string{
+		"key":              bucketKey,
+		"Content-Type":     contentType,
+		"x-amz-algorithm":  presignAlgorithm,
+		"x-amz-credential": credential,
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if len(credentials.SessionToken) > 0 {
+		form.Fields["x-amz-security-token"] = credentials.SessionToken
+	}
+
+	conditions := []interface{}{
+		map[string]string{"bucket": instance.s3BucketName},
+		[]interface{}{"content-length-range", 1, maxSize},
+	}
+	fields := make([]string, 0, len(form.Fields))
+	for field := range form.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		conditions = append(conditions, []string{"eq", "$" + field, form.Fields[field]})
+	}
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": expiresAt.Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return
+	}
+	form.Fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+
+	signingKey := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, scope := range []string{date, instance.s3Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, scope)
+	}
+	form.Fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, form.Fields["policy"]))
+	return
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,445 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeS3Bucket    = "portfolio-media"
+	fakeS3BucketKey = "media"
+	fakeS3BucketURL = "https://cdn.example.com/media"
+)
+
+type fakeS3Object struct {
+	content     []byte
+	contentType string
+	acl         string
+	modifiedAt  time.Time
+}
+
+// fakeS3 is a minimal in-process S3 compatible stand-in which understands path-style object requests, multipart
+// uploads and ListObjectsV2. It ignores the request signatures.
+type fakeS3 struct {
+	objects map[string]fakeS3Object
+	uploads map[string]map[int][]byte
+	mutex   sync.Mutex
+}
+
+type fakeS3ListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string   `xml:"Name"`
+	Prefix      string   `xml:"Prefix"`
+	KeyCount    int      `xml:"KeyCount"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	Contents    []struct {
+		Key          string `xml:"Key"`
+		Size         int    `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+func (fake *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(request.URL.Path, "/"), "/")
+	if bucket != fakeS3Bucket {
+		fake.writeError(writer, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	query := request.URL.Query()
+
+	switch {
+	case request.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := strconv.Itoa(len(fake.uploads) + 1)
+		fake.uploads[uploadID] = map[int][]byte{}
+		writer.Header().Set("Content-Type", "application/xml")
+		_, _ = writer.Write([]byte("<InitiateMultipartUploadResult><Bucket>" + bucket + "</Bucket><Key>" + key +
+			"</Key><UploadId>" + uploadID + "</UploadId></InitiateMultipartUploadResult>"))
+	case request.Method == http.MethodPut && query.Has("uploadId"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		content, _ := io.ReadAll(request.Body)
+		fake.uploads[query.Get("uploadId")][partNumber] = content
+		writer.Header().Set("ETag", `"part-`+query.Get("partNumber")+`"`)
+		writer.WriteHeader(http.StatusOK)
+	case request.Method == http.MethodGet && query.Has("uploadId"):
+		fake.listParts(writer, query.Get("uploadId"))
+	case request.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(fake.uploads, query.Get("uploadId"))
+		writer.WriteHeader(http.StatusNoContent)
+	case request.Method == http.MethodPost && query.Has("uploadId"):
+		parts := fake.uploads[query.Get("uploadId")]
+		content := []byte{}
+		for partNumber := 1; partNumber <= len(parts); partNumber++ {
+			content = append(content, parts[partNumber]...)
+		}
+		fake.objects[key] = fakeS3Object{content: content, modifiedAt: time.Now().UTC()}
+		delete(fake.uploads, query.Get("uploadId"))
+		writer.Header().Set("Content-Type", "application/xml")
+		_, _ = writer.Write([]byte("<CompleteMultipartUploadResult><Key>" + key + "</Key></CompleteMultipartUploadResult>"))
+	case request.Method == http.MethodGet && len(key) == 0 && query.Get("list-type") == "2":
+		fake.list(writer, query.Get("prefix"))
+	case request.Method == http.MethodPut && len(request.Header.Get("X-Amz-Copy-Source")) > 0:
+		copySource, _ := url.PathUnescape(request.Header.Get("X-Amz-Copy-Source"))
+		sourceBucket, sourceKey, _ := strings.Cut(strings.TrimPrefix(copySource, "/"), "/")
+		source, found := fake.objects[sourceKey]
+		if sourceBucket != fakeS3Bucket || !found {
+			fake.writeError(writer, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		fake.objects[key] = fakeS3Object{
+			content:     source.content,
+			contentType: source.contentType,
+			acl:         request.Header.Get("X-Amz-Acl"),
+			modifiedAt:  time.Now().UTC(),
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		_, _ = writer.Write([]byte("<CopyObjectResult><ETag>\"copy\"</ETag></CopyObjectResult>"))
+	case request.Method == http.MethodPut:
+		content, err := io.ReadAll(request.Body)
+		if err != nil {
+			fake.writeError(writer, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		fake.objects[key] = fakeS3Object{
+			content:     content,
+			contentType: request.Header.Get("Content-Type"),
+			acl:         request.Header.Get("X-Amz-Acl"),
+			modifiedAt:  time.Now().UTC(),
+		}
+		writer.WriteHeader(http.StatusOK)
+	case request.Method == http.MethodGet || request.Method == http.MethodHead:
+		object, found := fake.objects[key]
+		if !found {
+			if request.Method == http.MethodHead {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fake.writeError(writer, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		writer.Header().Set("Content-Type", object.contentType)
+		writer.Header().Set("Content-Length", strconv.Itoa(len(object.content)))
+		writer.Header().Set("Last-Modified", object.modifiedAt.Format(http.TimeFormat))
+		writer.WriteHeader(http.StatusOK)
+		if request.Method == http.MethodGet {
+			_, _ = writer.Write(object.content)
+		}
+	case request.Method == http.MethodDelete:
+		delete(fake.objects, key)
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		fake.writeError(writer, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (fake *fakeS3) list(writer http.ResponseWriter, prefix string) {
+	result := fakeS3ListResult{Name: fakeS3Bucket, Prefix: prefix}
+
+	keys := make([]string, 0, len(fake.objects))
+	for key := range fake.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		object := fake.objects[key]
+		result.Contents = append(result.Contents, struct {
+			Key          string `xml:"Key"`
+			Size         int    `xml:"Size"`
+			LastModified string `xml:"LastModified"`
+		}{key, len(object.content), object.modifiedAt.Format(time.RFC3339)})
+	}
+	result.KeyCount = len(keys)
+
+	writer.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(writer).Encode(result)
+}
+
+func (fake *fakeS3) listParts(writer http.ResponseWriter, uploadID string) {
+	parts, found := fake.uploads[uploadID]
+	if !found {
+		fake.writeError(writer, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	partNumbers := make([]int, 0, len(parts))
+	for partNumber := range parts {
+		partNumbers = append(partNumbers, partNumber)
+	}
+	sort.Ints(partNumbers)
+
+	result := strings.Builder{}
+	result.WriteString("<ListPartsResult><UploadId>" + uploadID + "</UploadId>")
+	for _, partNumber := range partNumbers {
+		result.WriteString("<Part><PartNumber>" + strconv.Itoa(partNumber) + "</PartNumber><ETag>\"part-" +
+			strconv.Itoa(partNumber) + "\"</ETag><Size>" + strconv.Itoa(len(parts[partNumber])) + "</Size></Part>")
+	}
+	result.WriteString("</ListPartsResult>")
+
+	writer.Header().Set("Content-Type", "application/xml")
+	_, _ = writer.Write([]byte(result.String()))
+}
+
+func (fake *fakeS3) writeError(writer http.ResponseWriter, statusCode int, code string) {
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(statusCode)
+	_, _ = writer.Write([]byte("<Error><Code>" + code + "</Code><Message>" + code + "</Message></Error>"))
+}
+
+// setupFakeS3 starts the stand-in and points the s3 session at it with a custom path-style endpoint
+func setupFakeS3(t *testing.T, useTLS bool) *fakeS3 {
+	fake := &fakeS3{objects: map[string]fakeS3Object{}, uploads: map[string]map[int][]byte{}}
+
+	server := httptest.NewUnstartedServer(fake)
+	options := S3EndpointOptions{ForcePathStyle: true}
+	if useTLS {
+		server.StartTLS()
+
+		caBundleFile := filepath.Join(t.TempDir(), "ca.pem")
+		certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := os.WriteFile(caBundleFile, certificate, 0600); err != nil {
+			t.Fatal(err)
+		}
+		options.CABundleFile = caBundleFile
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	options.Endpoint = server.URL
+
+	awsS3, storage = nil, nil
+	t.Cleanup(func() { awsS3, storage = nil, nil })
+
+	err := CreateS3Session(fakeS3Bucket, fakeS3BucketKey, fakeS3BucketURL, "us-east-1", "test", "test", "public-read", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake
+}
+
+// relativeTempPath builds a temporary file path relative to the working directory as DownloadFromS3 expects
+func relativeTempPath(t *testing.T, name string) string {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relativePath, err := filepath.Rel(currentDir, filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return relativePath
+}
+
+func TestS3UploadDownloadDelete(t *testing.T) {
+	fake := setupFakeS3(t, false)
+	content := []byte("fake image content")
+
+	if err := UploadToS3(bytes.NewReader(content), "projects/cover image.png", "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, found := fake.objects[fakeS3BucketKey+"/projects/cover image.png"]
+	if !found || !bytes.Equal(stored.content, content) || stored.contentType != "image/png" {
+		t.Fatalf("expected the object to be stored under the bucket key, got %v", fake.objects)
+	}
+
+	localPath := relativeTempPath(t, "cover.png")
+	if err := DownloadFromS3("projects/cover image.png", localPath); err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, err := os.ReadFile(localPath)
+	if err != nil || !bytes.Equal(downloaded, content) {
+		t.Fatalf("expected the downloaded file to match the upload, got %q %v", downloaded, err)
+	}
+
+	if err = DeleteFromS3(fakeS3BucketURL + "/projects/cover+image.png"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects) != 0 {
+		t.Fatalf("expected the object to be deleted, got %v", fake.objects)
+	}
+}
+
+func TestS3PutPrivateOmitsTheACL(t *testing.T) {
+	fake := setupFakeS3(t, false)
+
+	if err := GetStorage().Put("project-a", bytes.NewReader([]byte("stripped")), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := GetStorage().PutPrivate("originals/project-a", bytes.NewReader([]byte("original")), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	if acl := fake.objects[fakeS3BucketKey+"/project-a"].acl; acl != "public-read" {
+		t.Fatalf("expected the public object to get the configured ACL, got %q", acl)
+	}
+	original, found := fake.objects[fakeS3BucketKey+"/originals/project-a"]
+	if !found || len(original.acl) > 0 {
+		t.Fatalf("expected the private object to be stored without an ACL, got %q", original.acl)
+	}
+}
+
+func TestS3CopyKeepsTheContentType(t *testing.T) {
+	fake := setupFakeS3(t, false)
+
+	if err := GetStorage().Put("project-cover image", bytes.NewReader([]byte("image")), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := GetStorage().CopyPrivate("project-cover image", "trash/1/project-cover image"); err != nil {
+		t.Fatal(err)
+	}
+	if err := GetStorage().Copy("trash/1/project-cover image", "project-restored"); err != nil {
+		t.Fatal(err)
+	}
+
+	trashed := fake.objects[fakeS3BucketKey+"/trash/1/project-cover image"]
+	if string(trashed.content) != "image" || trashed.contentType != "image/png" || len(trashed.acl) > 0 {
+		t.Fatalf("expected a private copy with the content type, got %+v", trashed)
+	}
+	restored := fake.objects[fakeS3BucketKey+"/project-restored"]
+	if string(restored.content) != "image" || restored.acl != "public-read" {
+		t.Fatalf("expected a public copy, got %+v", restored)
+	}
+
+	if err := GetStorage().Copy("project-missing", "project-copy"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound for a missing source, got %v", err)
+	}
+}
+
+func TestS3StreamsLargeObjectsInParts(t *testing.T) {
+	fake := setupFakeS3(t, false)
+	content := bytes.Repeat([]byte("0123456789abcdef"), (s3UploadPartSize*2+1024)/16)
+
+	// a reader without Seek, as the multipart file streamed through a pipe
+	if err := GetStorage().Put("projects/demo.mp4", io.MultiReader(bytes.NewReader(content)), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, found := fake.objects[fakeS3BucketKey+"/projects/demo.mp4"]
+	if !found || !bytes.Equal(stored.content, content) {
+		t.Fatalf("expected the parts to be assembled into the object, got %d bytes", len(stored.content))
+	}
+}
+
+func TestS3MultipartUploadAssemblesPartsInOrder(t *testing.T) {
+	fake := setupFakeS3(t, false)
+	uploader, ok := GetMultipartUploader()
+	if !ok {
+		t.Fatal("expected the s3 storage to support the multipart uploads")
+	}
+
+	uploadID, err := uploader.CreateMultipartUpload("uploads/demo", "application/octet-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstPart := bytes.Repeat([]byte("a"), MinMultipartPartSize)
+	for _, part := range []struct {
+		number  int
+		content []byte
+	}{{2, []byte("tail")}, {1, []byte("stale")}, {1, firstPart}} {
+		if err = uploader.UploadPart("uploads/demo", uploadID, part.number, bytes.NewReader(part.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = uploader.CompleteMultipartUpload("uploads/demo", uploadID); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := fake.objects[fakeS3BucketKey+"/uploads/demo"]
+	if !bytes.Equal(stored.content, append(firstPart, []byte("tail")...)) {
+		t.Fatalf("expected the re-uploaded first part followed by the tail, got %d bytes", len(stored.content))
+	}
+}
+
+func TestS3AbortMultipartUpload(t *testing.T) {
+	fake := setupFakeS3(t, false)
+	uploader, _ := GetMultipartUploader()
+
+	uploadID, err := uploader.CreateMultipartUpload("uploads/abandoned", "application/octet-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = uploader.UploadPart("uploads/abandoned", uploadID, 1, bytes.NewReader([]byte("part"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = uploader.AbortMultipartUpload("uploads/abandoned", uploadID); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.uploads) != 0 || len(fake.objects) != 0 {
+		t.Fatalf("expected the parts to be discarded, got %v uploads and %v objects", fake.uploads, fake.objects)
+	}
+}
+
+func TestS3DeleteRejectsForeignURL(t *testing.T) {
+	setupFakeS3(t, false)
+
+	if err := DeleteFromS3("https://elsewhere.example.com/projects/cover.png"); err == nil {
+		t.Fatal("expected a URL outside of the bucket to be rejected")
+	}
+}
+
+func TestS3MissingObjectIsNotFound(t *testing.T) {
+	setupFakeS3(t, false)
+
+	if err := DownloadFromS3("missing.png", relativeTempPath(t, "missing.png")); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound on download, got %v", err)
+	}
+	if _, err := GetStorage().Stat("missing.png"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound on stat, got %v", err)
+	}
+}
+
+func TestS3StatAndList(t *testing.T) {
+	setupFakeS3(t, false)
+
+	for _, key := range []string{"jobs/logo.png", "jobs/banner.jpg", "projects/cover.png"} {
+		if err := UploadToS3(bytes.NewReader([]byte(key)), key, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := GetStorage().Stat("jobs/logo.png")
+	if err != nil || info.Size != int64(len("jobs/logo.png")) || info.ContentType != "image/png" {
+		t.Fatalf("unexpected object metadata %v %v", info, err)
+	}
+
+	objects, err := GetStorage().List("jobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Key != "jobs/banner.jpg" || objects[1].Key != "jobs/logo.png" {
+		t.Fatalf("expected the two job objects without the bucket key, got %v", objects)
+	}
+}
+
+func TestS3CustomCABundle(t *testing.T) {
+	setupFakeS3(t, true)
+
+	if err := UploadToS3(bytes.NewReader([]byte("secure")), "cv.pdf", "application/pdf"); err != nil {
+		t.Fatalf("expected the upload to trust the CA bundle, got %v", err)
+	}
+}
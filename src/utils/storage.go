@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Storage is the media storage backend. Keys are relative to the configured bucket key (or the local storage
+// root directory) and public URLs are built from the configured base URL.
+type Storage interface {
+	// Put streams the content to the given key, overwriting any existing object
+	Put(key string, content io.Reader, contentType string) error
+	// PutPrivate streams the content to the given key like Put, but without the public access of the served
+	// objects - for the archived originals which keep their metadata and the other internal objects
+	PutPrivate(key string, content io.Reader, contentType string) error
+	// Copy copies the object to the given key within the storage, without downloading it. The copy gets the
+	// public access of the served objects.
+	Copy(from, to string) error
+	// CopyPrivate copies the object like Copy, but without the public access
+	CopyPrivate(from, to string) error
+	// Get opens the object for reading, the caller should close it
+	Get(key string) (io.ReadCloser, ObjectInfo, error)
+	// Delete removes the object, deleting a missing object is not an error
+	Delete(key string) error
+	// List returns all objects which keys start with the given prefix
+	List(prefix string) ([]ObjectInfo, error)
+	// Stat returns the object metadata or ErrObjectNotFound
+	Stat(key string) (ObjectInfo, error)
+	// PublicURL builds the URL the object is publicly served from
+	PublicURL(key string) string
+	// KeyFromURL extracts the object key from a public URL, reports false if the URL is not served by the storage
+	KeyFromURL(url string) (string, bool)
+}
+
+// ErrObjectNotFound is returned when the requested object does not exist in the storage
+var ErrObjectNotFound = errors.New("object not found")
+
+var storage Storage
+
+// GetStorage gets the configured storage backend
+func GetStorage() Storage {
+	return storage
+}
+
+// DownloadFromStorage downloads a file from the configured storage with the passed file key to the local path
+// (relative to the working directory)
+func DownloadFromStorage(fileKey, localPath string) error {
+	return downloadToFile(storage, fileKey, localPath)
+}
+
+func downloadToFile(source Storage, fileKey, localPath string) (err error) {
+	content, _, err := source.Get(fileKey)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	fullLocalPath := filepath.Join(currentDir, localPath)
+
+	file, err := os.Create(fullLocalPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, content)
+	return
+}
+
+// PresignedUpload lets a client upload a file directly to the storage for a limited time, either with a PUT
+// request to the URL (sending the headers) or with a form POST
+type PresignedUpload struct {
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	Form      PresignedForm     `json:"form"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+type PresignedForm struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+// DirectUploader is implemented by the storage backends which accept uploads directly from the clients
+type DirectUploader interface {
+	// PresignUpload signs an upload of exactly the given size and content type to the key. The form upload
+	// accepts any size up to the maximum.
+	PresignUpload(key, contentType string, size, maxSize int64, lifetime time.Duration) (PresignedUpload, error)
+}
+
+// GetDirectUploader gets the configured storage backend if it accepts the direct uploads
+func GetDirectUploader() (DirectUploader, bool) {
+	uploader, ok := storage.(DirectUploader)
+	return uploader, ok
+}
+
+// MinMultipartPartSize is the smallest part the multipart uploads accept, except for the last part
+const MinMultipartPartSize = 5 << 20
+
+// MultipartUploader is implemented by the storage backends which assemble an object from parts uploaded in
+// separate requests. The parts are numbered from 1, uploading a part number again replaces it.
+type MultipartUploader interface {
+	// CreateMultipartUpload starts a private multipart upload to the key
+	CreateMultipartUpload(key, contentType string) (uploadID string, err error)
+	// UploadPart stores the part, all parts except the last have to be at least MinMultipartPartSize
+	UploadPart(key, uploadID string, partNumber int, content io.ReadSeeker) error
+	// CompleteMultipartUpload assembles the uploaded parts in order into the object
+	CompleteMultipartUpload(key, uploadID string) error
+	// AbortMultipartUpload discards the uploaded parts
+	AbortMultipartUpload(key, uploadID string) error
+}
+
+// GetMultipartUploader gets the configured storage backend if it supports the multipart uploads
+func GetMultipartUploader() (MultipartUploader, bool) {
+	uploader, ok := storage.(MultipartUploader)
+	return uploader, ok
+}